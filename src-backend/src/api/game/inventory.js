@@ -0,0 +1,37 @@
+import request from '@/utils/request'
+
+// 查询玩家背包列表
+export function listInventory(query) {
+  return request({
+    url: '/api/admin/game/inventory/page',
+    method: 'get',
+    params: query
+  })
+}
+
+// 发放物品
+export function grantItems(data) {
+  return request({
+    url: '/api/admin/game/inventory/grant',
+    method: 'post',
+    data: data
+  })
+}
+
+// 扣除物品
+export function consumeItems(data) {
+  return request({
+    url: '/api/admin/game/inventory/consume',
+    method: 'post',
+    data: data
+  })
+}
+
+// 查询物品记录列表
+export function listItemLog(query) {
+  return request({
+    url: '/api/admin/game/itemLog/page',
+    method: 'get',
+    params: query
+  })
+}
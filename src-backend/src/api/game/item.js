@@ -0,0 +1,71 @@
+import request from '@/utils/request'
+
+// 查询物品简单列表
+export function listItemSimple() {
+  return request({
+    url: '/api/admin/game/item/listSimple',
+    method: 'get'
+  })
+}
+
+// 查询物品列表
+export function listItem(query) {
+  return request({
+    url: '/api/admin/game/item/page',
+    method: 'get',
+    params: query
+  })
+}
+
+// 查询物品详细
+export function getItem(id) {
+  return request({
+    url: '/api/admin/game/item/get',
+    method: 'get',
+    params: {
+      id
+    }
+  })
+}
+
+// 新增物品
+export function addItem(data) {
+  return request({
+    url: '/api/admin/game/item/insert',
+    method: 'post',
+    data: data
+  })
+}
+
+// 修改物品
+export function updateItem(data) {
+  return request({
+    url: '/api/admin/game/item/update',
+    method: 'put',
+    data: data
+  })
+}
+
+// 物品状态修改
+export function changeItemStatus(id, status) {
+  const data = {
+    id,
+    status
+  }
+  return request({
+    url: '/api/admin/game/item/status',
+    method: 'put',
+    data: data
+  })
+}
+
+// 删除物品
+export function delItem(id) {
+  return request({
+    url: '/api/admin/game/item/delete',
+    method: 'delete',
+    params: {
+      id
+    }
+  })
+}
@@ -0,0 +1,41 @@
+import request from '@/utils/request'
+
+// 查询玩家存档列表
+export function listPlayerData(query) {
+  return request({
+    url: '/api/admin/game/data/page',
+    method: 'get',
+    params: query
+  })
+}
+
+// 查询玩家存档详细
+export function getPlayerData(id) {
+  return request({
+    url: '/api/admin/game/data/get',
+    method: 'get',
+    params: {
+      id
+    }
+  })
+}
+
+// 修改玩家存档
+export function updatePlayerData(data) {
+  return request({
+    url: '/api/admin/game/data/update',
+    method: 'put',
+    data: data
+  })
+}
+
+// 删除玩家存档
+export function delPlayerData(id) {
+  return request({
+    url: '/api/admin/game/data/delete',
+    method: 'delete',
+    params: {
+      id
+    }
+  })
+}
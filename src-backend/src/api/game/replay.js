@@ -0,0 +1,33 @@
+import request from '@/utils/request'
+
+// 查询回放列表
+export function listReplay(query) {
+  return request({
+    url: '/api/admin/game/replay/page',
+    method: 'get',
+    params: query
+  })
+}
+
+// 下载回放
+export function downloadReplay(id) {
+  return request({
+    url: '/api/admin/game/replay/download',
+    method: 'get',
+    params: {
+      id
+    },
+    responseType: 'blob'
+  })
+}
+
+// 删除回放
+export function delReplay(id) {
+  return request({
+    url: '/api/admin/game/replay/delete',
+    method: 'delete',
+    params: {
+      id
+    }
+  })
+}
@@ -0,0 +1,36 @@
+import request from '@/utils/request'
+
+// 查询货币列表
+export function listCurrencies() {
+  return request({
+    url: '/api/admin/game/wallet/currencies',
+    method: 'get'
+  })
+}
+
+// 查询玩家钱包列表
+export function listWallet(query) {
+  return request({
+    url: '/api/admin/game/wallet/page',
+    method: 'get',
+    params: query
+  })
+}
+
+// 调整余额
+export function adjustWallet(data) {
+  return request({
+    url: '/api/admin/game/wallet/adjust',
+    method: 'post',
+    data: data
+  })
+}
+
+// 查询账本流水列表
+export function listLedger(query) {
+  return request({
+    url: '/api/admin/game/ledger/page',
+    method: 'get',
+    params: query
+  })
+}
@@ -71,8 +71,16 @@ func wrapperQuery(table string, params interface{}, db *gorm.DB) {
 		if v.Field(i).Interface() == nil {
 			continue
 		}
-		if reflect.TypeOf(v.Field(i).Interface()).Kind() == reflect.Struct {
-			wrapperQuery(table, v.Field(i).Interface(), db)
+		value := v.Field(i)
+		//指针为nil时忽略, 否则按指向的值处理
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		}
+		if value.Kind() == reflect.Struct {
+			wrapperQuery(table, value.Interface(), db)
 			continue
 		}
 		sf := t.Field(i)
@@ -80,19 +88,19 @@ func wrapperQuery(table string, params interface{}, db *gorm.DB) {
 			queryItems := strings.Split(strings.ToLower(query), ";")
 			//must 若不存在此标注, 则忽略空值(空字符串、数字0)
 			if helpers.IndexOf[string](queryItems, "must") < 0 {
-				if str, ok := v.Field(i).Interface().(string); ok && len(str) == 0 {
+				if str, ok := value.Interface().(string); ok && len(str) == 0 {
 					continue
 				}
-				if i64, ok := helpers.Int64(v.Field(i).Interface()); ok && i64 == 0 {
+				if i64, ok := helpers.Int64(value.Interface()); ok && i64 == 0 {
 					continue
 				}
 			}
 			//目前只简单处理列的名字
 			colName := db.Statement.NamingStrategy.ColumnName(table, sf.Name)
 			if helpers.IndexOf[string](queryItems, "like") >= 0 {
-				db.Where(colName+" like ?", fmt.Sprintf("%%%v%%", v.Field(i).Interface()))
+				db.Where(colName+" like ?", fmt.Sprintf("%%%v%%", value.Interface()))
 			} else if helpers.IndexOf[string](queryItems, "eq") >= 0 {
-				db.Where(colName+" = ?", v.Field(i).Interface())
+				db.Where(colName+" = ?", value.Interface())
 			}
 		}
 	}
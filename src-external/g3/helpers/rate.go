@@ -0,0 +1,52 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package helpers
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶限流, 每秒补充rate个令牌, 最多积攒burst个
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow 取一个令牌
+func (tb *TokenBucket) Allow() bool {
+	return tb.AllowN(time.Now(), 1)
+}
+
+// AllowN 在now时刻取n个令牌, 令牌不足时返回false且不消耗
+func (tb *TokenBucket) AllowN(now time.Time, n int) bool {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens += elapsed.Seconds() * tb.rate
+		if tb.tokens > tb.burst {
+			tb.tokens = tb.burst
+		}
+		tb.last = now
+	}
+	if tb.tokens < float64(n) {
+		return false
+	}
+	tb.tokens -= float64(n)
+	return true
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	type step struct {
+		after time.Duration
+		n     int
+		want  bool
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+	}{
+		{"burst then empty", 1, 3, []step{{0, 1, true}, {0, 1, true}, {0, 1, true}, {0, 1, false}}},
+		{"refill over time", 2, 2, []step{{0, 2, true}, {0, 1, false}, {500 * time.Millisecond, 1, true}, {0, 1, false}}},
+		{"refill capped at burst", 10, 2, []step{{0, 2, true}, {time.Minute, 3, false}, {0, 2, true}}},
+		{"insufficient does not consume", 1, 3, []step{{0, 4, false}, {0, 3, true}}},
+		{"burst below one", 1, 0, []step{{0, 1, true}, {0, 1, false}, {time.Second, 1, true}}},
+		{"clock going back", 1, 1, []step{{0, 1, true}, {-time.Second, 1, false}, {2 * time.Second, 1, true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := NewTokenBucket(tt.rate, tt.burst)
+			now := tb.last
+			for i, s := range tt.steps {
+				now = now.Add(s.after)
+				if got := tb.AllowN(now, s.n); got != s.want {
+					t.Fatalf("step %d: AllowN(%d) = %v, want %v", i, s.n, got, s.want)
+				}
+			}
+		})
+	}
+}
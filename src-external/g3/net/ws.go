@@ -3,6 +3,8 @@
 package net
 
 import (
+	"compress/flate"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
@@ -11,8 +13,11 @@ import (
 	"github.com/zhouhp1295/g3"
 	"github.com/zhouhp1295/g3/helpers"
 	"go.uber.org/zap"
+	stdnet "net"
 	"net/http"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,10 +28,33 @@ type WsConnStatus int
 const (
 	WsErrorFailed = -1
 	WsErrorOk     = 0
+	// 其它错误码与http状态码保持一致, 方便客户端统一处理
+	WsErrorBadRequest      = http.StatusBadRequest
+	WsErrorUnauthorized    = http.StatusUnauthorized
+	WsErrorForbidden       = http.StatusForbidden
+	WsErrorNotFound        = http.StatusNotFound
+	WsErrorConflict        = http.StatusConflict
+	WsErrorTooManyRequests = http.StatusTooManyRequests
+	WsErrorInternal        = http.StatusInternalServerError
+	WsErrorUnavailable     = http.StatusServiceUnavailable
 
 	WsErrorOKMsg = "OK"
 )
 
+// WsMessage 服务端下发的消息
+type WsMessage struct {
+	// Id 客户端请求时携带的id, 响应时原样返回
+	Id string `json:"id,omitempty"`
+	// Push 是否为服务端主动推送
+	Push bool `json:"push,omitempty"`
+	// Seq 开启会话恢复后推送的序号, 断线重连时客户端据此请求补发
+	Seq    uint64      `json:"seq,omitempty"`
+	Router string      `json:"router"`
+	Code   int         `json:"code"`
+	Msg    string      `json:"msg"`
+	Data   interface{} `json:"data"`
+}
+
 const (
 	WsConnecting WsConnStatus = iota
 	WsAuthing
@@ -34,14 +62,97 @@ const (
 	WsClosed
 )
 
+const (
+	// defaultWsWriteWait 写入超时时间
+	defaultWsWriteWait = 10 * time.Second
+	// defaultWsSendQueueSize 默认发送队列长度
+	defaultWsSendQueueSize = 256
+)
+
+// WsOverflowPolicy 发送队列已满时的处理策略
+type WsOverflowPolicy int
+
+const (
+	// WsOverflowDrop 丢弃新消息
+	WsOverflowDrop WsOverflowPolicy = iota
+	// WsOverflowClose 断开连接
+	WsOverflowClose
+)
+
+var (
+	ErrWsConnClosed    = errors.New("websocket connection closed")
+	ErrWsSendQueueFull = errors.New("websocket send queue full")
+)
+
+type wsFrame struct {
+	messageType int
+	data        []byte
+}
+
 type WsConn struct {
 	Uuid     string
+	Uid      int64
+	IP       string
 	Conn     *websocket.Conn
 	Query    map[string]string
 	Data     map[string]interface{}
 	CreateAt time.Time
 	Status   WsConnStatus
 	Mutex    sync.Mutex
+
+	groups map[string]bool
+	// groupsLeft 已退出所有分组, 之后不能再加入; 由 groupMutex 保护
+	groupsLeft bool
+	// unbound 已从用户索引中移除, 之后不能再绑定; 由 userMutex 保护
+	unbound bool
+
+	activeAt  int64
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	codec      WsCodec
+	send       chan wsFrame
+	sendMutex  sync.RWMutex
+	sendClosed bool
+	writerDone chan struct{}
+	overflow   WsOverflowPolicy
+
+	session      *WsSession
+	sessionMutex sync.RWMutex
+
+	// bucket 连接级消息限流, 仅在读协程中使用
+	bucket *helpers.TokenBucket
+
+	compressionThreshold int
+}
+
+// Session 连接当前的会话, 未开启会话恢复或未授权时为nil
+func (wc *WsConn) Session() *WsSession {
+	wc.sessionMutex.RLock()
+	defer wc.sessionMutex.RUnlock()
+	return wc.session
+}
+
+func (wc *WsConn) setSession(session *WsSession) {
+	wc.sessionMutex.Lock()
+	wc.session = session
+	wc.sessionMutex.Unlock()
+}
+
+// ActiveAt 最后一次收到客户端消息的时间
+func (wc *WsConn) ActiveAt() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&wc.activeAt))
+}
+
+func (wc *WsConn) touch() {
+	atomic.StoreInt64(&wc.activeAt, time.Now().UnixNano())
+}
+
+// markClosed 通知该连接的后台协程退出
+func (wc *WsConn) markClosed() {
+	wc.closeOnce.Do(func() {
+		close(wc.closed)
+	})
 }
 
 func (wc *WsConn) Failed(router string, msg string) {
@@ -52,20 +163,120 @@ func (wc *WsConn) Ok(router string, data interface{}) {
 	wc.WriteJSON(router, WsErrorOk, WsErrorOKMsg, data)
 }
 
+// Reply 响应客户端请求, id 为请求中携带的id
+func (wc *WsConn) Reply(id string, router string, data interface{}) {
+	wc.write(WsMessage{Id: id, Router: router, Code: WsErrorOk, Msg: WsErrorOKMsg, Data: data})
+}
+
+// Error 以指定错误码响应客户端请求
+func (wc *WsConn) Error(id string, router string, code int, msg string) {
+	wc.write(WsMessage{Id: id, Router: router, Code: code, Msg: msg, Data: gin.H{}})
+}
+
+// Push 服务端主动推送, 有会话时分配序号并缓存以便断线重连后补发
+func (wc *WsConn) Push(router string, data interface{}) error {
+	if session := wc.Session(); session != nil {
+		return session.push(wsPush(router, data))
+	}
+	return wc.Send(wsPush(router, data))
+}
+
+func wsPush(router string, data interface{}) WsMessage {
+	return WsMessage{Push: true, Router: router, Code: WsErrorOk, Msg: WsErrorOKMsg, Data: data}
+}
+
 func (wc *WsConn) WriteJSON(router string, code int, msg string, data interface{}) {
-	err := wc.Conn.WriteJSON(gin.H{
-		"router": router,
-		"code":   code,
-		"msg":    msg,
-		"data":   data,
-	})
+	wc.write(WsMessage{Router: router, Code: code, Msg: msg, Data: data})
+}
+
+func (wc *WsConn) write(message WsMessage) {
+	err := wc.Send(message)
 	if err != nil {
 		g3.ZL().Error("failed to write to websocket connection",
-			zap.String("router", router),
+			zap.String("uuid", wc.Uuid),
+			zap.String("router", message.Router),
 			zap.Error(err))
 	}
 }
 
+// Codec 该连接协商的编解码
+func (wc *WsConn) Codec() WsCodec {
+	if wc.codec == nil {
+		return JSONCodec{}
+	}
+	return wc.codec
+}
+
+// Send 序列化后放入发送队列, 由该连接的写协程统一写出, 可在任意协程中调用
+func (wc *WsConn) Send(v interface{}) error {
+	frame, err := newWsFrame(wc.Codec(), v)
+	if err != nil {
+		return err
+	}
+	return wc.enqueue(frame)
+}
+
+func newWsFrame(codec WsCodec, v interface{}) (wsFrame, error) {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return wsFrame{}, err
+	}
+	return wsFrame{messageType: codec.MessageType(), data: data}, nil
+}
+
+func (wc *WsConn) enqueue(frame wsFrame) error {
+	wc.sendMutex.RLock()
+	defer wc.sendMutex.RUnlock()
+	if wc.sendClosed || wc.send == nil {
+		return ErrWsConnClosed
+	}
+	select {
+	case wc.send <- frame:
+		return nil
+	default:
+	}
+	g3.ZL().Warn("websocket send queue full",
+		zap.String("uuid", wc.Uuid),
+		zap.Int("size", cap(wc.send)))
+	if WsOverflowClose == wc.overflow && wc.Conn != nil {
+		// 关闭底层连接后读协程会退出并走正常的清理流程
+		_ = wc.Conn.Close()
+	}
+	return ErrWsSendQueueFull
+}
+
+// closeSend 停止接收新消息, 写协程写完队列中剩余的消息后退出
+func (wc *WsConn) closeSend() {
+	wc.sendMutex.Lock()
+	if !wc.sendClosed && wc.send != nil {
+		wc.sendClosed = true
+		close(wc.send)
+	}
+	wc.sendMutex.Unlock()
+}
+
+// writePump 写协程, 保证同一连接上的帧按顺序且不并发地写出
+func (wc *WsConn) writePump() {
+	defer close(wc.writerDone)
+	for frame := range wc.send {
+		if wc.compressionThreshold > 0 {
+			// 未协商压缩时该设置无效
+			wc.Conn.EnableWriteCompression(len(frame.data) >= wc.compressionThreshold)
+		}
+		_ = wc.Conn.SetWriteDeadline(time.Now().Add(defaultWsWriteWait))
+		if err := wc.Conn.WriteMessage(frame.messageType, frame.data); err != nil {
+			g3.ZL().Info("write message failed",
+				zap.String("uuid", wc.Uuid),
+				zap.Error(err))
+			_ = wc.Conn.Close()
+			return
+		}
+	}
+	_ = wc.Conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(defaultWsWriteWait))
+}
+
 type WsWorker struct {
 	Router string
 
@@ -80,13 +291,115 @@ type WsWorker struct {
 
 	connections map[string]*WsConn
 	rwMutex     sync.RWMutex
+
+	groups     map[string]map[string]*WsConn
+	groupMutex sync.RWMutex
+
+	users       map[int64]map[string]*WsConn
+	userMutex   sync.RWMutex
+	loginPolicy WsLoginPolicy
+
+	pingInterval time.Duration
+	pongWait     time.Duration
+	idleTimeout  time.Duration
+
+	sendQueueSize  int
+	overflowPolicy WsOverflowPolicy
+
+	codecs       map[string]WsCodec
+	codecNames   []string
+	defaultCodec string
+
+	sessions          map[string]*WsSession
+	userSessions      map[int64]map[string]*WsSession
+	sessionMutex      sync.Mutex
+	sessionGrace      time.Duration
+	sessionBufferSize int
+	sessionStop       chan struct{}
+
+	maxConns        int
+	maxConnsPerIP   int
+	connCount       int
+	ips             map[string]*wsIPState
+	ipMutex         sync.Mutex
+	connRate        float64
+	connBurst       int
+	ipRate          float64
+	ipBurst         int
+	limitDisconnect bool
+	trustedProxies  []*stdnet.IPNet
+
+	compressionLevel     int
+	compressionThreshold int
+	readLimit            int64
+
+	shuttingDown int32
+	inflight     int64
+}
+
+// extendReadDeadline 开启心跳后, 每次收到数据都顺延读超时
+func (w *WsWorker) extendReadDeadline(conn *WsConn) {
+	if w.pongWait > 0 {
+		_ = conn.Conn.SetReadDeadline(time.Now().Add(w.pongWait))
+	}
+}
+
+// heartbeat 定时发送ping, 并清理空闲超时的连接
+func (w *WsWorker) heartbeat(conn *WsConn) {
+	interval := w.pingInterval
+	if interval <= 0 {
+		interval = w.idleTimeout / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.closed:
+			return
+		case <-ticker.C:
+			if w.idleTimeout > 0 && time.Since(conn.ActiveAt()) > w.idleTimeout {
+				g3.ZL().Info("idle timeout",
+					zap.String("uuid", conn.Uuid),
+					zap.Time("activeAt", conn.ActiveAt()))
+				w.closeAndDelete(conn)
+				return
+			}
+			if w.pingInterval <= 0 {
+				continue
+			}
+			err := conn.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(defaultWsWriteWait))
+			if err != nil {
+				g3.ZL().Info("ping failed",
+					zap.String("uuid", conn.Uuid),
+					zap.Error(err))
+				w.closeAndDelete(conn)
+				return
+			}
+		}
+	}
 }
 
 func (w *WsWorker) listen(conn *WsConn) {
 	g3.ZL().Info("start listen", zap.String("uuid", conn.Uuid))
+	if w.pongWait > 0 {
+		conn.Conn.SetPongHandler(func(string) error {
+			w.extendReadDeadline(conn)
+			return nil
+		})
+		w.extendReadDeadline(conn)
+	}
+	if w.pingInterval > 0 || w.idleTimeout > 0 {
+		go w.heartbeat(conn)
+	}
 	for {
 		_, message, err := conn.Conn.ReadMessage()
 		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				g3.ZL().Warn("message too large",
+					zap.String("uuid", conn.Uuid),
+					zap.String("ip", conn.IP),
+					zap.Int64("limit", w.readLimit))
+			}
 			g3.ZL().Debug("on message err", zap.Error(err))
 			if w.onError != nil {
 				w.onError(conn, err)
@@ -94,24 +407,49 @@ func (w *WsWorker) listen(conn *WsConn) {
 			break
 		}
 		g3.ZL().Debug("on message", zap.String("uuid", conn.Uuid))
+		conn.touch()
+		w.extendReadDeadline(conn)
+		if w.IsShuttingDown() {
+			// 停止期间丢弃新消息, 客户端已收到停止通知
+			continue
+		}
+		if !w.allowMessage(conn) {
+			continue
+		}
 		if w.onMessage != nil {
-			w.onMessage(conn, message)
+			w.handleMessage(conn, message)
 		} else {
 			if WsAuthing == conn.Status {
 				// 没注册自定义消息处理, 不进行auth验证
 				conn.Status = WsConnected
-				_ = conn.Conn.WriteJSON(map[string]interface{}{
+				_ = conn.Send(map[string]interface{}{
 					"msg": "Login Success!",
 				})
 				continue
 			}
-			_ = conn.Conn.WriteJSON(map[string]interface{}{
+			_ = conn.Send(map[string]interface{}{
 				"msg": "Message From Server : " + string(message),
 			})
 		}
 	}
 }
 
+// handleMessage 调用自定义消息处理, panic时记录日志并继续读取下一条消息
+func (w *WsWorker) handleMessage(conn *WsConn, message []byte) {
+	atomic.AddInt64(&w.inflight, 1)
+	defer atomic.AddInt64(&w.inflight, -1)
+	defer func() {
+		if err := recover(); err != nil {
+			g3.ZL().Error("on message panic",
+				zap.String("uuid", conn.Uuid),
+				zap.Int64("uid", conn.Uid),
+				zap.Reflect("error", err),
+				zap.ByteString("stack", debug.Stack()))
+		}
+	}()
+	w.onMessage(conn, message)
+}
+
 // handleConnect
 func (w *WsWorker) handleConnect(conn *WsConn) bool {
 	g3.ZL().Info("connected",
@@ -121,28 +459,54 @@ func (w *WsWorker) handleConnect(conn *WsConn) bool {
 	w.rwMutex.Lock()
 	w.connections[conn.Uuid] = conn
 	conn.Status = WsAuthing
+	w.rwMutex.Unlock()
 	if w.onConnected != nil {
 		w.onConnected(conn)
 	}
-	w.rwMutex.Unlock()
 	return true
 }
 
 func (w *WsWorker) closeAndDelete(conn *WsConn) {
+	w.closeAndDeleteContext(context.Background(), conn)
+}
+
+// closeAndDeleteContext ctx 结束后不再等待写协程, 直接关闭底层连接
+func (w *WsWorker) closeAndDeleteContext(ctx context.Context, conn *WsConn) {
 	w.rwMutex.Lock()
-	if WsClosed != conn.Status {
-		if w.onClosed != nil {
-			w.onClosed(conn)
-		}
-		if conn != nil {
-			_ = conn.Conn.Close()
+	if WsClosed == conn.Status {
+		w.rwMutex.Unlock()
+		return
+	}
+	conn.Status = WsClosed
+	delete(w.connections, conn.Uuid)
+	w.rwMutex.Unlock()
+
+	w.leaveAll(conn)
+	w.unbindUser(conn)
+	w.detachSession(conn)
+
+	conn.markClosed()
+	conn.closeSend()
+	if conn.writerDone != nil {
+		// 等待写协程把已排队的消息(如失败原因)发送出去
+		timer := time.NewTimer(defaultWsWriteWait)
+		select {
+		case <-conn.writerDone:
+		case <-timer.C:
+		case <-ctx.Done():
 		}
-		delete(w.connections, conn.Uuid)
+		timer.Stop()
+	}
+	if conn.Conn != nil {
+		_ = conn.Conn.Close()
+	}
+	// onClosed 在锁外执行, 回调中可以继续访问worker
+	if w.onClosed != nil {
+		w.onClosed(conn)
 	}
 	g3.ZL().Info("connection closed",
 		zap.String("uuid", conn.Uuid),
 	)
-	w.rwMutex.Unlock()
 }
 
 func (w *WsWorker) closeConn(conn *WsConn) {
@@ -193,6 +557,39 @@ func WithWsMessaged(handler func(conn *WsConn, message []byte)) WsWorkerOption {
 	}
 }
 
+// WithWsClosed 自定义断开链接处理
+func WithWsClosed(handler func(conn *WsConn)) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.onClosed = handler
+	}
+}
+
+// WithWsHeartbeat 心跳设置
+// pingInterval 发送ping的间隔, pongWait 未收到任何数据(含pong)时的读超时, 0表示不启用
+func WithWsHeartbeat(pingInterval, pongWait time.Duration) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.pingInterval = pingInterval
+		worker.pongWait = pongWait
+	}
+}
+
+// WithWsIdleTimeout 空闲超时, 超过该时间未收到客户端消息(不含ping/pong)则断开, 0表示不启用
+func WithWsIdleTimeout(timeout time.Duration) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.idleTimeout = timeout
+	}
+}
+
+// WithWsSendQueue 发送队列长度及队列已满时的处理策略
+func WithWsSendQueue(size int, policy WsOverflowPolicy) WsWorkerOption {
+	return func(worker *WsWorker) {
+		if size > 0 {
+			worker.sendQueueSize = size
+		}
+		worker.overflowPolicy = policy
+	}
+}
+
 // WithWsError 自定义错误处理
 func WithWsError(handler func(conn *WsConn, err error)) WsWorkerOption {
 	return func(worker *WsWorker) {
@@ -237,17 +634,55 @@ func HandleWebsocket(router string, opts ...WsWorkerOption) (*WsWorker, error) {
 	worker.Router = router
 	worker.upgrader = defaultUpgrader()
 	worker.connections = make(map[string]*WsConn)
+	worker.groups = make(map[string]map[string]*WsConn)
+	worker.users = make(map[int64]map[string]*WsConn)
+	worker.sendQueueSize = defaultWsSendQueueSize
+	worker.codecs = make(map[string]WsCodec)
+	worker.registerCodec(JSONCodec{})
+	worker.registerCodec(MsgPackCodec{})
+	worker.registerCodec(ProtobufCodec{})
+	worker.defaultCodec = WsCodecJSON
+	worker.sessions = make(map[string]*WsSession)
+	worker.userSessions = make(map[int64]map[string]*WsSession)
+	worker.sessionBufferSize = defaultWsSessionBufferSize
+	worker.sessionStop = make(chan struct{})
+	worker.ips = make(map[string]*wsIPState)
+	worker.compressionLevel = flate.BestSpeed
 	for _, opt := range opts {
 		opt(worker)
 	}
+	if worker.SessionEnabled() {
+		go worker.sessionJanitor()
+	}
+	if len(worker.upgrader.Subprotocols) == 0 {
+		// 允许客户端通过子协议协商编解码
+		worker.upgrader.Subprotocols = worker.codecNames
+	}
 	http.HandleFunc(router, func(writer http.ResponseWriter, request *http.Request) {
+		if worker.IsShuttingDown() {
+			http.Error(writer, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		ip := worker.clientIP(request)
+		if status := worker.acquireConn(ip); status != http.StatusOK {
+			http.Error(writer, http.StatusText(status), status)
+			return
+		}
+		// 连接处理结束(含closeConn)后释放名额
+		defer worker.releaseConn(ip)
 		var conn *websocket.Conn
 		g3Conn := new(WsConn)
+		g3Conn.IP = ip
 		g3Conn.Status = WsConnecting
 		g3Conn.Uuid = fmt.Sprintf("%v", uuid.New())
 		g3Conn.Query = helpers.ParseQueryString(request.RequestURI)
 		g3Conn.Data = make(map[string]interface{})
 		g3Conn.CreateAt = time.Now()
+		g3Conn.closed = make(chan struct{})
+		g3Conn.touch()
+		if worker.connRate > 0 {
+			g3Conn.bucket = helpers.NewTokenBucket(worker.connRate, worker.connBurst)
+		}
 		conn, err := worker.upgrader.Upgrade(writer, request, nil)
 		if err != nil {
 			onError(worker, g3Conn, err)
@@ -259,6 +694,13 @@ func HandleWebsocket(router string, opts ...WsWorkerOption) (*WsWorker, error) {
 		}
 		defer worker.closeConn(g3Conn)
 		g3Conn.Conn = conn
+		worker.applyTransport(conn)
+		g3Conn.compressionThreshold = worker.compressionThreshold
+		g3Conn.codec = worker.negotiateCodec(conn.Subprotocol(), g3Conn.Query)
+		g3Conn.send = make(chan wsFrame, worker.sendQueueSize)
+		g3Conn.writerDone = make(chan struct{})
+		g3Conn.overflow = worker.overflowPolicy
+		go g3Conn.writePump()
 		if !worker.handleConnect(g3Conn) {
 			return
 		}
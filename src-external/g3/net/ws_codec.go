@@ -0,0 +1,163 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package net
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	WsCodecJSON     = "json"
+	WsCodecMsgPack  = "msgpack"
+	WsCodecProtobuf = "protobuf"
+
+	// WsCodecQueryKey 通过url参数协商编解码, 如 ws://host/?codec=msgpack
+	WsCodecQueryKey = "codec"
+)
+
+// WsCodec 消息编解码, 每个连接在握手时协商一种
+type WsCodec interface {
+	// Name 名称, 同时作为websocket子协议名
+	Name() string
+	// MessageType websocket.TextMessage 或 websocket.BinaryMessage
+	MessageType() int
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec 文本帧, 默认
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return WsCodecJSON
+}
+
+func (JSONCodec) MessageType() int {
+	return websocket.TextMessage
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// MsgPackCodec 二进制帧, 字段名沿用json标签
+type MsgPackCodec struct{}
+
+func (MsgPackCodec) Name() string {
+	return WsCodecMsgPack
+}
+
+func (MsgPackCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (MsgPackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (MsgPackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// ProtobufCodec 二进制帧
+// v 实现了proto.Message时直接编解码, 否则按 google.protobuf.Struct 编解码,
+// 客户端无需为每个router单独定义消息即可使用
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Name() string {
+	return WsCodecProtobuf
+}
+
+func (ProtobufCodec) MessageType() int {
+	return websocket.BinaryMessage
+}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Marshal(m)
+	}
+	// 先转成通用结构, 再转为Struct
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	s, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(s)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	s := new(structpb.Struct)
+	if err := proto.Unmarshal(data, s); err != nil {
+		return err
+	}
+	fields, err := json.Marshal(s.AsMap())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(fields, v)
+}
+
+// WithWsCodecs 注册编解码, 同名覆盖; 默认已注册 json、msgpack、protobuf
+func WithWsCodecs(codecs ...WsCodec) WsWorkerOption {
+	return func(worker *WsWorker) {
+		for _, codec := range codecs {
+			worker.registerCodec(codec)
+		}
+	}
+}
+
+// WithWsDefaultCodec 客户端未协商时使用的编解码, 默认json
+func WithWsDefaultCodec(name string) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.defaultCodec = name
+	}
+}
+
+func (w *WsWorker) registerCodec(codec WsCodec) {
+	if _, exist := w.codecs[codec.Name()]; !exist {
+		w.codecNames = append(w.codecNames, codec.Name())
+	}
+	w.codecs[codec.Name()] = codec
+}
+
+// negotiateCodec 优先使用子协议, 其次url参数, 都没有时使用默认
+func (w *WsWorker) negotiateCodec(subprotocol string, query map[string]string) WsCodec {
+	if codec, exist := w.codecs[subprotocol]; exist {
+		return codec
+	}
+	if codec, exist := w.codecs[query[WsCodecQueryKey]]; exist {
+		return codec
+	}
+	if codec, exist := w.codecs[w.defaultCodec]; exist {
+		return codec
+	}
+	return JSONCodec{}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package net
+
+import (
+	"errors"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3/helpers"
+	"go.uber.org/zap"
+)
+
+var ErrWsConnNotFound = errors.New("websocket connection not found")
+
+// Join 将连接加入分组(房间), 连接断开时自动退出
+func (w *WsWorker) Join(conn *WsConn, group string) bool {
+	w.rwMutex.RLock()
+	_, online := w.connections[conn.Uuid]
+	w.rwMutex.RUnlock()
+	if !online {
+		return false
+	}
+	w.groupMutex.Lock()
+	defer w.groupMutex.Unlock()
+	// 与 leaveAll 在同一把锁内判断, 连接已关闭时不能再加入
+	if conn.groupsLeft {
+		return false
+	}
+	members, exist := w.groups[group]
+	if !exist {
+		members = make(map[string]*WsConn)
+		w.groups[group] = members
+	}
+	members[conn.Uuid] = conn
+	if conn.groups == nil {
+		conn.groups = make(map[string]bool)
+	}
+	conn.groups[group] = true
+	return true
+}
+
+// Leave 退出分组, 分组为空时删除
+func (w *WsWorker) Leave(conn *WsConn, group string) {
+	w.groupMutex.Lock()
+	w.leave(conn, group)
+	w.groupMutex.Unlock()
+}
+
+func (w *WsWorker) leave(conn *WsConn, group string) {
+	if members, exist := w.groups[group]; exist {
+		delete(members, conn.Uuid)
+		if len(members) == 0 {
+			delete(w.groups, group)
+		}
+	}
+	delete(conn.groups, group)
+}
+
+// leaveAll 连接关闭时退出所有分组
+func (w *WsWorker) leaveAll(conn *WsConn) {
+	w.groupMutex.Lock()
+	conn.groupsLeft = true
+	for group := range conn.groups {
+		w.leave(conn, group)
+	}
+	w.groupMutex.Unlock()
+}
+
+// InGroup 连接是否在分组中
+func (w *WsWorker) InGroup(conn *WsConn, group string) bool {
+	w.groupMutex.RLock()
+	defer w.groupMutex.RUnlock()
+	return conn.groups[group]
+}
+
+// GroupSize 分组内的连接数
+func (w *WsWorker) GroupSize(group string) int {
+	w.groupMutex.RLock()
+	defer w.groupMutex.RUnlock()
+	return len(w.groups[group])
+}
+
+// GroupMembers 分组内的连接快照
+func (w *WsWorker) GroupMembers(group string) []*WsConn {
+	w.groupMutex.RLock()
+	defer w.groupMutex.RUnlock()
+	result := make([]*WsConn, 0, len(w.groups[group]))
+	for _, conn := range w.groups[group] {
+		result = append(result, conn)
+	}
+	return result
+}
+
+// Connection 根据uuid查找连接
+func (w *WsWorker) Connection(uuid string) (*WsConn, bool) {
+	w.rwMutex.RLock()
+	defer w.rwMutex.RUnlock()
+	conn, exist := w.connections[uuid]
+	return conn, exist
+}
+
+// SendToUuid 向指定连接推送消息
+func (w *WsWorker) SendToUuid(uuid string, router string, data interface{}) error {
+	conn, exist := w.Connection(uuid)
+	if !exist {
+		return ErrWsConnNotFound
+	}
+	return conn.Push(router, data)
+}
+
+// BroadcastToGroup 向分组内的所有连接推送消息, exclude 为不需要推送的连接uuid, 返回成功放入发送队列的连接数
+func (w *WsWorker) BroadcastToGroup(group string, router string, data interface{}, exclude ...string) int {
+	return w.broadcast(w.GroupMembers(group), router, data, exclude)
+}
+
+// BroadcastAll 向所有连接推送消息, 返回成功放入发送队列的连接数
+func (w *WsWorker) BroadcastAll(router string, data interface{}) int {
+	w.rwMutex.RLock()
+	conns := make([]*WsConn, 0, len(w.connections))
+	for _, conn := range w.connections {
+		conns = append(conns, conn)
+	}
+	w.rwMutex.RUnlock()
+	return w.broadcast(conns, router, data, nil)
+}
+
+func (w *WsWorker) broadcast(conns []*WsConn, router string, data interface{}, exclude []string) int {
+	if len(conns) == 0 {
+		return 0
+	}
+	message := wsPush(router, data)
+	// 每种编解码只序列化一次
+	frames := make(map[string]wsFrame)
+	cnt := 0
+	for _, conn := range conns {
+		if len(exclude) > 0 && helpers.IndexOf[string](exclude, conn.Uuid) >= 0 {
+			continue
+		}
+		if session := conn.Session(); session != nil {
+			// 会话中的推送需要各自的序号, 无法共用编码结果
+			if session.push(message) == nil {
+				cnt++
+			}
+			continue
+		}
+		codec := conn.Codec()
+		frame, exist := frames[codec.Name()]
+		if !exist {
+			var err error
+			frame, err = newWsFrame(codec, message)
+			if err != nil {
+				g3.ZL().Error("broadcast marshal failed",
+					zap.String("router", router),
+					zap.String("codec", codec.Name()),
+					zap.Error(err))
+				return cnt
+			}
+			frames[codec.Name()] = frame
+		}
+		if conn.enqueue(frame) == nil {
+			cnt++
+		}
+	}
+	return cnt
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package net
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3/helpers"
+	"go.uber.org/zap"
+	stdnet "net"
+	"net/http"
+	"strings"
+)
+
+// WsRateLimitedRouter 消息发送过快时推送的router
+const WsRateLimitedRouter = "server/rate_limited"
+
+// wsIPState 同一IP的连接数及共享的消息令牌桶
+type wsIPState struct {
+	conns  int
+	bucket *helpers.TokenBucket
+}
+
+// WithWsMaxConnections 最大连接数及单个IP的最大连接数, 0为不限制
+func WithWsMaxConnections(max, maxPerIP int) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.maxConns = max
+		worker.maxConnsPerIP = maxPerIP
+	}
+}
+
+// WithWsConnRateLimit 单个连接每秒最多rate条消息, 最多突发burst条, rate<=0为不限制
+func WithWsConnRateLimit(rate float64, burst int) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.connRate = rate
+		worker.connBurst = burst
+	}
+}
+
+// WithWsIPRateLimit 同一IP的所有连接共享, 每秒最多rate条消息, 最多突发burst条, rate<=0为不限制
+func WithWsIPRateLimit(rate float64, burst int) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.ipRate = rate
+		worker.ipBurst = burst
+	}
+}
+
+// WithWsRateLimitDisconnect 超过消息频率限制时断开连接, 默认仅丢弃消息
+func WithWsRateLimitDisconnect(disconnect bool) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.limitDisconnect = disconnect
+	}
+}
+
+// WithWsTrustedProxies 受信任的反向代理, IP或CIDR; 仅当请求来自这些地址时才采用 X-Forwarded-For / X-Real-IP 头
+func WithWsTrustedProxies(proxies ...string) WsWorkerOption {
+	return func(worker *WsWorker) {
+		for _, proxy := range proxies {
+			if proxy = strings.TrimSpace(proxy); len(proxy) == 0 {
+				continue
+			}
+			if !strings.Contains(proxy, "/") {
+				if ip := stdnet.ParseIP(proxy); ip != nil && ip.To4() != nil {
+					proxy += "/32"
+				} else {
+					proxy += "/128"
+				}
+			}
+			_, ipNet, err := stdnet.ParseCIDR(proxy)
+			if err != nil {
+				g3.ZL().Warn("invalid websocket trusted proxy", zap.String("proxy", proxy), zap.Error(err))
+				continue
+			}
+			worker.trustedProxies = append(worker.trustedProxies, ipNet)
+		}
+	}
+}
+
+// isTrustedProxy ip 是否为受信任的代理
+func (w *WsWorker) isTrustedProxy(ip string) bool {
+	parsed := stdnet.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range w.trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP 客户端IP; 直连地址为受信任的代理时, 从右向左取 X-Forwarded-For 中第一个非代理的地址, 没有时取 X-Real-IP
+// X-Forwarded-For 左侧的值可由客户端伪造, 只有代理追加的右侧部分可信
+func (w *WsWorker) clientIP(request *http.Request) string {
+	remote, _, err := stdnet.SplitHostPort(strings.TrimSpace(request.RemoteAddr))
+	if err != nil {
+		remote = request.RemoteAddr
+	}
+	if !w.isTrustedProxy(remote) {
+		return remote
+	}
+	if forwarded := request.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		ips := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(ips[i])
+			if stdnet.ParseIP(ip) == nil {
+				// 无法解析的值之前的部分不可信
+				break
+			}
+			remote = ip
+			if !w.isTrustedProxy(ip) {
+				return ip
+			}
+		}
+		return remote
+	}
+	if ip := strings.TrimSpace(request.Header.Get("X-Real-IP")); stdnet.ParseIP(ip) != nil {
+		return ip
+	}
+	return remote
+}
+
+// acquireConn 占用连接名额, 超过总数限制返回503, 超过单IP限制返回429
+func (w *WsWorker) acquireConn(ip string) int {
+	w.ipMutex.Lock()
+	defer w.ipMutex.Unlock()
+	if w.maxConns > 0 && w.connCount >= w.maxConns {
+		g3.ZL().Warn("websocket max connections reached",
+			zap.Int("max", w.maxConns),
+			zap.String("ip", ip))
+		return http.StatusServiceUnavailable
+	}
+	state, exist := w.ips[ip]
+	if w.maxConnsPerIP > 0 && exist && state.conns >= w.maxConnsPerIP {
+		g3.ZL().Warn("websocket max connections per ip reached",
+			zap.Int("max", w.maxConnsPerIP),
+			zap.String("ip", ip))
+		return http.StatusTooManyRequests
+	}
+	if !exist {
+		state = &wsIPState{}
+		if w.ipRate > 0 {
+			state.bucket = helpers.NewTokenBucket(w.ipRate, w.ipBurst)
+		}
+		w.ips[ip] = state
+	}
+	state.conns++
+	w.connCount++
+	return http.StatusOK
+}
+
+// releaseConn 释放连接名额
+func (w *WsWorker) releaseConn(ip string) {
+	w.ipMutex.Lock()
+	defer w.ipMutex.Unlock()
+	w.connCount--
+	if state, exist := w.ips[ip]; exist {
+		state.conns--
+		if state.conns <= 0 {
+			delete(w.ips, ip)
+		}
+	}
+}
+
+// allowMessage 依次检查连接及IP的消息频率
+func (w *WsWorker) allowMessage(conn *WsConn) bool {
+	if conn.bucket != nil && !conn.bucket.Allow() {
+		w.rateLimited(conn, "conn")
+		return false
+	}
+	if w.ipRate > 0 {
+		w.ipMutex.Lock()
+		state := w.ips[conn.IP]
+		w.ipMutex.Unlock()
+		if state != nil && state.bucket != nil && !state.bucket.Allow() {
+			w.rateLimited(conn, "ip")
+			return false
+		}
+	}
+	return true
+}
+
+func (w *WsWorker) rateLimited(conn *WsConn, scope string) {
+	g3.ZL().Warn("websocket message rate limited",
+		zap.String("scope", scope),
+		zap.String("uuid", conn.Uuid),
+		zap.Int64("uid", conn.Uid),
+		zap.String("ip", conn.IP),
+		zap.Bool("disconnect", w.limitDisconnect))
+	conn.write(WsMessage{
+		Push:   true,
+		Router: WsRateLimitedRouter,
+		Code:   WsErrorTooManyRequests,
+		Msg:    "too many requests",
+		Data:   gin.H{"scope": scope},
+	})
+	if w.limitDisconnect {
+		// 在读协程中, 关闭后由listen退出
+		go w.Close(conn)
+	}
+}
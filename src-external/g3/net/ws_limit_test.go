@@ -0,0 +1,42 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package net
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWsClientIP(t *testing.T) {
+	w := newTestWsWorker(0)
+	WithWsTrustedProxies("10.0.0.1", "192.168.0.0/16", "bad")(w)
+	cases := []struct {
+		name     string
+		remote   string
+		forwards []string
+		realIP   string
+		want     string
+	}{
+		{"direct", "1.2.3.4:5000", nil, "", "1.2.3.4"},
+		{"untrusted remote ignores headers", "1.2.3.4:5000", []string{"9.9.9.9"}, "8.8.8.8", "1.2.3.4"},
+		{"trusted proxy", "10.0.0.1:5000", []string{"5.6.7.8"}, "", "5.6.7.8"},
+		{"spoofed leftmost", "10.0.0.1:5000", []string{"9.9.9.9, 5.6.7.8"}, "", "5.6.7.8"},
+		{"proxy chain", "10.0.0.1:5000", []string{"9.9.9.9, 5.6.7.8, 192.168.1.2"}, "", "5.6.7.8"},
+		{"multiple headers", "10.0.0.1:5000", []string{"9.9.9.9", "5.6.7.8"}, "", "5.6.7.8"},
+		{"garbage stops walk", "10.0.0.1:5000", []string{"9.9.9.9, junk, 192.168.1.2"}, "", "192.168.1.2"},
+		{"real ip", "192.168.3.4:5000", nil, "5.6.7.8", "5.6.7.8"},
+		{"invalid real ip", "192.168.3.4:5000", nil, "junk", "192.168.3.4"},
+	}
+	for _, c := range cases {
+		request := &http.Request{RemoteAddr: c.remote, Header: http.Header{}}
+		for _, forward := range c.forwards {
+			request.Header.Add("X-Forwarded-For", forward)
+		}
+		if len(c.realIP) > 0 {
+			request.Header.Set("X-Real-IP", c.realIP)
+		}
+		if got := w.clientIP(request); got != c.want {
+			t.Errorf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package net
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/zhouhp1295/g3"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const defaultWsSessionBufferSize = 128
+
+var (
+	ErrWsSessionNotFound = errors.New("websocket session not found or expired")
+	ErrWsSessionGap      = errors.New("websocket session missed messages are no longer buffered")
+)
+
+// WsSession 可恢复的会话
+// 授权成功后创建, 连接断开后在宽限期内保留会话数据并缓存推送,
+// 新连接通过 Resume 重新接管并按序补发客户端未收到的推送
+type WsSession struct {
+	Token string
+	Uid   int64
+
+	mutex      sync.Mutex
+	conn       *WsConn
+	data       map[string]interface{}
+	seq        uint64
+	buffer     []WsMessage
+	bufferSize int
+	expireAt   time.Time
+}
+
+// Seq 最后一条推送的序号
+func (s *WsSession) Seq() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.seq
+}
+
+// push 为推送分配序号并缓存, 已连接时同时发送
+// 发送在锁内完成, 保证客户端收到的序号有序
+func (s *WsSession) push(message WsMessage) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.pushLocked(message)
+}
+
+func (s *WsSession) pushLocked(message WsMessage) error {
+	s.seq++
+	message.Seq = s.seq
+	s.buffer = append(s.buffer, message)
+	if len(s.buffer) > s.bufferSize {
+		s.buffer = s.buffer[len(s.buffer)-s.bufferSize:]
+	}
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Send(message)
+}
+
+// WithWsSession 开启会话恢复, grace 为断线后会话保留时长, bufferSize 为最多缓存的推送条数
+func WithWsSession(grace time.Duration, bufferSize int) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.sessionGrace = grace
+		if bufferSize > 0 {
+			worker.sessionBufferSize = bufferSize
+		}
+	}
+}
+
+// SessionEnabled 是否开启了会话恢复
+func (w *WsWorker) SessionEnabled() bool {
+	return w.sessionGrace > 0
+}
+
+// NewSession 为已授权的连接创建会话, 未开启会话恢复时返回nil
+// 非多端登录策略下, 同一用户的旧会话将失效
+func (w *WsWorker) NewSession(conn *WsConn) *WsSession {
+	if !w.SessionEnabled() || conn.Uid <= 0 {
+		return nil
+	}
+	session := &WsSession{
+		Token:      uuid.NewString(),
+		Uid:        conn.Uid,
+		conn:       conn,
+		bufferSize: w.sessionBufferSize,
+	}
+	w.sessionMutex.Lock()
+	if WsLoginAllowMultiple != w.loginPolicy {
+		for token := range w.userSessions[conn.Uid] {
+			delete(w.sessions, token)
+		}
+		delete(w.userSessions, conn.Uid)
+	}
+	w.sessions[session.Token] = session
+	if _, exist := w.userSessions[conn.Uid]; !exist {
+		w.userSessions[conn.Uid] = make(map[string]*WsSession)
+	}
+	w.userSessions[conn.Uid][session.Token] = session
+	w.sessionMutex.Unlock()
+
+	conn.setSession(session)
+	return session
+}
+
+// Resume 新连接接管会话, 按序补发序号大于lastSeq的推送
+// 旧连接仍未检测到断开时将被关闭; 接管、补发及绑定用户在会话锁内完成,
+// 期间的推送等待补发结束后按序发送, 不会丢失
+func (w *WsWorker) Resume(conn *WsConn, token string, lastSeq uint64) (*WsSession, error) {
+	w.sessionMutex.Lock()
+	session, exist := w.sessions[token]
+	w.sessionMutex.Unlock()
+	if !exist {
+		return nil, ErrWsSessionNotFound
+	}
+
+	session.mutex.Lock()
+	old := session.conn
+	session.mutex.Unlock()
+	if old != nil && old != conn {
+		g3.ZL().Info("session resumed, close stale connection",
+			zap.String("uuid", old.Uuid),
+			zap.String("newUuid", conn.Uuid))
+		w.closeAndDelete(old)
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.conn != nil && session.conn != conn {
+		// 并发恢复同一个会话, 后到的失败
+		return nil, ErrWsSessionNotFound
+	}
+	if session.conn == nil && time.Now().After(session.expireAt) {
+		go w.dropSession(session)
+		return nil, ErrWsSessionNotFound
+	}
+	if lastSeq > session.seq || (len(session.buffer) > 0 && lastSeq+1 < session.buffer[0].Seq) {
+		return nil, ErrWsSessionGap
+	}
+	if err := w.BindUser(conn, session.Uid); err != nil {
+		return nil, err
+	}
+	session.conn = conn
+	for key, value := range session.data {
+		if _, exist = conn.Data[key]; !exist {
+			conn.Data[key] = value
+		}
+	}
+	session.data = nil
+	conn.setSession(session)
+	replayed := 0
+	for _, message := range session.buffer {
+		if message.Seq > lastSeq {
+			_ = conn.Send(message)
+			replayed++
+		}
+	}
+	g3.ZL().Info("session resumed",
+		zap.Int64("uid", session.Uid),
+		zap.String("uuid", conn.Uuid),
+		zap.Uint64("lastSeq", lastSeq),
+		zap.Int("replayed", replayed))
+	return session, nil
+}
+
+// DropSession 使连接的会话失效, 如主动退出登录
+func (w *WsWorker) DropSession(conn *WsConn) {
+	if session := conn.Session(); session != nil {
+		w.dropSession(session)
+	}
+}
+
+func (w *WsWorker) dropSession(session *WsSession) {
+	w.sessionMutex.Lock()
+	defer w.sessionMutex.Unlock()
+	if w.sessions[session.Token] != session {
+		return
+	}
+	delete(w.sessions, session.Token)
+	if members, exist := w.userSessions[session.Uid]; exist {
+		delete(members, session.Token)
+		if len(members) == 0 {
+			delete(w.userSessions, session.Uid)
+		}
+	}
+}
+
+// detachSession 连接关闭时保留会话, 开始计算宽限期
+func (w *WsWorker) detachSession(conn *WsConn) {
+	session := conn.Session()
+	if session == nil {
+		return
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.conn != conn {
+		return
+	}
+	session.conn = nil
+	session.data = conn.Data
+	session.expireAt = time.Now().Add(w.sessionGrace)
+}
+
+// bufferToSessions 将推送写入用户的所有会话: 断线中的缓存待恢复, 已连接的缓存并发送
+// 与 Resume 使用同一把会话锁, 恢复期间的推送排在补发之后
+func (w *WsWorker) bufferToSessions(uid int64, message WsMessage) (map[*WsSession]bool, int) {
+	if !w.SessionEnabled() {
+		return nil, 0
+	}
+	w.sessionMutex.Lock()
+	sessions := make(map[*WsSession]bool, len(w.userSessions[uid]))
+	for _, session := range w.userSessions[uid] {
+		sessions[session] = true
+	}
+	w.sessionMutex.Unlock()
+	cnt := 0
+	for session := range sessions {
+		if session.push(message) == nil {
+			cnt++
+		}
+	}
+	return sessions, cnt
+}
+
+// sessionJanitor 定期清理超过宽限期的会话
+func (w *WsWorker) sessionJanitor() {
+	interval := w.sessionGrace / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.sessionStop:
+			return
+		case now := <-ticker.C:
+			w.expireSessions(now)
+		}
+	}
+}
+
+func (w *WsWorker) expireSessions(now time.Time) {
+	w.sessionMutex.Lock()
+	defer w.sessionMutex.Unlock()
+	for token, session := range w.sessions {
+		session.mutex.Lock()
+		expired := session.conn == nil && now.After(session.expireAt)
+		session.mutex.Unlock()
+		if !expired {
+			continue
+		}
+		delete(w.sessions, token)
+		if members, exist := w.userSessions[session.Uid]; exist {
+			delete(members, token)
+			if len(members) == 0 {
+				delete(w.userSessions, session.Uid)
+			}
+		}
+		g3.ZL().Info("session expired",
+			zap.Int64("uid", session.Uid))
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package net
+
+import (
+	"github.com/google/uuid"
+	"github.com/zhouhp1295/g3"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "g3-net")
+	if err != nil {
+		panic(err)
+	}
+	g3.Boot(&g3.Cfg{HomeDir: dir})
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestWsWorker 不注册http路由的worker, 开启会话恢复
+func newTestWsWorker(bufferSize int) *WsWorker {
+	return &WsWorker{
+		connections:       make(map[string]*WsConn),
+		groups:            make(map[string]map[string]*WsConn),
+		users:             make(map[int64]map[string]*WsConn),
+		sessions:          make(map[string]*WsSession),
+		userSessions:      make(map[int64]map[string]*WsSession),
+		sessionGrace:      time.Minute,
+		sessionBufferSize: bufferSize,
+	}
+}
+
+// newTestWsConn 没有底层连接的连接, 发送的帧留在队列中供检查
+func newTestWsConn(w *WsWorker) *WsConn {
+	conn := &WsConn{
+		Uuid:     uuid.NewString(),
+		Data:     make(map[string]interface{}),
+		CreateAt: time.Now(),
+		Status:   WsConnected,
+		closed:   make(chan struct{}),
+		send:     make(chan wsFrame, 4096),
+	}
+	w.rwMutex.Lock()
+	w.connections[conn.Uuid] = conn
+	w.rwMutex.Unlock()
+	return conn
+}
+
+// drainPushes 取出队列中的推送, 返回序号及数据
+func drainPushes(t *testing.T, conn *WsConn) ([]uint64, []int) {
+	t.Helper()
+	seqs, values := make([]uint64, 0), make([]int, 0)
+	for {
+		select {
+		case frame := <-conn.send:
+			message := WsMessage{}
+			if err := (JSONCodec{}).Unmarshal(frame.data, &message); err != nil {
+				t.Fatal(err)
+			}
+			seqs = append(seqs, message.Seq)
+			values = append(values, int(message.Data.(float64)))
+		default:
+			return seqs, values
+		}
+	}
+}
+
+func authTestWsConn(t *testing.T, w *WsWorker, uid int64) (*WsConn, *WsSession) {
+	t.Helper()
+	conn := newTestWsConn(w)
+	if err := w.BindUser(conn, uid); err != nil {
+		t.Fatal(err)
+	}
+	return conn, w.NewSession(conn)
+}
+
+func TestWsSessionResumeReplay(t *testing.T) {
+	w := newTestWsWorker(defaultWsSessionBufferSize)
+	conn, session := authTestWsConn(t, w, 7)
+	for i := 1; i <= 3; i++ {
+		w.SendToUser(7, "test", i)
+	}
+	if seqs, _ := drainPushes(t, conn); len(seqs) != 3 || seqs[2] != 3 {
+		t.Fatalf("expected seq 1-3 while connected, got %v", seqs)
+	}
+
+	// 断线期间的推送缓存在会话中
+	w.closeAndDelete(conn)
+	for i := 4; i <= 5; i++ {
+		if cnt := w.SendToUser(7, "test", i); cnt != 1 {
+			t.Fatalf("push should be buffered by the detached session, got %d", cnt)
+		}
+	}
+
+	// 客户端只收到了序号2, 需补发3-5
+	resumed := newTestWsConn(w)
+	if _, err := w.Resume(resumed, session.Token, 2); err != nil {
+		t.Fatal(err)
+	}
+	w.SendToUser(7, "test", 6)
+	seqs, values := drainPushes(t, resumed)
+	if len(seqs) != 4 {
+		t.Fatalf("expected 4 pushes after resume, got %v", seqs)
+	}
+	for i, seq := range seqs {
+		if seq != uint64(i+3) || values[i] != i+3 {
+			t.Fatalf("pushes out of order: seqs %v values %v", seqs, values)
+		}
+	}
+	if conns := w.UserConns(7); len(conns) != 1 || conns[0] != resumed {
+		t.Fatal("resumed connection should be bound to the user")
+	}
+}
+
+func TestWsSessionResumeGap(t *testing.T) {
+	w := newTestWsWorker(2)
+	conn, session := authTestWsConn(t, w, 8)
+	w.closeAndDelete(conn)
+	for i := 1; i <= 5; i++ {
+		w.SendToUser(8, "test", i)
+	}
+	if _, err := w.Resume(newTestWsConn(w), session.Token, 1); err != ErrWsSessionGap {
+		t.Fatalf("expected ErrWsSessionGap, got %v", err)
+	}
+	if _, err := w.Resume(newTestWsConn(w), "unknown", 0); err != ErrWsSessionNotFound {
+		t.Fatalf("expected ErrWsSessionNotFound, got %v", err)
+	}
+}
+
+// TestWsSessionResumeConcurrentPush 恢复过程中的推送既不丢失也不重复, 且按序号送达
+func TestWsSessionResumeConcurrentPush(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		w := newTestWsWorker(defaultWsSessionBufferSize)
+		conn, session := authTestWsConn(t, w, 9)
+		w.closeAndDelete(conn)
+
+		const total = defaultWsSessionBufferSize
+		resumed := newTestWsConn(w)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= total; i++ {
+				w.SendToUser(9, "test", i)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := w.Resume(resumed, session.Token, 0); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+
+		seqs, values := drainPushes(t, resumed)
+		if len(seqs) != total {
+			t.Fatalf("round %d: expected %d pushes, got %d", round, total, len(seqs))
+		}
+		for i, seq := range seqs {
+			if seq != uint64(i+1) || values[i] != i+1 {
+				t.Fatalf("round %d: push %d has seq %d value %d", round, i, seq, values[i])
+			}
+		}
+	}
+}
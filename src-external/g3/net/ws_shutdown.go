@@ -0,0 +1,80 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package net
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/zhouhp1295/g3"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WsShutdownRouter 服务停止前推送给所有连接的router
+const WsShutdownRouter = "server/shutdown"
+
+// IsShuttingDown 是否正在停止, 停止期间不再接受新连接和新消息
+func (w *WsWorker) IsShuttingDown() bool {
+	return atomic.LoadInt32(&w.shuttingDown) == 1
+}
+
+// Shutdown 优雅停止:
+// 拒绝新连接 -> 通知所有连接 -> 等待处理中的消息完成 -> 关闭所有连接
+// ctx 超时后不再等待处理中的消息及未发送完的消息, 直接关闭连接并返回 ctx.Err()
+func (w *WsWorker) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&w.shuttingDown, 0, 1) {
+		return nil
+	}
+	close(w.sessionStop)
+	w.rwMutex.RLock()
+	conns := make([]*WsConn, 0, len(w.connections))
+	for _, conn := range w.connections {
+		conns = append(conns, conn)
+	}
+	w.rwMutex.RUnlock()
+	g3.ZL().Info("websocket shutting down", zap.Int("connections", len(conns)))
+
+	for _, conn := range conns {
+		conn.write(WsMessage{
+			Push:   true,
+			Router: WsShutdownRouter,
+			Code:   WsErrorUnavailable,
+			Msg:    "server is shutting down",
+			Data:   gin.H{},
+		})
+	}
+
+	err := w.waitInflight(ctx)
+	if err != nil {
+		g3.ZL().Warn("websocket shutdown timeout, handlers still running",
+			zap.Int64("inflight", atomic.LoadInt64(&w.inflight)))
+	}
+
+	// 并行关闭, 每个连接最多等待写协程 defaultWsWriteWait, ctx 结束后立即关闭
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn *WsConn) {
+			defer wg.Done()
+			w.closeAndDeleteContext(ctx, conn)
+		}(conn)
+	}
+	wg.Wait()
+	g3.ZL().Info("websocket shutdown completed")
+	return err
+}
+
+func (w *WsWorker) waitInflight(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&w.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
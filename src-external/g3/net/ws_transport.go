@@ -0,0 +1,108 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package net
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/zhouhp1295/g3"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WithWsCompression 协商permessage-deflate压缩
+// level 为flate压缩级别(-2~9), threshold 为开启压缩的最小消息字节数, 过小的消息压缩不划算
+func WithWsCompression(enable bool, level int, threshold int) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.upgrader.EnableCompression = enable
+		worker.compressionLevel = level
+		worker.compressionThreshold = threshold
+	}
+}
+
+// WithWsReadLimit 客户端单条消息的最大字节数, 超过时断开连接, 0为不限制
+// 开启压缩时按压缩后的字节数计算
+func WithWsReadLimit(limit int64) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.readLimit = limit
+	}
+}
+
+// WithWsBufferSize 读写缓冲区大小, pool 为true时写缓冲区在连接间复用, 适合大量空闲连接
+func WithWsBufferSize(readSize, writeSize int, pool bool) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.upgrader.ReadBufferSize = readSize
+		worker.upgrader.WriteBufferSize = writeSize
+		if pool {
+			worker.upgrader.WriteBufferPool = &sync.Pool{}
+		} else {
+			worker.upgrader.WriteBufferPool = nil
+		}
+	}
+}
+
+// WithWsAllowedOrigins 允许的Origin, 为空时不限制
+// 支持完整的 scheme://host[:port]、仅host 以及 *.example.com 形式; 没有Origin头的非浏览器客户端不受限制
+func WithWsAllowedOrigins(origins ...string) WsWorkerOption {
+	return func(worker *WsWorker) {
+		allowed := make([]string, 0, len(origins))
+		for _, origin := range origins {
+			if origin = strings.ToLower(strings.TrimSpace(origin)); len(origin) > 0 {
+				allowed = append(allowed, origin)
+			}
+		}
+		if len(allowed) == 0 {
+			return
+		}
+		worker.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if len(origin) == 0 || matchWsOrigin(allowed, origin) {
+				return true
+			}
+			g3.ZL().Warn("websocket origin rejected",
+				zap.String("origin", origin),
+				zap.String("remote", r.RemoteAddr))
+			return false
+		}
+	}
+}
+
+func matchWsOrigin(allowed []string, origin string) bool {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || len(u.Host) == 0 {
+		return false
+	}
+	for _, pattern := range allowed {
+		switch {
+		case pattern == "*":
+			return true
+		case strings.Contains(pattern, "://"):
+			if pattern == u.Scheme+"://"+u.Host {
+				return true
+			}
+		case strings.HasPrefix(pattern, "*."):
+			if strings.HasSuffix(u.Hostname(), pattern[1:]) {
+				return true
+			}
+		case pattern == u.Host || pattern == u.Hostname():
+			return true
+		}
+	}
+	return false
+}
+
+// applyTransport 升级后对连接应用压缩及读限制
+func (w *WsWorker) applyTransport(conn *websocket.Conn) {
+	if w.readLimit > 0 {
+		conn.SetReadLimit(w.readLimit)
+	}
+	if w.upgrader.EnableCompression {
+		if err := conn.SetCompressionLevel(w.compressionLevel); err != nil {
+			g3.ZL().Warn("set compression level failed",
+				zap.Int("level", w.compressionLevel),
+				zap.Error(err))
+		}
+	}
+}
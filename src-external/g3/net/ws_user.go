@@ -0,0 +1,149 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package net
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/zhouhp1295/g3"
+	"go.uber.org/zap"
+)
+
+// WsLoginPolicy 同一账号重复登录时的处理策略
+type WsLoginPolicy int
+
+const (
+	// WsLoginKickOld 踢掉旧连接
+	WsLoginKickOld WsLoginPolicy = iota
+	// WsLoginRejectNew 拒绝新连接
+	WsLoginRejectNew
+	// WsLoginAllowMultiple 允许多个连接同时在线
+	WsLoginAllowMultiple
+)
+
+// WsKickedRouter 被踢下线时推送的router
+const WsKickedRouter = "user/kicked"
+
+var ErrWsUserLoggedIn = errors.New("user already logged in")
+
+// WithWsLoginPolicy 重复登录策略, 默认踢掉旧连接
+func WithWsLoginPolicy(policy WsLoginPolicy) WsWorkerOption {
+	return func(worker *WsWorker) {
+		worker.loginPolicy = policy
+	}
+}
+
+// BindUser 授权成功后将连接与用户id绑定, 连接已关闭时返回 ErrWsConnNotFound
+func (w *WsWorker) BindUser(conn *WsConn, uid int64) error {
+	w.userMutex.Lock()
+	// 与 unbindUser 在同一把锁内判断, 授权期间连接已关闭时不再绑定
+	if conn.unbound {
+		w.userMutex.Unlock()
+		return ErrWsConnNotFound
+	}
+	kicked := make([]*WsConn, 0)
+	members, exist := w.users[uid]
+	if exist && len(members) > 0 {
+		switch w.loginPolicy {
+		case WsLoginRejectNew:
+			w.userMutex.Unlock()
+			g3.ZL().Info("duplicate login rejected",
+				zap.Int64("uid", uid),
+				zap.String("uuid", conn.Uuid))
+			return ErrWsUserLoggedIn
+		case WsLoginKickOld:
+			for _, old := range members {
+				if old != conn {
+					kicked = append(kicked, old)
+				}
+			}
+			for _, old := range kicked {
+				delete(members, old.Uuid)
+			}
+		}
+	}
+	if !exist {
+		members = make(map[string]*WsConn)
+		w.users[uid] = members
+	}
+	conn.Uid = uid
+	members[conn.Uuid] = conn
+	w.userMutex.Unlock()
+
+	for _, old := range kicked {
+		g3.ZL().Info("duplicate login, kick old connection",
+			zap.Int64("uid", uid),
+			zap.String("uuid", old.Uuid),
+			zap.String("newUuid", conn.Uuid))
+		old.write(WsMessage{
+			Push:   true,
+			Router: WsKickedRouter,
+			Code:   WsErrorConflict,
+			Msg:    "logged in elsewhere",
+			Data:   gin.H{},
+		})
+		// 异步关闭, 避免等待旧连接的写协程阻塞当前授权流程
+		go w.Close(old)
+	}
+	return nil
+}
+
+// unbindUser 连接关闭时移除索引
+func (w *WsWorker) unbindUser(conn *WsConn) {
+	w.userMutex.Lock()
+	defer w.userMutex.Unlock()
+	conn.unbound = true
+	if conn.Uid == 0 {
+		return
+	}
+	if members, exist := w.users[conn.Uid]; exist {
+		if members[conn.Uuid] == conn {
+			delete(members, conn.Uuid)
+		}
+		if len(members) == 0 {
+			delete(w.users, conn.Uid)
+		}
+	}
+}
+
+// UserConns 用户当前在线的连接
+func (w *WsWorker) UserConns(uid int64) []*WsConn {
+	w.userMutex.RLock()
+	defer w.userMutex.RUnlock()
+	result := make([]*WsConn, 0, len(w.users[uid]))
+	for _, conn := range w.users[uid] {
+		result = append(result, conn)
+	}
+	return result
+}
+
+// IsUserOnline 用户是否在本节点在线
+func (w *WsWorker) IsUserOnline(uid int64) bool {
+	w.userMutex.RLock()
+	defer w.userMutex.RUnlock()
+	return len(w.users[uid]) > 0
+}
+
+// OnlineUsers 本节点在线的用户id
+func (w *WsWorker) OnlineUsers() []int64 {
+	w.userMutex.RLock()
+	defer w.userMutex.RUnlock()
+	result := make([]int64, 0, len(w.users))
+	for uid := range w.users {
+		result = append(result, uid)
+	}
+	return result
+}
+
+// SendToUser 向用户的所有连接推送消息, 返回成功放入发送队列的连接数(含断线中缓存的会话)
+// 有会话的连接经由会话发送, 以保证序号连续且恢复时不丢失
+func (w *WsWorker) SendToUser(uid int64, router string, data interface{}) int {
+	sessions, cnt := w.bufferToSessions(uid, wsPush(router, data))
+	conns := make([]*WsConn, 0)
+	for _, conn := range w.UserConns(uid) {
+		if session := conn.Session(); session == nil || !sessions[session] {
+			conns = append(conns, conn)
+		}
+	}
+	return cnt + w.broadcast(conns, router, data, nil)
+}
@@ -3,6 +3,8 @@
 package boot
 
 import (
+	"context"
+	"github.com/go-redis/redis/v8"
 	"github.com/zhouhp1295/g3"
 	"github.com/zhouhp1295/g3-game/utils"
 	"github.com/zhouhp1295/g3/crud"
@@ -14,8 +16,10 @@ import (
 	"go.beyondstorage.io/v5/types"
 	"go.uber.org/zap"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 )
 
 var (
@@ -27,6 +31,8 @@ var (
 	}
 	Lache    *lache.Client
 	Storager types.Storager
+	// Redis 缓存使用redis时可用, 否则为nil; 供排行榜等需要redis数据结构的功能使用
+	Redis *redis.Client
 
 	initDatabaseOnce sync.Once
 
@@ -39,8 +45,13 @@ var (
 	afterInstallFunctions     []func()
 	afterInstallFunctionMutex sync.Mutex
 
+	shutdownFunctions     []func()
+	shutdownFunctionMutex sync.Mutex
+	shutdownDone          = make(chan struct{})
+
 	preStart func()
 	start    func()
+	stop     func(ctx context.Context)
 )
 
 func init() {
@@ -58,6 +69,13 @@ func RegisterAfterInstallFunction(f func()) {
 	afterInstallFunctionMutex.Unlock()
 }
 
+// RegisterShutdownFunction 注册停止时执行的函数, 在服务停止后、数据库关闭前按注册顺序执行
+func RegisterShutdownFunction(f func()) {
+	shutdownFunctionMutex.Lock()
+	shutdownFunctions = append(shutdownFunctions, f)
+	shutdownFunctionMutex.Unlock()
+}
+
 func DoAfterInstall() {
 	//写入installed文件
 	SetInstalled()
@@ -69,6 +87,17 @@ func DoAfterInstall() {
 	}
 }
 
+func newLache(cfg cacheConfig) *lache.Client {
+	switch strings.ToLower(cfg.Type) {
+	case "redis":
+		return lache.New(lache.Redis, RedisCfg.options())
+	case "", "local":
+		return lache.New(lache.Local, driver.LocalOptions{})
+	default:
+		panic("未定义的缓存类型:" + cfg.Type)
+	}
+}
+
 // IsProdMode returns true if the application is running in production mode.
 func IsProdMode() bool {
 	return strings.EqualFold(App.RunMode, "prod")
@@ -103,7 +132,8 @@ func SetInstalled() {
 
 func InitDatabase() {
 	initDatabaseOnce.Do(func() {
-		initGormDB(&GormLogger{})
+		gormLogger = &GormLogger{}
+		initGormDB(gormLogger)
 	})
 }
 
@@ -120,16 +150,22 @@ func Start() {
 		AppId:   App.Identifier,
 	}
 	g3.Boot(&g3Cfg)
-	// 缓存
-	Lache = lache.New(lache.Local, driver.LocalOptions{})
 	// 加载配置文件
 	loadConfigs()
+	// 缓存
+	Lache = newLache(CacheCfg)
+	if strings.EqualFold(CacheCfg.Type, "redis") {
+		options := RedisCfg.options()
+		Redis = redis.NewClient(&options)
+	}
 	// 存储
 	Storager, err = services.NewStoragerFromString(StorageCfg.Uri)
 	if err != nil {
 		panic(err)
 	}
 	g3.ZL().Info("存储空间", zap.Reflect("Storager", Storager))
+	// 消息总线
+	Bus = newWsBus(BusCfg)
 	// 运行 preStart
 	if preStart != nil {
 		preStart()
@@ -140,5 +176,47 @@ func Start() {
 	g3.ZL().Info("Application Start",
 		zap.String("Addr", ServerCfg.HTTPAddr),
 		zap.String("Port", ServerCfg.HTTPPort))
+	go waitForShutdown()
 	start()
+	// 服务已停止监听, 等待清理完成
+	<-shutdownDone
+}
+
+// waitForShutdown 收到SIGINT/SIGTERM后依次:
+// 停止服务 -> 执行注册的停止函数 -> 关闭消息总线及redis -> 关闭数据库 -> 刷新日志
+func waitForShutdown() {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	signal.Stop(quit)
+	g3.ZL().Info("Application Stopping", zap.String("signal", sig.String()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), ServerCfg.shutdownTimeout())
+	defer cancel()
+	if stop != nil {
+		stop(ctx)
+	}
+	shutdownFunctionMutex.Lock()
+	for _, f := range shutdownFunctions {
+		f()
+	}
+	shutdownFunctionMutex.Unlock()
+	if Bus != nil {
+		if err := Bus.Close(); err != nil {
+			g3.ZL().Error("close bus failed", zap.Error(err))
+		}
+	}
+	if Redis != nil {
+		if err := Redis.Close(); err != nil {
+			g3.ZL().Error("close redis failed", zap.Error(err))
+		}
+	}
+	closeDatabase()
+
+	g3.ZL().Info("Application Stopped")
+	_ = g3.ZL().Sync()
+	if gormLogger != nil {
+		gormLogger.Sync()
+	}
+	close(shutdownDone)
 }
@@ -0,0 +1,189 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package boot
+
+import (
+	"context"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zhouhp1295/g3"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+)
+
+const (
+	WsTargetUser  = "user"
+	WsTargetGroup = "group"
+	WsTargetAll   = "all"
+)
+
+const (
+	BusLocal = "local"
+	BusRedis = "redis"
+
+	defaultBusChannel = "g3-game:ws-bus"
+)
+
+// WsEnvelope 跨节点推送的消息, 由每个websocket节点投递给本地连接
+type WsEnvelope struct {
+	Node   string      `json:"node"`
+	Target string      `json:"target"`
+	Uid    int64       `json:"uid,omitempty"`
+	Group  string      `json:"group,omitempty"`
+	Router string      `json:"router"`
+	Data   interface{} `json:"data"`
+}
+
+// WsBus 消息总线
+type WsBus interface {
+	Publish(env WsEnvelope) error
+	Subscribe(handler func(env WsEnvelope)) error
+	Close() error
+}
+
+// Bus 消息总线, http节点与websocket节点通过它向玩家推送消息
+var Bus WsBus
+
+// PublishToUser 向用户推送消息(所有节点)
+func PublishToUser(uid int64, router string, data interface{}) error {
+	return publish(WsEnvelope{Target: WsTargetUser, Uid: uid, Router: router, Data: data})
+}
+
+// PublishToGroup 向分组推送消息(所有节点)
+func PublishToGroup(group string, router string, data interface{}) error {
+	return publish(WsEnvelope{Target: WsTargetGroup, Group: group, Router: router, Data: data})
+}
+
+// PublishToAll 向所有在线连接推送消息(所有节点)
+func PublishToAll(router string, data interface{}) error {
+	return publish(WsEnvelope{Target: WsTargetAll, Router: router, Data: data})
+}
+
+func publish(env WsEnvelope) error {
+	if Bus == nil {
+		return errors.New("bus is nil")
+	}
+	env.Node = App.Identifier
+	err := Bus.Publish(env)
+	if err != nil {
+		g3.ZL().Error("publish to bus failed",
+			zap.Reflect("envelope", env),
+			zap.Error(err))
+	}
+	return err
+}
+
+func newWsBus(cfg busConfig) WsBus {
+	switch strings.ToLower(cfg.Type) {
+	case BusRedis:
+		return newRedisBus(RedisCfg.options(), cfg.Channel)
+	case "", BusLocal:
+		return newLocalBus()
+	default:
+		panic("未定义的消息总线类型:" + cfg.Type)
+	}
+}
+
+// localBus 进程内总线, 只能投递到当前进程的连接
+type localBus struct {
+	rwMutex  sync.RWMutex
+	handlers []func(env WsEnvelope)
+}
+
+func newLocalBus() *localBus {
+	return new(localBus)
+}
+
+func (b *localBus) Publish(env WsEnvelope) error {
+	b.rwMutex.RLock()
+	defer b.rwMutex.RUnlock()
+	if len(b.handlers) == 0 {
+		g3.ZL().Debug("local bus has no subscriber",
+			zap.String("router", env.Router))
+	}
+	for _, handler := range b.handlers {
+		handler(env)
+	}
+	return nil
+}
+
+func (b *localBus) Subscribe(handler func(env WsEnvelope)) error {
+	b.rwMutex.Lock()
+	b.handlers = append(b.handlers, handler)
+	b.rwMutex.Unlock()
+	return nil
+}
+
+func (b *localBus) Close() error {
+	return nil
+}
+
+// redisBus 基于redis pub/sub, 所有节点订阅同一个channel
+type redisBus struct {
+	client  *redis.Client
+	channel string
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mutex  sync.Mutex
+	pubsub *redis.PubSub
+}
+
+func newRedisBus(options redis.Options, channel string) *redisBus {
+	if len(channel) == 0 {
+		channel = defaultBusChannel
+	}
+	b := new(redisBus)
+	b.client = redis.NewClient(&options)
+	b.channel = channel
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	return b
+}
+
+func (b *redisBus) Publish(env WsEnvelope) error {
+	payload, err := jsoniter.MarshalToString(env)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(b.ctx, b.channel, payload).Err()
+}
+
+func (b *redisBus) Subscribe(handler func(env WsEnvelope)) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.pubsub != nil {
+		return errors.New("redis bus already subscribed")
+	}
+	pubsub := b.client.Subscribe(b.ctx, b.channel)
+	// 等待订阅确认, 连接失败时直接返回错误
+	if _, err := pubsub.Receive(b.ctx); err != nil {
+		_ = pubsub.Close()
+		return err
+	}
+	b.pubsub = pubsub
+	go func() {
+		for message := range pubsub.Channel() {
+			env := WsEnvelope{}
+			if err := jsoniter.UnmarshalFromString(message.Payload, &env); err != nil {
+				g3.ZL().Error("decode bus message failed",
+					zap.String("payload", message.Payload),
+					zap.Error(err))
+				continue
+			}
+			handler(env)
+		}
+	}()
+	return nil
+}
+
+func (b *redisBus) Close() error {
+	b.cancel()
+	b.mutex.Lock()
+	if b.pubsub != nil {
+		_ = b.pubsub.Close()
+	}
+	b.mutex.Unlock()
+	return b.client.Close()
+}
@@ -5,10 +5,12 @@ package boot
 import (
 	"fmt"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/zhouhp1295/g3"
 	"github.com/zhouhp1295/g3-game/utils"
 	"gopkg.in/ini.v1"
 	"strings"
+	"time"
 )
 
 var File *ini.File
@@ -32,6 +34,16 @@ type serverConfig struct {
 	Domain   string
 	HTTPAddr string `ini:"HTTP_ADDR"`
 	HTTPPort string `ini:"HTTP_PORT"`
+	NodeId   string `ini:"NODE_ID"`
+	// ShutdownTimeout 优雅停止的最长等待秒数
+	ShutdownTimeout int `ini:"SHUTDOWN_TIMEOUT"`
+}
+
+func (cfg *serverConfig) shutdownTimeout() time.Duration {
+	if cfg.ShutdownTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(cfg.ShutdownTimeout) * time.Second
 }
 
 // ServerCfg settings
@@ -42,6 +54,26 @@ type jwtConfig struct {
 	GameSecret     string
 	WsSecret       string
 	ExpiredSeconds int64
+	// GameAccessSeconds 游戏客户端http及websocket token的有效期, 过期后使用refresh token换取
+	GameAccessSeconds int64
+	// GameRefreshSeconds 游戏客户端refresh token的有效期
+	GameRefreshSeconds int64
+}
+
+// GameAccessTTL 未配置时为15分钟
+func (cfg jwtConfig) GameAccessTTL() time.Duration {
+	if cfg.GameAccessSeconds <= 0 {
+		return 15 * time.Minute
+	}
+	return time.Duration(cfg.GameAccessSeconds) * time.Second
+}
+
+// GameRefreshTTL 未配置时为30天
+func (cfg jwtConfig) GameRefreshTTL() time.Duration {
+	if cfg.GameRefreshSeconds <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return time.Duration(cfg.GameRefreshSeconds) * time.Second
 }
 
 var JwtCfg jwtConfig
@@ -81,6 +113,40 @@ func (cfg *storageConfig) check() bool {
 
 var StorageCfg storageConfig
 
+type redisConfig struct {
+	Addr     string
+	Password string
+	DB       int `ini:"DB"`
+}
+
+func (cfg *redisConfig) options() redis.Options {
+	return redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	}
+}
+
+// RedisCfg redis设置
+var RedisCfg redisConfig
+
+type busConfig struct {
+	// Type local 进程内, redis 跨节点
+	Type    string
+	Channel string
+}
+
+// BusCfg 消息总线设置
+var BusCfg busConfig
+
+type cacheConfig struct {
+	// Type local 进程内, redis 多节点共享
+	Type string
+}
+
+// CacheCfg 缓存设置
+var CacheCfg cacheConfig
+
 func loadConfigs() {
 	var err error
 	var iniPath string
@@ -127,4 +193,25 @@ func loadConfigs() {
 	if !StorageCfg.check() {
 		panic("请检查storage配置")
 	}
+
+	// ***************************
+	// ----- RedisCfg settings -----
+	// ***************************
+	if err = File.Section("redis").MapTo(&RedisCfg); err != nil {
+		panic(err)
+	}
+
+	// ***************************
+	// ----- BusCfg settings -----
+	// ***************************
+	if err = File.Section("bus").MapTo(&BusCfg); err != nil {
+		panic(err)
+	}
+
+	// ***************************
+	// ----- CacheCfg settings -----
+	// ***************************
+	if err = File.Section("cache").MapTo(&CacheCfg); err != nil {
+		panic(err)
+	}
 }
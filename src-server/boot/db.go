@@ -26,6 +26,11 @@ var (
 	UsePostgreSQL = false
 )
 
+var (
+	gormDB     *gorm.DB
+	gormLogger *GormLogger
+)
+
 const (
 	MySQL      = "mysql"
 	SQLite3    = "sqlite3"
@@ -44,6 +49,13 @@ func (gl *GormLogger) Printf(format string, v ...interface{}) {
 	gl.zLogger.Info(fmt.Sprintf(format, v...))
 }
 
+// Sync 刷新日志缓冲
+func (gl *GormLogger) Sync() {
+	if gl.zLogger != nil {
+		_ = gl.zLogger.Sync()
+	}
+}
+
 // parsePostgreSQLHostPort parses given input in various forms defined in
 // https://www.postgresql.org/docs/current/static/libpq-connect.html#LIBPQ-CONNSTRING
 // and returns proper host and port number.
@@ -167,5 +179,21 @@ func initGormDB(w logger.Writer) {
 		panic("未定义的数据库类型:" + DatabaseCfg.Type)
 	}
 
+	gormDB = db
 	crud.InitDbEngine(db)
 }
+
+// closeDatabase 关闭数据库连接池, 未初始化时忽略
+func closeDatabase() {
+	if gormDB == nil {
+		return
+	}
+	sqlDB, err := gormDB.DB()
+	if err != nil {
+		g3.ZL().Error("get sql db failed", zap.Error(err))
+		return
+	}
+	if err = sqlDB.Close(); err != nil {
+		g3.ZL().Error("close database failed", zap.Error(err))
+	}
+}
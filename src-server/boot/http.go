@@ -6,6 +6,7 @@
 package boot
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 
 	"github.com/zhouhp1295/g3"
@@ -16,6 +17,7 @@ import (
 	"net/http"
 	"os/exec"
 	"strings"
+	"time"
 )
 
 func init() {
@@ -25,8 +27,11 @@ func init() {
 	App.RunMode = "dev"
 	preStart = preHttpStart
 	start = startHttp
+	stop = stopHttp
 }
 
+var httpServer *http.Server
+
 func loadHttpConfig() {
 	iniPath := g3.AssetPath("conf/http.ini")
 	if !utils.IsExist(iniPath) {
@@ -45,6 +50,9 @@ func loadHttpConfig() {
 	if err = iniFile.Section("server").MapTo(&ServerCfg); err != nil {
 		panic(err)
 	}
+	if len(ServerCfg.NodeId) > 0 {
+		App.Identifier = ServerCfg.NodeId
+	}
 }
 
 func checkInstall(context *gin.Context) {
@@ -70,7 +78,7 @@ func preHttpStart() {
 	// 安装引导
 	g3.GetGin().Engine.Use(checkInstall)
 	g3.GetGin().Group("/api").NewJwt(JwtCfg.AdminSecret, JwtCfg.ExpiredSeconds)
-	g3.GetGin().Group("/api/game").NewJwt(JwtCfg.GameSecret, JwtCfg.ExpiredSeconds)
+	g3.GetGin().Group("/api/game").NewJwt(JwtCfg.GameSecret, int64(JwtCfg.GameAccessTTL()/time.Second))
 	// 初始化
 	if IsInstalled() {
 		DoAfterInstall()
@@ -84,8 +92,22 @@ func preHttpStart() {
 }
 
 func startHttp() {
-	err := g3.GetGin().Engine.Run(ServerCfg.HTTPAddr + ":" + ServerCfg.HTTPPort)
-	if err != nil {
+	httpServer = &http.Server{
+		Addr:    ServerCfg.HTTPAddr + ":" + ServerCfg.HTTPPort,
+		Handler: g3.GetGin().Engine,
+	}
+	err := httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		g3.ZL().Fatal("Application Stopped", zap.Error(err))
 	}
 }
+
+// stopHttp 停止监听并等待处理中的请求完成
+func stopHttp(ctx context.Context) {
+	if httpServer == nil {
+		return
+	}
+	if err := httpServer.Shutdown(ctx); err != nil {
+		g3.ZL().Error("http shutdown failed", zap.Error(err))
+	}
+}
@@ -6,18 +6,26 @@
 package boot
 
 import (
+	"context"
 	"errors"
-	jsoniter "github.com/json-iterator/go"
 	"github.com/zhouhp1295/g3"
 	"github.com/zhouhp1295/g3-game/utils"
 	"github.com/zhouhp1295/g3/net"
 	"go.uber.org/zap"
 	"gopkg.in/ini.v1"
 	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const UserAuthRouter = "user/auth"
+const (
+	UserAuthRouter   = "user/auth"
+	UserResumeRouter = "user/resume"
+)
 
 var (
 	worker *net.WsWorker
@@ -34,8 +42,90 @@ var (
 	wsDefaultResp = WsResponseMsg{
 		Msg: "Coming soon ...",
 	}
+
+	wsPanicCount int64
 )
 
+type websocketConfig struct {
+	PingInterval int `ini:"PING_INTERVAL"`
+	PongWait     int `ini:"PONG_WAIT"`
+	IdleTimeout  int `ini:"IDLE_TIMEOUT"`
+	// SendQueueSize 每个连接的发送队列长度
+	SendQueueSize int `ini:"SEND_QUEUE_SIZE"`
+	// SendOverflow 发送队列已满时的处理: drop 丢弃消息, close 断开连接
+	SendOverflow string `ini:"SEND_OVERFLOW"`
+	// LoginPolicy 同一账号重复登录: kick 踢掉旧连接, reject 拒绝新连接, multiple 允许多端在线
+	LoginPolicy string `ini:"LOGIN_POLICY"`
+	// SessionGrace 断线后会话保留时长, 期间可通过user/resume恢复, 0为关闭
+	SessionGrace int `ini:"SESSION_GRACE"`
+	// SessionBuffer 每个会话缓存的推送条数
+	SessionBuffer int `ini:"SESSION_BUFFER"`
+	// MaxConnections 最大连接数, MaxConnsPerIP 单个IP最大连接数, 0为不限制
+	MaxConnections int `ini:"MAX_CONNECTIONS"`
+	MaxConnsPerIP  int `ini:"MAX_CONNS_PER_IP"`
+	// 每秒消息数及突发数, 分别按连接、IP、连接+router限制, 0为不限制
+	ConnRate    float64 `ini:"CONN_RATE"`
+	ConnBurst   int     `ini:"CONN_BURST"`
+	IPRate      float64 `ini:"IP_RATE"`
+	IPBurst     int     `ini:"IP_BURST"`
+	RouterRate  float64 `ini:"ROUTER_RATE"`
+	RouterBurst int     `ini:"ROUTER_BURST"`
+	// RateLimitDisconnect 超过限制时断开连接
+	RateLimitDisconnect bool `ini:"RATE_LIMIT_DISCONNECT"`
+	// TrustedProxies 受信任的反向代理IP或CIDR, 来自这些地址的请求从代理头获取客户端IP
+	TrustedProxies []string `ini:"TRUSTED_PROXIES" delim:","`
+	// 压缩: 压缩级别及开启压缩的最小字节数
+	EnableCompression    bool `ini:"ENABLE_COMPRESSION"`
+	CompressionLevel     int  `ini:"COMPRESSION_LEVEL"`
+	CompressionThreshold int  `ini:"COMPRESSION_THRESHOLD"`
+	// ReadLimit 单条消息最大字节数
+	ReadLimit int64 `ini:"READ_LIMIT"`
+	// 读写缓冲区大小, WriteBufferPool 写缓冲区在连接间复用
+	ReadBufferSize  int  `ini:"READ_BUFFER_SIZE"`
+	WriteBufferSize int  `ini:"WRITE_BUFFER_SIZE"`
+	WriteBufferPool bool `ini:"WRITE_BUFFER_POOL"`
+	// AllowedOrigins 允许的Origin, 为空时不限制
+	AllowedOrigins []string `ini:"ALLOWED_ORIGINS" delim:","`
+	// RouterLimits 按router单独设置的限流, 来自[websocket.router_limit]
+	RouterLimits map[string]wsRateLimit `ini:"-"`
+}
+
+type wsRateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// routerLimit router的限流设置, 未单独设置时使用ROUTER_RATE/ROUTER_BURST
+func (cfg *websocketConfig) routerLimit(router string) wsRateLimit {
+	if limit, ok := cfg.RouterLimits[router]; ok {
+		return limit
+	}
+	return wsRateLimit{Rate: cfg.RouterRate, Burst: cfg.RouterBurst}
+}
+
+func (cfg *websocketConfig) overflowPolicy() net.WsOverflowPolicy {
+	if strings.EqualFold(cfg.SendOverflow, "close") {
+		return net.WsOverflowClose
+	}
+	return net.WsOverflowDrop
+}
+
+func (cfg *websocketConfig) loginPolicy() net.WsLoginPolicy {
+	switch strings.ToLower(cfg.LoginPolicy) {
+	case "reject":
+		return net.WsLoginRejectNew
+	case "multiple":
+		return net.WsLoginAllowMultiple
+	}
+	return net.WsLoginKickOld
+}
+
+// WebsocketCfg websocket设置, 时间单位均为秒
+var WebsocketCfg websocketConfig
+
+// WebsocketFile websocket.ini, 供模块读取各自的配置段
+var WebsocketFile *ini.File
+
 type WsRouterHandler func(*net.WsWorker, *net.WsConn, WsRequestMsg)
 
 func RegisterWsAuthHandler(handler WsRouterHandler) {
@@ -51,10 +141,22 @@ func RegisterWsRouterHandler(router string, handler WsRouterHandler) {
 }
 
 type WsRequestMsg struct {
+	// Id 客户端生成的请求id, 响应中原样返回, 用于匹配并发请求, 可为空
+	Id     string                 `json:"id"`
 	Router string                 `json:"router"`
 	Params map[string]interface{} `json:"params"`
 }
 
+// Ok 响应成功
+func (wr *WsRequestMsg) Ok(conn *net.WsConn, data interface{}) {
+	conn.Reply(wr.Id, wr.Router, data)
+}
+
+// Failed 以指定错误码响应失败
+func (wr *WsRequestMsg) Failed(conn *net.WsConn, code int, msg string) {
+	conn.Error(wr.Id, wr.Router, code, msg)
+}
+
 func (wr *WsRequestMsg) Get(key string) (interface{}, error) {
 	if wr.Params == nil {
 		g3.ZL().Error("get value failed. params is empty",
@@ -85,7 +187,112 @@ func (wr *WsRequestMsg) GetString(key string) (string, error) {
 	return str, nil
 }
 
+// GetInt64 兼容不同编解码解析出的数值类型
+func (wr *WsRequestMsg) GetInt64(key string) (int64, error) {
+	v, err := wr.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := toInt64(v)
+	if !ok {
+		g3.ZL().Error("get int64 failed. type is not incorrect",
+			zap.Reflect("key", key))
+		return 0, errors.New("value type is incorrect")
+	}
+	return n, nil
+}
+
+// GetInt64Slice 数值数组
+func (wr *WsRequestMsg) GetInt64Slice(key string) ([]int64, error) {
+	v, err := wr.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	values, ok := v.([]interface{})
+	if !ok {
+		g3.ZL().Error("get int64 slice failed. type is not incorrect",
+			zap.Reflect("key", key))
+		return nil, errors.New("value type is incorrect")
+	}
+	result := make([]int64, 0, len(values))
+	for _, value := range values {
+		n, ok := toInt64(value)
+		if !ok {
+			g3.ZL().Error("get int64 slice failed. element type is not incorrect",
+				zap.Reflect("key", key))
+			return nil, errors.New("value type is incorrect")
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+func (wr *WsRequestMsg) GetBool(key string) (bool, error) {
+	v, err := wr.Get(key)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		g3.ZL().Error("get bool failed. type is not incorrect",
+			zap.Reflect("key", key))
+		return false, errors.New("value type is incorrect")
+	}
+	return b, nil
+}
+
+// GetFloat64 浮点数, 兼容整数类型
+func (wr *WsRequestMsg) GetFloat64(key string) (float64, error) {
+	v, err := wr.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	}
+	n, ok := toInt64(v)
+	if !ok {
+		g3.ZL().Error("get float64 failed. type is not incorrect",
+			zap.Reflect("key", key))
+		return 0, errors.New("value type is incorrect")
+	}
+	return float64(n), nil
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case float32:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
 type WsResponseMsg struct {
+	Id     string                 `json:"id,omitempty"`
+	Push   bool                   `json:"push,omitempty"`
 	Router string                 `json:"router"`
 	Code   int                    `json:"code"`
 	Msg    string                 `json:"msg"`
@@ -99,6 +306,7 @@ func init() {
 	App.RunMode = "dev"
 	preStart = preWebsocketStart
 	start = startWebsocket
+	stop = stopWebsocket
 	wsRouterHandlers = make(map[string][]WsRouterHandler)
 }
 
@@ -120,37 +328,103 @@ func loadWebsocketConfig() {
 	if err = iniFile.Section("server").MapTo(&ServerCfg); err != nil {
 		panic(err)
 	}
+	if len(ServerCfg.NodeId) > 0 {
+		App.Identifier = ServerCfg.NodeId
+	}
+	// ***************************
+	// ----- WebsocketCfg settings -----
+	// ***************************
+	WebsocketFile = iniFile
+	if err = iniFile.Section("websocket").MapTo(&WebsocketCfg); err != nil {
+		panic(err)
+	}
+	WebsocketCfg.RouterLimits = make(map[string]wsRateLimit)
+	for _, key := range iniFile.Section("websocket.router_limit").Keys() {
+		values := strings.Split(key.String(), ",")
+		if len(values) != 2 {
+			panic("router限流配置错误:" + key.Name() + "=" + key.String())
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(values[0]), 64)
+		if err != nil {
+			panic("router限流配置错误:" + key.Name() + "=" + key.String())
+		}
+		burst, err := strconv.Atoi(strings.TrimSpace(values[1]))
+		if err != nil {
+			panic("router限流配置错误:" + key.Name() + "=" + key.String())
+		}
+		WebsocketCfg.RouterLimits[key.Name()] = wsRateLimit{Rate: rate, Burst: burst}
+	}
+}
+
+// WsPanicCount 处理消息时发生panic的次数
+func WsPanicCount() int64 {
+	return atomic.LoadInt64(&wsPanicCount)
+}
+
+// recoverWsPanic 记录panic并以内部错误响应, 连接保持不断开
+func recoverWsPanic(conn *net.WsConn, msg WsRequestMsg, err interface{}) {
+	atomic.AddInt64(&wsPanicCount, 1)
+	g3.ZL().Error("ws handler panic",
+		zap.String("router", msg.Router),
+		zap.String("id", msg.Id),
+		zap.String("uuid", conn.Uuid),
+		zap.Int64("uid", conn.Uid),
+		zap.Reflect("error", err),
+		zap.ByteString("stack", debug.Stack()))
+	msg.Failed(conn, net.WsErrorInternal, "internal error")
 }
 
 func onWsMessage(conn *net.WsConn, msg []byte) {
 	conn.Mutex.Lock()
 	defer conn.Mutex.Unlock()
 	reqParams := WsRequestMsg{}
-	err := jsoniter.Unmarshal(msg, &reqParams)
+	// 单条消息的panic不影响连接及后续消息
+	defer func() {
+		if err := recover(); err != nil {
+			recoverWsPanic(conn, reqParams, err)
+		}
+	}()
+	// 按连接握手时协商的编解码解析
+	err := conn.Codec().Unmarshal(msg, &reqParams)
 	if err != nil {
 		g3.ZL().Error("on message err .", zap.Error(err))
 		worker.Close(conn)
 		return
 	}
 	if net.WsAuthing == conn.Status {
-		// 新的链接，第一个动作必须是授权验证
+		// 新的链接，第一个动作必须是授权验证或恢复会话
+		if UserResumeRouter == reqParams.Router && worker.SessionEnabled() {
+			dispatchWsMessage(conn, reqParams, []WsHandlerFunc{wrapWsRouterHandler(onWsResume)})
+			if net.WsConnected == conn.Status {
+				onWsAuthed(conn, true)
+			}
+			return
+		}
 		if wsAuthHandler == nil || UserAuthRouter != reqParams.Router {
 			g3.ZL().Error("websocket auth handler undefined.")
-			conn.Failed(reqParams.Router, "auth failed")
+			reqParams.Failed(conn, net.WsErrorUnauthorized, "auth failed")
 			worker.Close(conn)
 			return
 		}
-		wsAuthHandler(worker, conn, reqParams)
+		dispatchWsMessage(conn, reqParams, []WsHandlerFunc{wrapWsRouterHandler(wsAuthHandler)})
+		if net.WsConnected == conn.Status {
+			onWsAuthed(conn, false)
+		}
 		return
 	}
-	if funcList, ok := wsRouterHandlers[reqParams.Router]; ok {
+	wsRouterHandlerMutex.Lock()
+	funcList, ok := wsRouterHandlers[reqParams.Router]
+	wsRouterHandlerMutex.Unlock()
+	if ok {
+		handlers := make([]WsHandlerFunc, 0, len(funcList))
 		for _, f := range funcList {
-			f(worker, conn, reqParams)
+			handlers = append(handlers, wrapWsRouterHandler(f))
 		}
+		dispatchWsMessage(conn, reqParams, handlers)
 	} else {
 		g3.ZL().Warn("undefined router. please check.",
 			zap.String("router", reqParams.Router))
-		conn.Failed(reqParams.Router, "undefined router")
+		reqParams.Failed(conn, net.WsErrorNotFound, "undefined router")
 	}
 }
 
@@ -160,17 +434,119 @@ func preWebsocketStart() {
 	}
 	// 加载配置
 	loadWebsocketConfig()
+	// 数据库, 用于保存回放等
+	InitDatabase()
 	// 启动
 	var err error
-	worker, err = net.HandleWebsocket("/", net.WithWsMessaged(onWsMessage))
+	worker, err = net.HandleWebsocket("/",
+		net.WithWsMessaged(onWsMessage),
+		net.WithWsConnected(onWsConnected),
+		net.WithWsClosed(onWsClosed),
+		net.WithWsHeartbeat(
+			time.Duration(WebsocketCfg.PingInterval)*time.Second,
+			time.Duration(WebsocketCfg.PongWait)*time.Second),
+		net.WithWsIdleTimeout(time.Duration(WebsocketCfg.IdleTimeout)*time.Second),
+		net.WithWsSendQueue(WebsocketCfg.SendQueueSize, WebsocketCfg.overflowPolicy()),
+		net.WithWsLoginPolicy(WebsocketCfg.loginPolicy()),
+		net.WithWsSession(time.Duration(WebsocketCfg.SessionGrace)*time.Second, WebsocketCfg.SessionBuffer),
+		net.WithWsMaxConnections(WebsocketCfg.MaxConnections, WebsocketCfg.MaxConnsPerIP),
+		net.WithWsConnRateLimit(WebsocketCfg.ConnRate, WebsocketCfg.ConnBurst),
+		net.WithWsIPRateLimit(WebsocketCfg.IPRate, WebsocketCfg.IPBurst),
+		net.WithWsRateLimitDisconnect(WebsocketCfg.RateLimitDisconnect),
+		net.WithWsTrustedProxies(WebsocketCfg.TrustedProxies...),
+		net.WithWsCompression(WebsocketCfg.EnableCompression, WebsocketCfg.CompressionLevel, WebsocketCfg.CompressionThreshold),
+		net.WithWsReadLimit(WebsocketCfg.ReadLimit),
+		net.WithWsBufferSize(WebsocketCfg.ReadBufferSize, WebsocketCfg.WriteBufferSize, WebsocketCfg.WriteBufferPool),
+		net.WithWsAllowedOrigins(WebsocketCfg.AllowedOrigins...),
+	)
 	if err != nil {
 		g3.ZL().Fatal("服务启动失败", zap.Error(err))
 	}
+	// 按配置对每个router限流, 先于其它中间件执行
+	wsMiddlewareMutex.Lock()
+	wsMiddlewares = append([]WsHandlerFunc{wsRouterRateLimit()}, wsMiddlewares...)
+	wsMiddlewareMutex.Unlock()
+	// 接收其它节点(含http节点)发布的推送
+	if err = Bus.Subscribe(deliverWsEnvelope); err != nil {
+		g3.ZL().Fatal("订阅消息总线失败", zap.Error(err))
+	}
 }
 
-func startWebsocket() {
-	err := http.ListenAndServe(ServerCfg.HTTPAddr+":"+ServerCfg.HTTPPort, nil)
+// onWsResume 断线重连后恢复会话, 参数 session 为授权时返回的会话token, seq 为客户端最后收到的推送序号
+// 缓存中的推送会先于本次响应补发
+func onWsResume(worker *net.WsWorker, conn *net.WsConn, msg WsRequestMsg) {
+	token, err := msg.GetString("session")
+	if err != nil {
+		msg.Failed(conn, net.WsErrorBadRequest, "parse session failed")
+		worker.Close(conn)
+		return
+	}
+	// 未收到过推送时可不传
+	lastSeq, _ := msg.GetInt64("seq")
+	if lastSeq < 0 {
+		lastSeq = 0
+	}
+	session, err := worker.Resume(conn, token, uint64(lastSeq))
 	if err != nil {
+		g3.ZL().Info("resume session failed",
+			zap.String("uuid", conn.Uuid),
+			zap.Int64("seq", lastSeq),
+			zap.Error(err))
+		switch err {
+		case net.ErrWsSessionGap:
+			msg.Failed(conn, net.WsErrorConflict, "session messages lost, please auth again")
+		case net.ErrWsUserLoggedIn:
+			msg.Failed(conn, net.WsErrorConflict, "already logged in")
+		default:
+			msg.Failed(conn, net.WsErrorUnauthorized, "session expired")
+		}
+		worker.Close(conn)
+		return
+	}
+	conn.Status = net.WsConnected
+	msg.Ok(conn, map[string]interface{}{"uid": session.Uid, "seq": session.Seq()})
+}
+
+// Worker 当前节点的websocket worker
+func Worker() *net.WsWorker {
+	return worker
+}
+
+func deliverWsEnvelope(env WsEnvelope) {
+	switch env.Target {
+	case WsTargetUser:
+		worker.SendToUser(env.Uid, env.Router, env.Data)
+	case WsTargetGroup:
+		worker.BroadcastToGroup(env.Group, env.Router, env.Data)
+	case WsTargetAll:
+		worker.BroadcastAll(env.Router, env.Data)
+	default:
+		g3.ZL().Warn("undefined bus target. please check.",
+			zap.Reflect("envelope", env))
+	}
+}
+
+var wsServer *http.Server
+
+func startWebsocket() {
+	wsServer = &http.Server{Addr: ServerCfg.HTTPAddr + ":" + ServerCfg.HTTPPort}
+	err := wsServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		g3.ZL().Fatal("Application Stopped", zap.Error(err))
 	}
 }
+
+// stopWebsocket 先停止监听, 再通知并关闭所有连接
+// websocket连接已被hijack, http.Server.Shutdown不会等待它们
+func stopWebsocket(ctx context.Context) {
+	if wsServer != nil {
+		if err := wsServer.Shutdown(ctx); err != nil {
+			g3.ZL().Error("websocket server shutdown failed", zap.Error(err))
+		}
+	}
+	if worker != nil {
+		if err := worker.Shutdown(ctx); err != nil {
+			g3.ZL().Error("websocket worker shutdown failed", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build websocket
+// +build websocket
+
+package boot
+
+import (
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3/net"
+	"go.uber.org/zap"
+	"runtime/debug"
+	"sync"
+)
+
+// WsConnHookFunc 连接建立及关闭时的回调
+type WsConnHookFunc func(worker *net.WsWorker, conn *net.WsConn)
+
+// WsAuthedHookFunc 授权成功时的回调, resumed 为true表示通过user/resume恢复的会话
+type WsAuthedHookFunc func(worker *net.WsWorker, conn *net.WsConn, resumed bool)
+
+var (
+	wsConnectedHooks []WsConnHookFunc
+	wsAuthedHooks    []WsAuthedHookFunc
+	wsClosedHooks    []WsConnHookFunc
+	wsHookMutex      sync.RWMutex
+)
+
+// RegisterWsConnectedHandler 连接建立(尚未授权)时执行
+func RegisterWsConnectedHandler(handler WsConnHookFunc) {
+	wsHookMutex.Lock()
+	wsConnectedHooks = append(wsConnectedHooks, handler)
+	wsHookMutex.Unlock()
+}
+
+// RegisterWsAuthedHandler 授权或恢复会话成功后执行, 与该连接的消息处理串行
+func RegisterWsAuthedHandler(handler WsAuthedHookFunc) {
+	wsHookMutex.Lock()
+	wsAuthedHooks = append(wsAuthedHooks, handler)
+	wsHookMutex.Unlock()
+}
+
+// RegisterWsClosedHandler 连接关闭后执行, 未授权的连接 conn.Uid 为0
+// 可能在该连接的消息处理中触发, 回调中不要再获取 conn.Mutex;
+// 开启会话恢复时, 玩家仍可能在宽限期内通过user/resume重新连接
+func RegisterWsClosedHandler(handler WsConnHookFunc) {
+	wsHookMutex.Lock()
+	wsClosedHooks = append(wsClosedHooks, handler)
+	wsHookMutex.Unlock()
+}
+
+func onWsConnected(conn *net.WsConn) {
+	wsHookMutex.RLock()
+	hooks := wsConnectedHooks
+	wsHookMutex.RUnlock()
+	for _, hook := range hooks {
+		runWsHook("connected", conn, func() {
+			hook(worker, conn)
+		})
+	}
+}
+
+func onWsAuthed(conn *net.WsConn, resumed bool) {
+	wsHookMutex.RLock()
+	hooks := wsAuthedHooks
+	wsHookMutex.RUnlock()
+	for _, hook := range hooks {
+		runWsHook("authed", conn, func() {
+			hook(worker, conn, resumed)
+		})
+	}
+}
+
+func onWsClosed(conn *net.WsConn) {
+	wsHookMutex.RLock()
+	hooks := wsClosedHooks
+	wsHookMutex.RUnlock()
+	for _, hook := range hooks {
+		runWsHook("closed", conn, func() {
+			hook(worker, conn)
+		})
+	}
+}
+
+// runWsHook 单个回调panic不影响其它回调及连接
+func runWsHook(event string, conn *net.WsConn, f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			g3.ZL().Error("ws hook panic",
+				zap.String("event", event),
+				zap.String("uuid", conn.Uuid),
+				zap.Int64("uid", conn.Uid),
+				zap.Reflect("error", err),
+				zap.ByteString("stack", debug.Stack()))
+		}
+	}()
+	f()
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build websocket
+// +build websocket
+
+package boot
+
+import (
+	"fmt"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3/helpers"
+	"github.com/zhouhp1295/g3/net"
+	"go.uber.org/zap"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const wsAbortIndex = math.MaxInt8 >> 1
+
+// WsHandlerFunc 中间件及处理函数
+type WsHandlerFunc func(ctx *WsContext)
+
+// WsContext 一条消息的处理上下文, 类似gin.Context
+type WsContext struct {
+	Worker *net.WsWorker
+	Conn   *net.WsConn
+	Msg    WsRequestMsg
+	Keys   map[string]interface{}
+
+	handlers []WsHandlerFunc
+	index    int
+}
+
+// Next 执行后续的中间件及处理函数
+func (c *WsContext) Next() {
+	c.index++
+	for c.index < len(c.handlers) {
+		c.handlers[c.index](c)
+		c.index++
+	}
+}
+
+// Abort 终止后续处理
+func (c *WsContext) Abort() {
+	c.index = wsAbortIndex
+}
+
+// AbortWithError 响应错误并终止后续处理
+func (c *WsContext) AbortWithError(code int, msg string) {
+	c.Msg.Failed(c.Conn, code, msg)
+	c.Abort()
+}
+
+// IsAborted 是否已终止
+func (c *WsContext) IsAborted() bool {
+	return c.index >= wsAbortIndex
+}
+
+// Ok 响应成功
+func (c *WsContext) Ok(data interface{}) {
+	c.Msg.Ok(c.Conn, data)
+}
+
+// Failed 响应失败
+func (c *WsContext) Failed(code int, msg string) {
+	c.Msg.Failed(c.Conn, code, msg)
+}
+
+func (c *WsContext) Set(key string, value interface{}) {
+	if c.Keys == nil {
+		c.Keys = make(map[string]interface{})
+	}
+	c.Keys[key] = value
+}
+
+func (c *WsContext) Get(key string) (value interface{}, exists bool) {
+	value, exists = c.Keys[key]
+	return
+}
+
+var (
+	wsMiddlewares          []WsHandlerFunc
+	wsRouterMiddlewares    = make(map[string][]WsHandlerFunc)
+	wsMiddlewareMutex      sync.RWMutex
+	wsRateLimitMiddlewareN int64
+)
+
+// UseWsMiddleware 注册全局中间件, 对所有router(含授权)生效, 按注册顺序执行
+func UseWsMiddleware(middlewares ...WsHandlerFunc) {
+	wsMiddlewareMutex.Lock()
+	wsMiddlewares = append(wsMiddlewares, middlewares...)
+	wsMiddlewareMutex.Unlock()
+}
+
+// UseWsRouterMiddleware 注册指定router的中间件, 在全局中间件之后执行
+func UseWsRouterMiddleware(router string, middlewares ...WsHandlerFunc) {
+	wsMiddlewareMutex.Lock()
+	wsRouterMiddlewares[router] = append(wsRouterMiddlewares[router], middlewares...)
+	wsMiddlewareMutex.Unlock()
+}
+
+// wrapWsRouterHandler 将WsRouterHandler转为中间件链中的处理函数
+func wrapWsRouterHandler(handler WsRouterHandler) WsHandlerFunc {
+	return func(ctx *WsContext) {
+		handler(ctx.Worker, ctx.Conn, ctx.Msg)
+	}
+}
+
+// dispatchWsMessage 依次执行 全局中间件 -> router中间件 -> 处理函数
+func dispatchWsMessage(conn *net.WsConn, msg WsRequestMsg, handlers []WsHandlerFunc) {
+	wsMiddlewareMutex.RLock()
+	chain := make([]WsHandlerFunc, 0, len(wsMiddlewares)+len(wsRouterMiddlewares[msg.Router])+len(handlers))
+	chain = append(chain, wsMiddlewares...)
+	chain = append(chain, wsRouterMiddlewares[msg.Router]...)
+	wsMiddlewareMutex.RUnlock()
+	chain = append(chain, handlers...)
+
+	ctx := &WsContext{
+		Worker:   worker,
+		Conn:     conn,
+		Msg:      msg,
+		handlers: chain,
+		index:    -1,
+	}
+	ctx.Next()
+}
+
+// WsLogger 记录每条消息的处理耗时
+func WsLogger() WsHandlerFunc {
+	return func(ctx *WsContext) {
+		start := time.Now()
+		ctx.Next()
+		g3.ZL().Info("ws message",
+			zap.String("router", ctx.Msg.Router),
+			zap.String("id", ctx.Msg.Id),
+			zap.String("uuid", ctx.Conn.Uuid),
+			zap.Int64("uid", ctx.Conn.Uid),
+			zap.Duration("cost", time.Since(start)),
+			zap.Bool("aborted", ctx.IsAborted()))
+	}
+}
+
+// WsSlowLog 处理耗时超过threshold时记录警告
+func WsSlowLog(threshold time.Duration) WsHandlerFunc {
+	return func(ctx *WsContext) {
+		start := time.Now()
+		ctx.Next()
+		if cost := time.Since(start); cost > threshold {
+			g3.ZL().Warn("slow ws message",
+				zap.String("router", ctx.Msg.Router),
+				zap.String("uuid", ctx.Conn.Uuid),
+				zap.Int64("uid", ctx.Conn.Uid),
+				zap.Duration("cost", cost))
+		}
+	}
+}
+
+// WsRecovery 捕获后续处理中的panic, 响应错误而不断开连接
+// onWsMessage 已对整条消息做了兜底, 该中间件用于让链上之前的中间件(如WsLogger)继续执行完
+func WsRecovery() WsHandlerFunc {
+	return func(ctx *WsContext) {
+		defer func() {
+			if err := recover(); err != nil {
+				recoverWsPanic(ctx.Conn, ctx.Msg, err)
+				ctx.Abort()
+			}
+		}()
+		ctx.Next()
+	}
+}
+
+// WsAuthRequired 要求连接已完成授权
+func WsAuthRequired() WsHandlerFunc {
+	return func(ctx *WsContext) {
+		if ctx.Conn.Uid <= 0 {
+			ctx.AbortWithError(net.WsErrorUnauthorized, "unauthorized")
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// WsRateLimit 按连接+router限流, 每秒rate次, 最多突发burst次
+func WsRateLimit(rate float64, burst int) WsHandlerFunc {
+	// 每个中间件实例使用独立的令牌桶
+	prefix := fmt.Sprintf("_ws_rate_limit_%d_", atomic.AddInt64(&wsRateLimitMiddlewareN, 1))
+	return func(ctx *WsContext) {
+		if !allowWsRouter(ctx, prefix, rate, burst) {
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// wsRouterRateLimit 按配置的ROUTER_RATE及[websocket.router_limit]对连接+router限流
+func wsRouterRateLimit() WsHandlerFunc {
+	return func(ctx *WsContext) {
+		limit := WebsocketCfg.routerLimit(ctx.Msg.Router)
+		if limit.Rate > 0 && !allowWsRouter(ctx, "_ws_router_limit_", limit.Rate, limit.Burst) {
+			if WebsocketCfg.RateLimitDisconnect {
+				ctx.Worker.Close(ctx.Conn)
+			}
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// allowWsRouter 取连接+router的令牌, 不足时响应错误并终止
+func allowWsRouter(ctx *WsContext, prefix string, rate float64, burst int) bool {
+	// 同一连接的消息在conn.Mutex下串行处理, 可以直接读写conn.Data
+	key := prefix + ctx.Msg.Router
+	bucket, ok := ctx.Conn.Data[key].(*helpers.TokenBucket)
+	if !ok {
+		bucket = helpers.NewTokenBucket(rate, burst)
+		ctx.Conn.Data[key] = bucket
+	}
+	if !bucket.Allow() {
+		g3.ZL().Warn("ws router rate limited",
+			zap.String("router", ctx.Msg.Router),
+			zap.String("uuid", ctx.Conn.Uuid),
+			zap.Int64("uid", ctx.Conn.Uid),
+			zap.String("ip", ctx.Conn.IP))
+		ctx.AbortWithError(net.WsErrorTooManyRequests, "too many requests")
+		return false
+	}
+	return true
+}
@@ -4,6 +4,7 @@ package game
 
 import (
 	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/modules/game/migrations"
 	"github.com/zhouhp1295/g3-game/modules/game/model"
 	_ "github.com/zhouhp1295/g3-game/modules/game/routers"
 	"github.com/zhouhp1295/g3/crud"
@@ -11,12 +12,25 @@ import (
 )
 
 func DoMigrate() {
+	crud.DoMigrate(migrations.M20261018GamePlayerDataCode, migrations.M20261018GamePlayerData())
+	crud.DoMigrate(migrations.M20261018GameItemCode, migrations.M20261018GameItem())
+	crud.DoMigrate(migrations.M20261018GameWalletCode, migrations.M20261018GameWallet())
+	crud.DoMigrate(migrations.M20261018GameReplayCode, migrations.M20261018GameReplay())
+	crud.DoMigrate(migrations.M20261018GameUserUsernameCode, migrations.M20261018GameUserUsername())
 }
 
 func SyncTables() {
 	//初始化数据结构
 	tables := []interface{}{
 		new(model.GameUser),
+		new(model.GameReplay),
+		new(model.GameRefreshToken),
+		new(model.GamePlayerData),
+		new(model.GameItem),
+		new(model.GameInventory),
+		new(model.GameItemLog),
+		new(model.GameWallet),
+		new(model.GameLedger),
 	}
 	err := crud.SyncTables(crud.DbSess(), tables)
 	if err != nil {
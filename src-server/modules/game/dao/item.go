@@ -0,0 +1,131 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package dao
+
+import (
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3/crud"
+	"regexp"
+)
+
+var itemCodePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.\-]{0,31}$`)
+
+type gameItemDAO struct {
+	crud.BaseDao
+}
+
+var GameItemDao = &gameItemDAO{
+	crud.BaseDao{Model: new(model.GameItem)},
+}
+
+func (dao *gameItemDAO) BeforeInsert(m crud.ModelInterface) (ok bool, msg string) {
+	if _m, _ok := m.(*model.GameItem); _ok {
+		if !itemCodePattern.MatchString(_m.Code) {
+			msg = "物品代码须以字母开头, 由1-32位字母、数字或_.-组成"
+			return
+		}
+		if dao.CountByColumn("code", _m.Code) > 0 {
+			msg = "已存在物品代码"
+			return
+		}
+		return dao.check(_m)
+	}
+	return
+}
+
+func (dao *gameItemDAO) BeforeUpdate(m crud.ModelInterface) (ok bool, msg string) {
+	if _m, _ok := m.(*model.GameItem); _ok {
+		return dao.check(_m)
+	}
+	return
+}
+
+func (dao *gameItemDAO) check(m *model.GameItem) (ok bool, msg string) {
+	if len(m.Name) == 0 {
+		msg = "名称不能为空"
+		return
+	}
+	if len(m.Type) == 0 {
+		m.Type = model.ItemTypeMisc
+	}
+	valid := false
+	for _, t := range model.ItemTypes {
+		valid = valid || t == m.Type
+	}
+	if !valid {
+		msg = "物品类型错误"
+		return
+	}
+	if m.MaxStack < 0 {
+		msg = "堆叠上限不能小于0"
+		return
+	}
+	ok = true
+	return
+}
+
+// FindByCode 按代码查询, 包括已停用的
+func (dao *gameItemDAO) FindByCode(code string) *model.GameItem {
+	m, _ := dao.FindOneByColumn("code", code).(*model.GameItem)
+	return m
+}
+
+// FindEnabled 所有启用的物品, 按排序
+func (dao *gameItemDAO) FindEnabled() []model.GameItem {
+	items := make([]model.GameItem, 0)
+	crud.DbSess().
+		Where("status = ? AND deleted = ?", crud.FlagYes, crud.FlagNo).
+		Order("sort, id").
+		Find(&items)
+	return items
+}
+
+// FindByIds 按id查询, 包括已停用及已删除的, 用于展示背包及记录
+func (dao *gameItemDAO) FindByIds(ids []int64) map[int64]model.GameItem {
+	result := make(map[int64]model.GameItem, len(ids))
+	if len(ids) == 0 {
+		return result
+	}
+	items := make([]model.GameItem, 0, len(ids))
+	crud.DbSess().Where("id IN ?", ids).Find(&items)
+	for _, item := range items {
+		result[item.Id] = item
+	}
+	return result
+}
+
+type gameInventoryDAO struct {
+	crud.BaseDao
+}
+
+var GameInventoryDao = &gameInventoryDAO{
+	crud.BaseDao{Model: new(model.GameInventory)},
+}
+
+// FindByUid 玩家持有的物品, 不包括数量为0的
+func (dao *gameInventoryDAO) FindByUid(uid int64) []model.GameInventory {
+	rows := make([]model.GameInventory, 0)
+	crud.DbSess().
+		Where("uid = ? AND quantity > 0 AND deleted = ?", uid, crud.FlagNo).
+		Order("item_id").
+		Find(&rows)
+	return rows
+}
+
+type gameItemLogDAO struct {
+	crud.BaseDao
+}
+
+var GameItemLogDao = &gameItemLogDAO{
+	crud.BaseDao{Model: new(model.GameItemLog)},
+}
+
+// FindByOpId 玩家某次操作的记录
+func (dao *gameItemLogDAO) FindByOpId(uid int64, opId string) []model.GameItemLog {
+	rows := make([]model.GameItemLog, 0)
+	crud.DbSess().
+		Where("uid = ? AND op_id = ? AND deleted = ?", uid, opId, crud.FlagNo).
+		Order("id").
+		Find(&rows)
+	return rows
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package dao
+
+import (
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3/crud"
+)
+
+type gamePlayerDataDAO struct {
+	crud.BaseDao
+}
+
+var GamePlayerDataDao = &gamePlayerDataDAO{
+	crud.BaseDao{Model: new(model.GamePlayerData)},
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package dao
+
+import (
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3/crud"
+	"time"
+)
+
+type gameRefreshTokenDAO struct {
+	crud.BaseDao
+}
+
+var GameRefreshTokenDao = &gameRefreshTokenDAO{
+	crud.BaseDao{Model: new(model.GameRefreshToken)},
+}
+
+// FindByHash 按token哈希查询, 包括已轮换及已吊销的
+func (dao *gameRefreshTokenDAO) FindByHash(hash string) *model.GameRefreshToken {
+	tokens := make([]model.GameRefreshToken, 0, 1)
+	crud.DbSess().Where("token_hash = ? AND deleted = ?", hash, crud.FlagNo).Limit(1).Find(&tokens)
+	if len(tokens) == 0 {
+		return nil
+	}
+	return &tokens[0]
+}
+
+// MarkUsed 标记为已轮换, 仅当未轮换且未吊销时成功, 并发刷新时只有一个请求能成功
+func (dao *gameRefreshTokenDAO) MarkUsed(id int64, at time.Time) bool {
+	result := crud.DbSess().Table(dao.Model.Table()).
+		Where("id = ? AND used = ? AND status = ?", id, crud.FlagNo, crud.FlagYes).
+		Updates(map[string]interface{}{"used": crud.FlagYes, "used_at": at})
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// RevokeFamily 吊销同一家族的全部token
+func (dao *gameRefreshTokenDAO) RevokeFamily(family string) int64 {
+	return crud.DbSess().Table(dao.Model.Table()).
+		Where("family = ? AND status = ?", family, crud.FlagYes).
+		Update("status", crud.FlagNo).RowsAffected
+}
+
+// RevokeUser 吊销玩家的全部token
+func (dao *gameRefreshTokenDAO) RevokeUser(uid int64) int64 {
+	return crud.DbSess().Table(dao.Model.Table()).
+		Where("uid = ? AND status = ?", uid, crud.FlagYes).
+		Update("status", crud.FlagNo).RowsAffected
+}
+
+// PurgeExpired 物理删除玩家已过期的token
+func (dao *gameRefreshTokenDAO) PurgeExpired(uid int64, before time.Time) {
+	crud.DbSess().Where("uid = ? AND expires_at < ?", uid, before).Delete(new(model.GameRefreshToken))
+}
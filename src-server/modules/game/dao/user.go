@@ -5,6 +5,7 @@ package dao
 import (
 	"github.com/zhouhp1295/g3-game/modules/game/model"
 	"github.com/zhouhp1295/g3/crud"
+	"strings"
 )
 
 type gameUserDAO struct {
@@ -15,10 +16,52 @@ var GameUserDao = &gameUserDAO{
 	crud.BaseDao{Model: new(model.GameUser)},
 }
 
+// Taken 唯一列username、email、phone的值是否已被其它账户占用, 包含已删除的账户
+func (dao *gameUserDAO) Taken(column, value string, excludeId int64) bool {
+	var cnt int64
+	crud.DbSess().Table(dao.Model.Table()).
+		Where(column+" = ? AND id <> ?", value, excludeId).
+		Count(&cnt)
+	return cnt > 0
+}
+
+// normalize 用户名及邮箱转为小写, 空的邮箱及手机号改为NULL
+func (dao *gameUserDAO) normalize(m *model.GameUser) {
+	m.Username = strings.ToLower(m.Username)
+	if m.Email != nil {
+		if email := strings.ToLower(strings.TrimSpace(*m.Email)); len(email) > 0 {
+			m.Email = &email
+		} else {
+			m.Email = nil
+		}
+	}
+	if m.Phone != nil {
+		if phone := strings.TrimSpace(*m.Phone); len(phone) > 0 {
+			m.Phone = &phone
+		} else {
+			m.Phone = nil
+		}
+	}
+}
+
+// checkUnique 检查唯一列, 返回错误信息
+func (dao *gameUserDAO) checkUnique(m *model.GameUser) string {
+	if len(m.Username) > 0 && dao.Taken("username", m.Username, m.Id) {
+		return "用户名已存在"
+	}
+	if m.Email != nil && dao.Taken("email", *m.Email, m.Id) {
+		return "邮箱已被其它账号绑定"
+	}
+	if m.Phone != nil && dao.Taken("phone", *m.Phone, m.Id) {
+		return "手机号已被其它账号绑定"
+	}
+	return ""
+}
+
 func (dao *gameUserDAO) BeforeInsert(m crud.ModelInterface) (ok bool, msg string) {
 	if _m, _ok := m.(*model.GameUser); _ok {
-		if dao.CountByColumn("username", _m.Username) > 0 {
-			msg = "用户名已存在"
+		dao.normalize(_m)
+		if msg = dao.checkUnique(_m); len(msg) > 0 {
 			return
 		}
 		ok = true
@@ -28,11 +71,26 @@ func (dao *gameUserDAO) BeforeInsert(m crud.ModelInterface) (ok bool, msg string
 
 func (dao *gameUserDAO) BeforeUpdate(m crud.ModelInterface) (ok bool, msg string) {
 	if _m, _ok := m.(*model.GameUser); _ok {
-		if dao.CountByColumn("username", _m.Username) > 1 {
-			msg = "用户名已存在"
+		dao.normalize(_m)
+		if msg = dao.checkUnique(_m); len(msg) > 0 {
 			return
 		}
 		ok = true
 	}
 	return
 }
+
+// AfterDelete 吊销全部refresh token, 并释放设备id、邮箱及手机号, 之后可由其它账号使用
+func (dao *gameUserDAO) AfterDelete(m crud.ModelInterface) (ok bool, msg string) {
+	_m, _ok := m.(*model.GameUser)
+	if !_ok {
+		return true, ""
+	}
+	GameRefreshTokenDao.RevokeUser(_m.Id)
+	ok = crud.DbSess().Table(_m.Table()).Where("id = ?", _m.Id).Updates(map[string]interface{}{
+		"device_id": nil,
+		"email":     nil,
+		"phone":     nil,
+	}).Error == nil
+	return
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package dao
+
+import (
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3/crud"
+)
+
+type gameWalletDAO struct {
+	crud.BaseDao
+}
+
+var GameWalletDao = &gameWalletDAO{
+	crud.BaseDao{Model: new(model.GameWallet)},
+}
+
+// FindByUid 玩家的所有钱包
+func (dao *gameWalletDAO) FindByUid(uid int64) []model.GameWallet {
+	rows := make([]model.GameWallet, 0)
+	crud.DbSess().Where("uid = ? AND deleted = ?", uid, crud.FlagNo).Find(&rows)
+	return rows
+}
+
+type gameLedgerDAO struct {
+	crud.BaseDao
+}
+
+var GameLedgerDao = &gameLedgerDAO{
+	crud.BaseDao{Model: new(model.GameLedger)},
+}
+
+// FindRecent 玩家最近的limit条分录, 可按货币过滤
+func (dao *gameLedgerDAO) FindRecent(uid int64, currency string, limit int) []model.GameLedger {
+	rows := make([]model.GameLedger, 0, limit)
+	sess := crud.DbSess().Where("uid = ? AND deleted = ?", uid, crud.FlagNo)
+	if len(currency) > 0 {
+		sess = sess.Where("currency = ?", currency)
+	}
+	sess.Order("id DESC").Limit(limit).Find(&rows)
+	return rows
+}
+
+// FindByRefKey 幂等键对应的分录
+func (dao *gameLedgerDAO) FindByRefKey(refKey string) *model.GameLedger {
+	rows := make([]model.GameLedger, 0, 1)
+	crud.DbSess().Where("ref_key = ? AND deleted = ?", refKey, crud.FlagNo).Limit(1).Find(&rows)
+	if len(rows) == 0 {
+		return nil
+	}
+	return &rows[0]
+}
+
+// FindByTxId 同一交易的所有分录
+func (dao *gameLedgerDAO) FindByTxId(txId string) []model.GameLedger {
+	rows := make([]model.GameLedger, 0, 2)
+	crud.DbSess().Where("tx_id = ? AND deleted = ?", txId, crud.FlagNo).Order("id").Find(&rows)
+	return rows
+}
@@ -12,8 +12,9 @@ type wsJwtClaims struct {
 	Uid int64
 }
 
-func NewWsJwtToken(secret []byte, uid int64) (string, error) {
-	expiredTime := time.Now().Add(time.Hour * 24 * 365)
+// NewWsJwtToken websocket鉴权token, 只在建立连接时校验, 过期后需通过refresh token重新获取
+func NewWsJwtToken(secret []byte, uid int64, expires time.Duration) (string, error) {
+	expiredTime := time.Now().Add(expires)
 	claims := wsJwtClaims{
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expiredTime.Unix(),
@@ -0,0 +1,341 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package leaderboard
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// keyPrefix 有序集合的key前缀, 赛季榜在后面追加":赛季"
+	keyPrefix = "K-Game-Leaderboard-"
+
+	// SeasonCurrent 当前赛季, 查询时season为空等同于当前赛季
+	SeasonCurrent = "current"
+	// SeasonPrevious 上一赛季
+	SeasonPrevious = "previous"
+
+	// MaxLimit 单次查询的最大条数
+	MaxLimit = 100
+	// DefaultLimit 未指定条数时使用
+	DefaultLimit = 10
+)
+
+var (
+	ErrUnknownBoard     = errors.New("unknown leaderboard")
+	ErrInvalidSeason    = errors.New("invalid season")
+	ErrSubmitForbidden  = errors.New("leaderboard does not accept client submit")
+	ErrUnknownPolicy    = errors.New("unknown leaderboard policy")
+	ErrUnknownPeriod    = errors.New("unknown leaderboard period")
+	ErrScoreNotFinite   = errors.New("score must be a finite number")
+	ErrNegativeSumScore = errors.New("score of sum leaderboard must not be negative")
+)
+
+// Policy 提交分数时的更新策略
+type Policy string
+
+const (
+	// PolicyBest 保留最高分
+	PolicyBest Policy = "best"
+	// PolicySum 累加
+	PolicySum Policy = "sum"
+	// PolicyLatest 以最后一次为准
+	PolicyLatest Policy = "latest"
+)
+
+// Period 赛季周期, 每个赛季使用单独的有序集合, 到期自动清除
+type Period string
+
+const (
+	// PeriodNone 不重置
+	PeriodNone Period = "none"
+	// PeriodDaily 每天0点重置
+	PeriodDaily Period = "daily"
+	// PeriodWeekly 每周一0点重置
+	PeriodWeekly Period = "weekly"
+)
+
+// Board 排行榜配置
+type Board struct {
+	Name   string `json:"name"`
+	Policy Policy `json:"policy"`
+	Period Period `json:"period"`
+	// Keep 赛季结束后保留的赛季数, 默认1即可查询上一赛季
+	Keep int `json:"keep"`
+	// ClientSubmit 是否允许客户端直接提交, 否则只能由服务端逻辑提交
+	ClientSubmit bool `json:"clientSubmit"`
+}
+
+func (board *Board) check() error {
+	switch board.Policy {
+	case "":
+		board.Policy = PolicyBest
+	case PolicyBest, PolicySum, PolicyLatest:
+	default:
+		return ErrUnknownPolicy
+	}
+	switch board.Period {
+	case "":
+		board.Period = PeriodNone
+	case PeriodNone, PeriodDaily, PeriodWeekly:
+	default:
+		return ErrUnknownPeriod
+	}
+	if board.Keep < 0 {
+		board.Keep = 0
+	}
+	return nil
+}
+
+// season 时间t所在的赛季: 每日为20060102, 每周为ISO周2006W01
+func (board *Board) season(t time.Time) string {
+	switch board.Period {
+	case PeriodDaily:
+		return t.Format("20060102")
+	case PeriodWeekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04dW%02d", year, week)
+	}
+	return ""
+}
+
+// seasonStart 时间t所在赛季的开始时间
+func (board *Board) seasonStart(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if board.Period == PeriodWeekly {
+		weekday := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -weekday)
+	}
+	return day
+}
+
+// next 赛季开始时间start的下一赛季开始时间
+func (board *Board) next(start time.Time, n int) time.Time {
+	if board.Period == PeriodWeekly {
+		return start.AddDate(0, 0, 7*n)
+	}
+	return start.AddDate(0, 0, n)
+}
+
+// ttl 当前赛季的集合在赛季结束并保留Keep个赛季后过期
+func (board *Board) ttl(now time.Time) time.Duration {
+	if board.Period == PeriodNone {
+		return 0
+	}
+	return board.next(board.seasonStart(now), board.Keep+1).Sub(now)
+}
+
+// resolve 将查询参数中的赛季转换为赛季id
+func (board *Board) resolve(season string, now time.Time) (string, error) {
+	if board.Period == PeriodNone {
+		if season == "" || season == SeasonCurrent {
+			return "", nil
+		}
+		return "", ErrInvalidSeason
+	}
+	switch season {
+	case "", SeasonCurrent:
+		return board.season(now), nil
+	case SeasonPrevious:
+		return board.season(board.next(board.seasonStart(now), -1)), nil
+	}
+	// 指定赛季id, 需格式正确且在保留范围内
+	start := board.seasonStart(now)
+	for i := 0; i <= board.Keep; i++ {
+		if season == board.season(board.next(start, -i)) {
+			return season, nil
+		}
+	}
+	return "", ErrInvalidSeason
+}
+
+func (board *Board) key(season string) string {
+	if season == "" {
+		return keyPrefix + board.Name
+	}
+	return keyPrefix + board.Name + ":" + season
+}
+
+// Entry 榜上的一条记录, Rank 从1开始
+type Entry struct {
+	Uid   int64   `json:"uid"`
+	Score float64 `json:"score"`
+	Rank  int64   `json:"rank"`
+}
+
+// Result 查询结果
+type Result struct {
+	Board   string  `json:"board"`
+	Season  string  `json:"season"`
+	Total   int64   `json:"total"`
+	Entries []Entry `json:"entries"`
+}
+
+// Leaderboards 排行榜集合
+type Leaderboards struct {
+	store  Store
+	boards map[string]Board
+	now    func() time.Time
+}
+
+func NewLeaderboards(store Store, boards []Board) (*Leaderboards, error) {
+	lb := &Leaderboards{
+		store:  store,
+		boards: make(map[string]Board, len(boards)),
+		now:    time.Now,
+	}
+	for _, board := range boards {
+		if err := board.check(); err != nil {
+			return nil, fmt.Errorf("leaderboard %s: %w", board.Name, err)
+		}
+		lb.boards[board.Name] = board
+	}
+	return lb, nil
+}
+
+// Boards 所有排行榜, 按名称排序
+func (lb *Leaderboards) Boards() []Board {
+	result := make([]Board, 0, len(lb.boards))
+	for _, board := range lb.boards {
+		result = append(result, board)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Board 按名称查找
+func (lb *Leaderboards) Board(name string) (Board, bool) {
+	board, exist := lb.boards[name]
+	return board, exist
+}
+
+// Season 将查询参数中的赛季(空, current, previous 或赛季id)转换为赛季id, 不重置的榜为空
+func (lb *Leaderboards) Season(name, season string) (string, error) {
+	result, _, err := lb.result(name, season)
+	return result.Season, err
+}
+
+// Submit 提交分数到当前赛季, client 为true时检查是否允许客户端提交; 返回更新后的成绩
+func (lb *Leaderboards) Submit(name string, uid int64, score float64, client bool) (Entry, error) {
+	entry := Entry{Uid: uid}
+	board, exist := lb.boards[name]
+	if !exist {
+		return entry, ErrUnknownBoard
+	}
+	if client && !board.ClientSubmit {
+		return entry, ErrSubmitForbidden
+	}
+	if score != score || score > maxScore || score < -maxScore {
+		return entry, ErrScoreNotFinite
+	}
+	if board.Policy == PolicySum && score < 0 {
+		return entry, ErrNegativeSumScore
+	}
+	now := lb.now()
+	key := board.key(board.season(now))
+	var err error
+	if entry.Score, err = lb.store.Submit(key, uid, score, board.Policy, board.ttl(now)); err != nil {
+		return entry, err
+	}
+	rank, _, err := lb.store.Rank(key, uid)
+	entry.Rank = rank + 1
+	return entry, err
+}
+
+// Top 前limit名
+func (lb *Leaderboards) Top(name, season string, limit int) (Result, error) {
+	result, key, err := lb.result(name, season)
+	if err != nil {
+		return result, err
+	}
+	if result.Entries, err = lb.store.Range(key, 0, int64(clampLimit(limit))-1); err != nil {
+		return result, err
+	}
+	result.Total, err = lb.store.Count(key)
+	return result, err
+}
+
+// RankOf 玩家的成绩, 未上榜时返回false
+func (lb *Leaderboards) RankOf(name, season string, uid int64) (Entry, bool, error) {
+	entry := Entry{Uid: uid}
+	_, key, err := lb.result(name, season)
+	if err != nil {
+		return entry, false, err
+	}
+	rank, exist, err := lb.store.Rank(key, uid)
+	if err != nil || !exist {
+		return entry, false, err
+	}
+	score, exist, err := lb.store.Score(key, uid)
+	if err != nil || !exist {
+		return entry, false, err
+	}
+	entry.Rank, entry.Score = rank+1, score
+	return entry, true, nil
+}
+
+// Around 玩家前后共limit名, 玩家居中; 未上榜时返回空列表
+func (lb *Leaderboards) Around(name, season string, uid int64, limit int) (Result, error) {
+	result, key, err := lb.result(name, season)
+	if err != nil {
+		return result, err
+	}
+	if result.Total, err = lb.store.Count(key); err != nil {
+		return result, err
+	}
+	rank, exist, err := lb.store.Rank(key, uid)
+	if err != nil || !exist {
+		return result, err
+	}
+	limit = clampLimit(limit)
+	start := rank - int64(limit/2)
+	if start+int64(limit) > result.Total {
+		start = result.Total - int64(limit)
+	}
+	if start < 0 {
+		start = 0
+	}
+	result.Entries, err = lb.store.Range(key, start, start+int64(limit)-1)
+	return result, err
+}
+
+// Remove 将玩家移出当前赛季, 用于处理作弊等
+func (lb *Leaderboards) Remove(name string, uid int64) error {
+	board, exist := lb.boards[name]
+	if !exist {
+		return ErrUnknownBoard
+	}
+	return lb.store.Remove(board.key(board.season(lb.now())), uid)
+}
+
+func (lb *Leaderboards) result(name, season string) (Result, string, error) {
+	result := Result{Board: name, Entries: make([]Entry, 0)}
+	board, exist := lb.boards[name]
+	if !exist {
+		return result, "", ErrUnknownBoard
+	}
+	var err error
+	if result.Season, err = board.resolve(strings.TrimSpace(season), lb.now()); err != nil {
+		return result, "", err
+	}
+	return result, board.key(result.Season), nil
+}
+
+// maxScore redis有序集合中分数为双精度浮点数, 限制范围以排除Inf
+const maxScore = 1e300
+
+func clampLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultLimit
+	}
+	if limit > MaxLimit {
+		return MaxLimit
+	}
+	return limit
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package leaderboard
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeClock 可调的时间, 同时用于排行榜及内存存储
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestLeaderboards(t *testing.T, boards ...Board) (*Leaderboards, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2022, 6, 15, 12, 0, 0, 0, time.Local)}
+	store := NewMemoryStore()
+	store.now = clock.now
+	lb, err := NewLeaderboards(store, boards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.now = clock.now
+	return lb, clock
+}
+
+func TestSkiplist(t *testing.T) {
+	sl := newSkiplist()
+	scores := make(map[string]float64)
+	for i := 0; i < 500; i++ {
+		member := fmt.Sprintf("%03d", i)
+		score := float64(rand.Intn(50))
+		scores[member] = score
+		sl.insert(member, score)
+	}
+	for i := 0; i < 500; i += 3 {
+		member := fmt.Sprintf("%03d", i)
+		if !sl.delete(member, scores[member]) {
+			t.Fatalf("delete %s failed", member)
+		}
+		delete(scores, member)
+	}
+	if sl.delete("999", 1) {
+		t.Fatal("delete missing member should fail")
+	}
+	expected := make([]string, 0, len(scores))
+	for member := range scores {
+		expected = append(expected, member)
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		a, b := expected[i], expected[j]
+		return scores[a] > scores[b] || (scores[a] == scores[b] && a > b)
+	})
+	if sl.length != int64(len(expected)) {
+		t.Fatalf("length %d, expected %d", sl.length, len(expected))
+	}
+	for i, member := range expected {
+		if rank := sl.rank(member, scores[member]); rank != int64(i+1) {
+			t.Fatalf("rank of %s is %d, expected %d", member, rank, i+1)
+		}
+		if node := sl.byRank(int64(i + 1)); node == nil || node.member != member {
+			t.Fatalf("byRank(%d) mismatch", i+1)
+		}
+	}
+	if sl.tail == nil || sl.tail.member != expected[len(expected)-1] {
+		t.Fatal("tail mismatch")
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	lb, _ := newTestLeaderboards(t,
+		Board{Name: "best", Policy: PolicyBest, ClientSubmit: true},
+		Board{Name: "sum", Policy: PolicySum},
+		Board{Name: "latest", Policy: PolicyLatest},
+	)
+	cases := []struct {
+		board    string
+		scores   []float64
+		expected float64
+	}{
+		{"best", []float64{10, 30, 20}, 30},
+		{"sum", []float64{10, 30, 20}, 60},
+		{"latest", []float64{10, 30, 20}, 20},
+	}
+	for _, c := range cases {
+		var entry Entry
+		var err error
+		for _, score := range c.scores {
+			if entry, err = lb.Submit(c.board, 1, score, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if entry.Score != c.expected || entry.Rank != 1 {
+			t.Fatalf("%s: unexpected %+v", c.board, entry)
+		}
+	}
+	if _, err := lb.Submit("sum", 1, 1, true); err != ErrSubmitForbidden {
+		t.Fatalf("expected ErrSubmitForbidden, got %v", err)
+	}
+	if _, err := lb.Submit("sum", 1, -1, false); err != ErrNegativeSumScore {
+		t.Fatalf("expected ErrNegativeSumScore, got %v", err)
+	}
+	if _, err := lb.Submit("missing", 1, 1, false); err != ErrUnknownBoard {
+		t.Fatalf("expected ErrUnknownBoard, got %v", err)
+	}
+	if _, err := NewLeaderboards(NewMemoryStore(), []Board{{Name: "x", Policy: "max"}}); err == nil {
+		t.Fatal("unknown policy should fail")
+	}
+}
+
+func TestQueries(t *testing.T) {
+	lb, _ := newTestLeaderboards(t, Board{Name: "score"})
+	for uid := int64(1); uid <= 20; uid++ {
+		_, _ = lb.Submit("score", uid, float64(uid*10), false)
+	}
+	// 同分时uid大的在前, 与redis一致
+	_, _ = lb.Submit("score", 21, 200, false)
+
+	top, err := lb.Top("score", "", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top.Total != 21 || len(top.Entries) != 3 || top.Entries[0].Uid != 21 || top.Entries[1].Uid != 20 || top.Entries[2].Rank != 3 {
+		t.Fatalf("unexpected top %+v", top)
+	}
+	entry, exist, _ := lb.RankOf("score", "", 5)
+	if !exist || entry.Rank != 17 || entry.Score != 50 {
+		t.Fatalf("unexpected rank %+v", entry)
+	}
+	if _, exist, _ = lb.RankOf("score", "", 99); exist {
+		t.Fatal("missing player should not be ranked")
+	}
+
+	around, _ := lb.Around("score", "", 5, 5)
+	if len(around.Entries) != 5 || around.Entries[0].Rank != 15 || around.Entries[2].Uid != 5 {
+		t.Fatalf("unexpected around %+v", around)
+	}
+	// 靠近末尾时向前补足
+	around, _ = lb.Around("score", "", 1, 5)
+	if len(around.Entries) != 5 || around.Entries[0].Rank != 17 || around.Entries[4].Uid != 1 {
+		t.Fatalf("unexpected around at bottom %+v", around)
+	}
+	around, _ = lb.Around("score", "", 99, 5)
+	if len(around.Entries) != 0 {
+		t.Fatal("missing player should get empty around")
+	}
+	if _, err = lb.Top("score", SeasonPrevious, 3); err != ErrInvalidSeason {
+		t.Fatalf("expected ErrInvalidSeason, got %v", err)
+	}
+}
+
+func TestSeasons(t *testing.T) {
+	lb, clock := newTestLeaderboards(t,
+		Board{Name: "daily", Period: PeriodDaily, Keep: 1},
+		Board{Name: "weekly", Policy: PolicySum, Period: PeriodWeekly, Keep: 1},
+	)
+	_, _ = lb.Submit("daily", 1, 100, false)
+	_, _ = lb.Submit("weekly", 1, 100, false)
+	if season, _ := lb.Season("weekly", ""); season != "2022W24" {
+		t.Fatalf("unexpected weekly season %s", season)
+	}
+
+	// 第二天: 日榜重置, 周榜继续累加
+	clock.t = clock.t.AddDate(0, 0, 1)
+	_, _ = lb.Submit("daily", 2, 50, false)
+	_, _ = lb.Submit("weekly", 1, 100, false)
+	top, _ := lb.Top("daily", "", 10)
+	if top.Season != "20220616" || len(top.Entries) != 1 || top.Entries[0].Uid != 2 {
+		t.Fatalf("unexpected daily %+v", top)
+	}
+	top, _ = lb.Top("daily", SeasonPrevious, 10)
+	if top.Season != "20220615" || len(top.Entries) != 1 || top.Entries[0].Uid != 1 {
+		t.Fatalf("unexpected previous daily %+v", top)
+	}
+	if top, _ = lb.Top("daily", "20220615", 10); len(top.Entries) != 1 {
+		t.Fatal("season id within keep should be queryable")
+	}
+	if _, err := lb.Top("daily", "20220614", 10); err != ErrInvalidSeason {
+		t.Fatalf("expected ErrInvalidSeason, got %v", err)
+	}
+	if entry, _, _ := lb.RankOf("weekly", "", 1); entry.Score != 200 {
+		t.Fatalf("unexpected weekly %+v", entry)
+	}
+
+	// 下周一: 周榜重置, 上周保留; 日榜超出保留范围被清除
+	clock.t = time.Date(2022, 6, 20, 0, 0, 1, 0, time.Local)
+	if top, _ = lb.Top("weekly", "", 10); top.Season != "2022W25" || len(top.Entries) != 0 {
+		t.Fatalf("unexpected weekly %+v", top)
+	}
+	if top, _ = lb.Top("weekly", SeasonPrevious, 10); top.Season != "2022W24" || len(top.Entries) != 1 {
+		t.Fatalf("unexpected previous weekly %+v", top)
+	}
+	count, _ := lb.store.Count(keyPrefix + "daily:20220616")
+	if count != 0 {
+		t.Fatal("expired season should be removed")
+	}
+}
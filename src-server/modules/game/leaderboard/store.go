@@ -0,0 +1,265 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package leaderboard
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store 有序集合存储, 排名从0开始, 分数从高到低
+type Store interface {
+	// Submit 按策略更新分数并返回更新后的分数, ttl 大于0时设置过期时间
+	Submit(key string, uid int64, score float64, policy Policy, ttl time.Duration) (float64, error)
+	// Score 玩家的分数
+	Score(key string, uid int64) (float64, bool, error)
+	// Rank 玩家的排名
+	Rank(key string, uid int64) (int64, bool, error)
+	// Range 排名在[start, stop]之间的玩家
+	Range(key string, start, stop int64) ([]Entry, error)
+	// Count 上榜人数
+	Count(key string) (int64, error)
+	// Remove 移出排行榜
+	Remove(key string, uid int64) error
+}
+
+// zset 跳表及成员分数
+type zset struct {
+	list     *skiplist
+	scores   map[string]float64
+	expireAt time.Time
+}
+
+// MemoryStore 进程内的跳表存储, 数据不在节点间共享, 重启后丢失
+type MemoryStore struct {
+	mutex sync.Mutex
+	sets  map[string]*zset
+	now   func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sets: make(map[string]*zset),
+		now:  time.Now,
+	}
+}
+
+// get 取有序集合, 已过期的视为不存在; create 为true时不存在则创建
+func (s *MemoryStore) get(key string, create bool) *zset {
+	z, exist := s.sets[key]
+	if exist && !z.expireAt.IsZero() && !s.now().Before(z.expireAt) {
+		delete(s.sets, key)
+		z, exist = nil, false
+	}
+	if !exist && create {
+		z = &zset{list: newSkiplist(), scores: make(map[string]float64)}
+		s.sets[key] = z
+	}
+	return z
+}
+
+func (s *MemoryStore) Submit(key string, uid int64, score float64, policy Policy, ttl time.Duration) (float64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+	z := s.get(key, true)
+	member := strconv.FormatInt(uid, 10)
+	current, exist := z.scores[member]
+	next := score
+	switch policy {
+	case PolicyBest:
+		if exist && current >= score {
+			next = current
+		}
+	case PolicySum:
+		next = current + score
+	}
+	if !exist || next != current {
+		if exist {
+			z.list.delete(member, current)
+		}
+		z.list.insert(member, next)
+		z.scores[member] = next
+	}
+	if ttl > 0 {
+		z.expireAt = s.now().Add(ttl)
+	}
+	return next, nil
+}
+
+func (s *MemoryStore) Score(key string, uid int64) (float64, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	z := s.get(key, false)
+	if z == nil {
+		return 0, false, nil
+	}
+	score, exist := z.scores[strconv.FormatInt(uid, 10)]
+	return score, exist, nil
+}
+
+func (s *MemoryStore) Rank(key string, uid int64) (int64, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	z := s.get(key, false)
+	if z == nil {
+		return 0, false, nil
+	}
+	member := strconv.FormatInt(uid, 10)
+	score, exist := z.scores[member]
+	if !exist {
+		return 0, false, nil
+	}
+	return z.list.rank(member, score) - 1, true, nil
+}
+
+func (s *MemoryStore) Range(key string, start, stop int64) ([]Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]Entry, 0)
+	z := s.get(key, false)
+	if z == nil || start < 0 || start >= z.list.length || stop < start {
+		return result, nil
+	}
+	if stop >= z.list.length {
+		stop = z.list.length - 1
+	}
+	node := z.list.byRank(start + 1)
+	for rank := start; rank <= stop && node != nil; rank++ {
+		uid, _ := strconv.ParseInt(node.member, 10, 64)
+		result = append(result, Entry{Uid: uid, Score: node.score, Rank: rank + 1})
+		node = node.level[0].forward
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) Count(key string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	z := s.get(key, false)
+	if z == nil {
+		return 0, nil
+	}
+	return z.list.length, nil
+}
+
+func (s *MemoryStore) Remove(key string, uid int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	z := s.get(key, false)
+	if z == nil {
+		return nil
+	}
+	member := strconv.FormatInt(uid, 10)
+	if score, exist := z.scores[member]; exist {
+		z.list.delete(member, score)
+		delete(z.scores, member)
+	}
+	return nil
+}
+
+// expire 清理过期的赛季
+func (s *MemoryStore) expire() {
+	now := s.now()
+	for key, z := range s.sets {
+		if !z.expireAt.IsZero() && !now.Before(z.expireAt) {
+			delete(s.sets, key)
+		}
+	}
+}
+
+// submitBestScript 分数更高时才更新, 兼容不支持 ZADD GT 的redis版本
+var submitBestScript = redis.NewScript(`
+local current = redis.call('ZSCORE', KEYS[1], ARGV[2])
+if (not current) or tonumber(ARGV[1]) > tonumber(current) then
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+	current = ARGV[1]
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return current
+`)
+
+// RedisStore 基于redis有序集合, 多节点共享
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Submit(key string, uid int64, score float64, policy Policy, ttl time.Duration) (float64, error) {
+	ctx := context.Background()
+	member := strconv.FormatInt(uid, 10)
+	switch policy {
+	case PolicyBest:
+		return submitBestScript.Run(ctx, s.client, []string{key}, score, member, ttl.Milliseconds()).Float64()
+	case PolicySum:
+		var incr *redis.FloatCmd
+		_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			incr = pipe.ZIncrBy(ctx, key, score, member)
+			if ttl > 0 {
+				pipe.PExpire(ctx, key, ttl)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		return incr.Val(), nil
+	default:
+		_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, key, &redis.Z{Score: score, Member: member})
+			if ttl > 0 {
+				pipe.PExpire(ctx, key, ttl)
+			}
+			return nil
+		})
+		return score, err
+	}
+}
+
+func (s *RedisStore) Score(key string, uid int64) (float64, bool, error) {
+	score, err := s.client.ZScore(context.Background(), key, strconv.FormatInt(uid, 10)).Result()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	return score, err == nil, err
+}
+
+func (s *RedisStore) Rank(key string, uid int64) (int64, bool, error) {
+	rank, err := s.client.ZRevRank(context.Background(), key, strconv.FormatInt(uid, 10)).Result()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	return rank, err == nil, err
+}
+
+func (s *RedisStore) Range(key string, start, stop int64) ([]Entry, error) {
+	result := make([]Entry, 0)
+	if start < 0 || stop < start {
+		return result, nil
+	}
+	values, err := s.client.ZRevRangeWithScores(context.Background(), key, start, stop).Result()
+	if err != nil {
+		return result, err
+	}
+	for i, z := range values {
+		uid, _ := strconv.ParseInt(z.Member.(string), 10, 64)
+		result = append(result, Entry{Uid: uid, Score: z.Score, Rank: start + int64(i) + 1})
+	}
+	return result, nil
+}
+
+func (s *RedisStore) Count(key string) (int64, error) {
+	return s.client.ZCard(context.Background(), key).Result()
+}
+
+func (s *RedisStore) Remove(key string, uid int64) error {
+	return s.client.ZRem(context.Background(), key, strconv.FormatInt(uid, 10)).Err()
+}
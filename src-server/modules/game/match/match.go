@@ -0,0 +1,357 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package match
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/zhouhp1295/g3"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MatchedRouter 匹配成功时推送
+	MatchedRouter = "match/matched"
+	// TimeoutRouter 匹配超时时推送
+	TimeoutRouter = "match/timeout"
+
+	// DefaultRating 未提供分数时使用
+	DefaultRating = 1000
+)
+
+var (
+	ErrUnknownMode   = errors.New("unknown match mode")
+	ErrAlreadyQueued = errors.New("already in match queue")
+)
+
+// PushFunc 按uid推送, 由玩家当前的连接接收; 重连或顶号后仍能送达
+type PushFunc func(uid int64, router string, data interface{})
+
+// ModeConfig 匹配模式
+type ModeConfig struct {
+	// TeamSize 每队人数
+	TeamSize int
+	// Teams 队伍数, 默认2
+	Teams int
+	// RatingWindow 初始允许的分差
+	RatingWindow int
+	// WindowStep 每等待WidenEvery, 允许的分差扩大WindowStep
+	WindowStep int
+	WidenEvery time.Duration
+	// MaxWindow 允许的最大分差, 0为不限制
+	MaxWindow int
+	// Timeout 最长等待时间, 超时移出队列, 0为不超时
+	Timeout time.Duration
+}
+
+func (cfg ModeConfig) players() int {
+	return cfg.TeamSize * cfg.Teams
+}
+
+// window 等待wait后允许的分差
+func (cfg ModeConfig) window(wait time.Duration) int {
+	window := cfg.RatingWindow
+	if cfg.WindowStep > 0 && cfg.WidenEvery > 0 {
+		window += cfg.WindowStep * int(wait/cfg.WidenEvery)
+	}
+	if cfg.MaxWindow > 0 && window > cfg.MaxWindow {
+		window = cfg.MaxWindow
+	}
+	return window
+}
+
+// Ticket 排队中的玩家
+type Ticket struct {
+	Uid        int64
+	Mode       string
+	Rating     int
+	EnqueuedAt time.Time
+}
+
+// Match 匹配结果
+type Match struct {
+	RoomId string
+	Mode   string
+	// Teams 每队玩家的uid
+	Teams   [][]int64
+	Tickets []*Ticket
+}
+
+// MatchedPush 匹配成功推送的数据
+type MatchedPush struct {
+	RoomId string    `json:"roomId"`
+	Mode   string    `json:"mode"`
+	Team   int       `json:"team"`
+	Teams  [][]int64 `json:"teams"`
+}
+
+// Matcher 按模式分队列匹配, 由定时循环驱动
+type Matcher struct {
+	interval time.Duration
+	modes    map[string]ModeConfig
+	push     PushFunc
+
+	mutex   sync.Mutex
+	queues  map[string][]*Ticket
+	tickets map[int64]*Ticket
+
+	onMatched []func(match Match)
+
+	// now 及 newRoomId 便于测试替换
+	now       func() time.Time
+	newRoomId func() string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMatcher interval 为匹配循环间隔, push 推送匹配结果
+func NewMatcher(interval time.Duration, modes map[string]ModeConfig, push PushFunc) *Matcher {
+	m := &Matcher{
+		interval:  interval,
+		modes:     make(map[string]ModeConfig),
+		push:      push,
+		queues:    make(map[string][]*Ticket),
+		tickets:   make(map[int64]*Ticket),
+		now:       time.Now,
+		newRoomId: uuid.NewString,
+		stop:      make(chan struct{}),
+	}
+	for name, cfg := range modes {
+		if cfg.TeamSize < 1 {
+			cfg.TeamSize = 1
+		}
+		if cfg.Teams < 1 {
+			cfg.Teams = 2
+		}
+		m.modes[name] = cfg
+	}
+	return m
+}
+
+// OnMatched 匹配成功后回调, 如创建房间; 在推送之前执行
+func (m *Matcher) OnMatched(f func(match Match)) {
+	m.mutex.Lock()
+	m.onMatched = append(m.onMatched, f)
+	m.mutex.Unlock()
+}
+
+// Modes 已配置的模式
+func (m *Matcher) Modes() []string {
+	result := make([]string, 0, len(m.modes))
+	for name := range m.modes {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Enqueue 加入匹配队列
+func (m *Matcher) Enqueue(uid int64, mode string, rating int) (*Ticket, error) {
+	if _, exist := m.modes[mode]; !exist {
+		return nil, ErrUnknownMode
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exist := m.tickets[uid]; exist {
+		return nil, ErrAlreadyQueued
+	}
+	ticket := &Ticket{
+		Uid:        uid,
+		Mode:       mode,
+		Rating:     rating,
+		EnqueuedAt: m.now(),
+	}
+	m.tickets[uid] = ticket
+	m.queues[mode] = append(m.queues[mode], ticket)
+	return ticket, nil
+}
+
+// Cancel 退出匹配队列, 不在队列中时返回false
+func (m *Matcher) Cancel(uid int64) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ticket, exist := m.tickets[uid]
+	if !exist {
+		return false
+	}
+	m.remove(ticket)
+	return true
+}
+
+// IsQueued 是否在匹配队列中
+func (m *Matcher) IsQueued(uid int64) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, exist := m.tickets[uid]
+	return exist
+}
+
+// QueueSize 模式当前排队人数
+func (m *Matcher) QueueSize(mode string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.queues[mode])
+}
+
+func (m *Matcher) remove(ticket *Ticket) {
+	delete(m.tickets, ticket.Uid)
+	queue := m.queues[ticket.Mode]
+	for i, t := range queue {
+		if t == ticket {
+			m.queues[ticket.Mode] = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+}
+
+// Start 启动匹配循环
+func (m *Matcher) Start() {
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				m.tick()
+			}
+		}
+	}()
+}
+
+// Stop 停止匹配循环
+func (m *Matcher) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// tick 一轮匹配: 先移除超时的玩家, 再按等待时间从长到短依次尝试组队
+func (m *Matcher) tick() {
+	m.mutex.Lock()
+	now := m.now()
+	timeouts := make([]*Ticket, 0)
+	matches := make([]Match, 0)
+	for mode, cfg := range m.modes {
+		expired := make([]*Ticket, 0)
+		for _, ticket := range m.queues[mode] {
+			if cfg.Timeout > 0 && now.Sub(ticket.EnqueuedAt) >= cfg.Timeout {
+				expired = append(expired, ticket)
+			}
+		}
+		for _, ticket := range expired {
+			m.remove(ticket)
+		}
+		timeouts = append(timeouts, expired...)
+		for {
+			match, ok := m.matchOne(mode, cfg, now)
+			if !ok {
+				break
+			}
+			for _, ticket := range match.Tickets {
+				m.remove(ticket)
+			}
+			matches = append(matches, match)
+		}
+	}
+	hooks := m.onMatched
+	m.mutex.Unlock()
+
+	// 回调及推送在锁外执行
+	for _, ticket := range timeouts {
+		g3.ZL().Info("match timeout",
+			zap.Int64("uid", ticket.Uid),
+			zap.String("mode", ticket.Mode))
+		m.push(ticket.Uid, TimeoutRouter, map[string]interface{}{"mode": ticket.Mode})
+	}
+	for _, match := range matches {
+		g3.ZL().Info("matched",
+			zap.String("roomId", match.RoomId),
+			zap.String("mode", match.Mode),
+			zap.Reflect("teams", match.Teams))
+		for _, hook := range hooks {
+			hook(match)
+		}
+		for _, ticket := range match.Tickets {
+			push := MatchedPush{RoomId: match.RoomId, Mode: match.Mode, Teams: match.Teams}
+			for team, uids := range match.Teams {
+				for _, uid := range uids {
+					if uid == ticket.Uid {
+						push.Team = team
+					}
+				}
+			}
+			m.push(ticket.Uid, MatchedRouter, push)
+		}
+	}
+}
+
+// matchOne 以等待最久的玩家为锚点, 找出分差在双方允许范围内且最接近的玩家组成一局
+func (m *Matcher) matchOne(mode string, cfg ModeConfig, now time.Time) (Match, bool) {
+	queue := m.queues[mode]
+	need := cfg.players()
+	if len(queue) < need {
+		return Match{}, false
+	}
+	// 队列按入队顺序排列, 即等待时间从长到短
+	for _, anchor := range queue {
+		anchorWindow := cfg.window(now.Sub(anchor.EnqueuedAt))
+		candidates := make([]*Ticket, 0, len(queue))
+		for _, ticket := range queue {
+			if ticket == anchor {
+				continue
+			}
+			window := cfg.window(now.Sub(ticket.EnqueuedAt))
+			if window > anchorWindow {
+				window = anchorWindow
+			}
+			if abs(ticket.Rating-anchor.Rating) <= window {
+				candidates = append(candidates, ticket)
+			}
+		}
+		if len(candidates) < need-1 {
+			continue
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return abs(candidates[i].Rating-anchor.Rating) < abs(candidates[j].Rating-anchor.Rating)
+		})
+		tickets := append([]*Ticket{anchor}, candidates[:need-1]...)
+		return Match{
+			RoomId:  m.newRoomId(),
+			Mode:    mode,
+			Teams:   splitTeams(tickets, cfg.Teams),
+			Tickets: tickets,
+		}, true
+	}
+	return Match{}, false
+}
+
+// splitTeams 按分数从高到低蛇形分配, 使各队总分接近
+func splitTeams(tickets []*Ticket, teams int) [][]int64 {
+	sorted := make([]*Ticket, len(tickets))
+	copy(sorted, tickets)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Rating > sorted[j].Rating
+	})
+	result := make([][]int64, teams)
+	for i, ticket := range sorted {
+		round, pos := i/teams, i%teams
+		if round%2 == 1 {
+			pos = teams - 1 - pos
+		}
+		result[pos] = append(result[pos], ticket.Uid)
+	}
+	return result
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package match
+
+import (
+	"fmt"
+	"github.com/zhouhp1295/g3"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakePush struct {
+	router string
+	data   interface{}
+}
+
+// fakePusher 按uid记录收到的推送
+type fakePusher struct {
+	mutex  sync.Mutex
+	pushes map[int64][]fakePush
+}
+
+func (p *fakePusher) Push(uid int64, router string, data interface{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.pushes[uid] = append(p.pushes[uid], fakePush{router: router, data: data})
+}
+
+func (p *fakePusher) last(uid int64) (fakePush, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pushes := p.pushes[uid]
+	if len(pushes) == 0 {
+		return fakePush{}, false
+	}
+	return pushes[len(pushes)-1], true
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "g3-game-match")
+	if err != nil {
+		panic(err)
+	}
+	g3.Boot(&g3.Cfg{HomeDir: dir})
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func newTestMatcher(modes map[string]ModeConfig) (*Matcher, *fakeClock, *fakePusher) {
+	clock := &fakeClock{now: time.Unix(1660000000, 0)}
+	pusher := &fakePusher{pushes: make(map[int64][]fakePush)}
+	m := NewMatcher(time.Second, modes, pusher.Push)
+	m.now = clock.Now
+	n := 0
+	m.newRoomId = func() string {
+		n++
+		return fmt.Sprintf("room-%d", n)
+	}
+	return m, clock, pusher
+}
+
+func TestMatchTeams(t *testing.T) {
+	m, _, pusher := newTestMatcher(map[string]ModeConfig{
+		"2v2": {TeamSize: 2, Teams: 2, RatingWindow: 100},
+	})
+	ratings := []int{1000, 1040, 1060, 1090}
+	for i, rating := range ratings {
+		if _, err := m.Enqueue(int64(i+1), "2v2", rating); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Enqueue(1, "2v2", 1000); err != ErrAlreadyQueued {
+		t.Fatalf("expected ErrAlreadyQueued, got %v", err)
+	}
+	if _, err := m.Enqueue(9, "unknown", 1000); err != ErrUnknownMode {
+		t.Fatalf("expected ErrUnknownMode, got %v", err)
+	}
+	m.tick()
+	if m.QueueSize("2v2") != 0 {
+		t.Fatalf("queue should be empty, got %d", m.QueueSize("2v2"))
+	}
+	for i := range ratings {
+		uid := int64(i + 1)
+		push, ok := pusher.last(uid)
+		if !ok || push.router != MatchedRouter {
+			t.Fatalf("uid %d not matched: %+v", uid, push)
+		}
+		matched := push.data.(MatchedPush)
+		if matched.RoomId != "room-1" || len(matched.Teams) != 2 {
+			t.Fatalf("unexpected match %+v", matched)
+		}
+		found := false
+		for _, member := range matched.Teams[matched.Team] {
+			found = found || member == uid
+		}
+		if !found {
+			t.Fatalf("uid %d not in its team %+v", uid, matched)
+		}
+	}
+	// 蛇形分配: 1090+1000 对 1060+1040
+	teams := pusher.pushes[1][0].data.(MatchedPush).Teams
+	if fmt.Sprint(teams) != "[[4 1] [3 2]]" {
+		t.Fatalf("unexpected teams %v", teams)
+	}
+}
+
+func TestMatchWindowWidening(t *testing.T) {
+	m, clock, pusher := newTestMatcher(map[string]ModeConfig{
+		"1v1": {TeamSize: 1, Teams: 2, RatingWindow: 50, WindowStep: 50, WidenEvery: 10 * time.Second, MaxWindow: 200},
+	})
+	_, _ = m.Enqueue(1, "1v1", 1000)
+	_, _ = m.Enqueue(2, "1v1", 1180)
+	m.tick()
+	if _, ok := pusher.last(1); ok {
+		t.Fatal("should not match with initial window")
+	}
+	// 等待20秒后分差扩大到150, 仍不足
+	clock.Add(20 * time.Second)
+	m.tick()
+	if _, ok := pusher.last(1); ok {
+		t.Fatal("should not match with window 150")
+	}
+	// 等待30秒后分差扩大到200
+	clock.Add(10 * time.Second)
+	m.tick()
+	if push, ok := pusher.last(1); !ok || push.router != MatchedRouter {
+		t.Fatal("should match after widening")
+	}
+	if push, ok := pusher.last(2); !ok || push.router != MatchedRouter {
+		t.Fatal("should match after widening")
+	}
+}
+
+func TestMatchClosestRating(t *testing.T) {
+	m, _, pusher := newTestMatcher(map[string]ModeConfig{
+		"1v1": {TeamSize: 1, Teams: 2, RatingWindow: 300},
+	})
+	_, _ = m.Enqueue(1, "1v1", 1000)
+	_, _ = m.Enqueue(2, "1v1", 1250)
+	_, _ = m.Enqueue(3, "1v1", 1020)
+	m.tick()
+	if _, ok := pusher.last(2); ok {
+		t.Fatal("uid 2 should still be queued")
+	}
+	if !m.IsQueued(2) || m.IsQueued(1) || m.IsQueued(3) {
+		t.Fatal("uid 1 and 3 should be matched together")
+	}
+}
+
+func TestMatchCancelAndTimeout(t *testing.T) {
+	m, clock, pusher := newTestMatcher(map[string]ModeConfig{
+		"1v1": {TeamSize: 1, Teams: 2, RatingWindow: 10, Timeout: time.Minute},
+	})
+	_, _ = m.Enqueue(1, "1v1", 1000)
+	_, _ = m.Enqueue(2, "1v1", 1000)
+	if !m.Cancel(2) || m.Cancel(2) {
+		t.Fatal("cancel should succeed only once")
+	}
+	m.tick()
+	if _, ok := pusher.last(1); ok {
+		t.Fatal("should not match a cancelled player")
+	}
+	clock.Add(time.Minute)
+	m.tick()
+	if push, ok := pusher.last(1); !ok || push.router != TimeoutRouter {
+		t.Fatalf("expected timeout push, got %+v", push)
+	}
+	if m.IsQueued(1) {
+		t.Fatal("timed out player should leave the queue")
+	}
+	if _, ok := pusher.last(2); ok {
+		t.Fatal("cancelled player should not receive pushes")
+	}
+}
+
+func TestMatchOnMatched(t *testing.T) {
+	m, _, _ := newTestMatcher(map[string]ModeConfig{
+		"1v1": {TeamSize: 1, Teams: 2, RatingWindow: 10},
+	})
+	var got []Match
+	m.OnMatched(func(match Match) {
+		got = append(got, match)
+	})
+	for uid := int64(1); uid <= 5; uid++ {
+		_, _ = m.Enqueue(uid, "1v1", 1000)
+	}
+	m.tick()
+	if len(got) != 2 || m.QueueSize("1v1") != 1 {
+		t.Fatalf("expected 2 matches and 1 queued, got %d and %d", len(got), m.QueueSize("1v1"))
+	}
+	if !m.IsQueued(5) {
+		t.Fatal("the newest player should be left in queue")
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+package migrations
+
+import (
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/modules/system/migrations"
+	"github.com/zhouhp1295/g3/crud"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var gameItemMenuData20261018 = `
+[
+	{"id":301, "pid":3, "name":"Item", "title":"物品管理", "path":"item", "type":"2", "icon": "shopping", "component":"game/item/index", "perms":"game:item:list", "sort":10},
+	{"id":30101, "pid":301, "title":"物品查询", "type":"3", "perms":"game:item:query", "sort":0},
+	{"id":30102, "pid":301, "title":"物品新增", "type":"3", "perms":"game:item:add", "sort":1},
+	{"id":30103, "pid":301, "title":"物品编辑", "type":"3", "perms":"game:item:edit", "sort":2},
+	{"id":30104, "pid":301, "title":"物品删除", "type":"3", "perms":"game:item:remove", "sort":3},
+	{"id":302, "pid":3, "name":"Inventory", "title":"玩家背包", "path":"inventory", "type":"2", "icon": "list", "component":"game/inventory/index", "perms":"game:inventory:list", "sort":20},
+	{"id":30201, "pid":302, "title":"背包查询", "type":"3", "perms":"game:inventory:query", "sort":0},
+	{"id":30202, "pid":302, "title":"发放扣除", "type":"3", "perms":"game:inventory:edit", "sort":1},
+	{"id":303, "pid":3, "name":"ItemLog", "title":"物品记录", "path":"itemLog", "type":"2", "icon": "log", "component":"game/itemLog/index", "perms":"game:itemLog:list", "sort":30},
+	{"id":30301, "pid":303, "title":"记录查询", "type":"3", "perms":"game:itemLog:query", "sort":0}
+]
+`
+
+const M20261018GameItemCode = "20261018_game_item"
+
+func M20261018GameItem() func() error {
+	return func() error {
+		rootDB := crud.DbSess()
+		//开启事务
+		return rootDB.Transaction(func(tx *gorm.DB) error {
+			err := migrations.CreateSystemMenus(tx, gameItemMenuData20261018)
+			if err != nil {
+				g3.ZL().Fatal("20261018_game_item", zap.Error(err))
+				return err
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+package migrations
+
+import (
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/modules/system/migrations"
+	"github.com/zhouhp1295/g3/crud"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var gamePlayerDataMenuData20261018 = `
+[
+	{"id":3, "name":"游戏管理", "title":"游戏管理", "path":"/game", "type":"1", "icon": "example", "component":"Layout", "sort":0},
+	{"id":300, "pid":3, "name":"PlayerData", "title":"玩家存档", "path":"playerData", "type":"2", "icon": "documentation", "component":"game/playerData/index", "perms":"game:data:list", "sort":0},
+	{"id":30001, "pid":300, "title":"存档查询", "type":"3", "perms":"game:data:query", "sort":0},
+	{"id":30002, "pid":300, "title":"存档编辑", "type":"3", "perms":"game:data:edit", "sort":1},
+	{"id":30003, "pid":300, "title":"存档删除", "type":"3", "perms":"game:data:remove", "sort":2}
+]
+`
+
+const M20261018GamePlayerDataCode = "20261018_game_player_data"
+
+func M20261018GamePlayerData() func() error {
+	return func() error {
+		rootDB := crud.DbSess()
+		//开启事务
+		return rootDB.Transaction(func(tx *gorm.DB) error {
+			err := migrations.CreateSystemMenus(tx, gamePlayerDataMenuData20261018)
+			if err != nil {
+				g3.ZL().Fatal("20261018_game_player_data", zap.Error(err))
+				return err
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+package migrations
+
+import (
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/modules/system/migrations"
+	"github.com/zhouhp1295/g3/crud"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var gameReplayMenuData20261018 = `
+[
+	{"id":306, "pid":3, "name":"Replay", "title":"对局回放", "path":"replay", "type":"2", "icon": "monitor", "component":"game/replay/index", "perms":"game:replay:list", "sort":60},
+	{"id":30601, "pid":306, "title":"回放查询", "type":"3", "perms":"game:replay:query", "sort":0},
+	{"id":30602, "pid":306, "title":"回放删除", "type":"3", "perms":"game:replay:remove", "sort":1}
+]
+`
+
+const M20261018GameReplayCode = "20261018_game_replay"
+
+func M20261018GameReplay() func() error {
+	return func() error {
+		rootDB := crud.DbSess()
+		//开启事务
+		return rootDB.Transaction(func(tx *gorm.DB) error {
+			err := migrations.CreateSystemMenus(tx, gameReplayMenuData20261018)
+			if err != nil {
+				g3.ZL().Fatal("20261018_game_replay", zap.Error(err))
+				return err
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+package migrations
+
+import (
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3/crud"
+	"go.uber.org/zap"
+	"strings"
+)
+
+const M20261018GameUserUsernameCode = "20261018_game_user_username"
+
+// M20261018GameUserUsername 用户名改为小写保存, 将旧数据中含大写的用户名转为小写; 与已有用户名冲突的保留原值并记录日志
+func M20261018GameUserUsername() func() error {
+	return func() error {
+		type row struct {
+			Id       int64
+			Username string
+		}
+		rows := make([]row, 0)
+		if err := crud.DbSess().Table("game_user").
+			Select("id, username").
+			Where("username <> LOWER(username)").
+			Find(&rows).Error; err != nil {
+			g3.ZL().Error("20261018_game_user_username", zap.Error(err))
+			return err
+		}
+		for _, r := range rows {
+			if err := crud.DbSess().Table("game_user").
+				Where("id = ?", r.Id).
+				Update("username", strings.ToLower(r.Username)).Error; err != nil {
+				g3.ZL().Warn("20261018_game_user_username conflict",
+					zap.Int64("id", r.Id),
+					zap.String("username", r.Username),
+					zap.Error(err))
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+package migrations
+
+import (
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/modules/system/migrations"
+	"github.com/zhouhp1295/g3/crud"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var gameWalletMenuData20261018 = `
+[
+	{"id":304, "pid":3, "name":"Wallet", "title":"玩家钱包", "path":"wallet", "type":"2", "icon": "money", "component":"game/wallet/index", "perms":"game:wallet:list", "sort":40},
+	{"id":30401, "pid":304, "title":"钱包查询", "type":"3", "perms":"game:wallet:query", "sort":0},
+	{"id":30402, "pid":304, "title":"调整余额", "type":"3", "perms":"game:wallet:edit", "sort":1},
+	{"id":305, "pid":3, "name":"Ledger", "title":"账本流水", "path":"ledger", "type":"2", "icon": "log", "component":"game/ledger/index", "perms":"game:ledger:list", "sort":50},
+	{"id":30501, "pid":305, "title":"流水查询", "type":"3", "perms":"game:ledger:query", "sort":0}
+]
+`
+
+const M20261018GameWalletCode = "20261018_game_wallet"
+
+func M20261018GameWallet() func() error {
+	return func() error {
+		rootDB := crud.DbSess()
+		//开启事务
+		return rootDB.Transaction(func(tx *gorm.DB) error {
+			err := migrations.CreateSystemMenus(tx, gameWalletMenuData20261018)
+			if err != nil {
+				g3.ZL().Fatal("20261018_game_wallet", zap.Error(err))
+				return err
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+package model
+
+import "github.com/zhouhp1295/g3/crud"
+
+const (
+	// ItemTypeConsumable 消耗品
+	ItemTypeConsumable = "consumable"
+	// ItemTypeMaterial 材料
+	ItemTypeMaterial = "material"
+	// ItemTypeEquipment 装备
+	ItemTypeEquipment = "equipment"
+	// ItemTypeMisc 其他
+	ItemTypeMisc = "misc"
+)
+
+// ItemTypes 物品类型
+var ItemTypes = []string{ItemTypeConsumable, ItemTypeMaterial, ItemTypeEquipment, ItemTypeMisc}
+
+// GameItem 物品定义, 由后台配置; Code 创建后不可修改, 服务端逻辑及客户端按Code引用
+type GameItem struct {
+	crud.BaseModel
+	Code        string `gorm:"TYPE:VARCHAR(32);INDEX;NOT NULL;COMMENT:物品代码" json:"code" form:"code" query:"like"`
+	Name        string `gorm:"TYPE:VARCHAR(50);NOT NULL;COMMENT:名称" json:"name" form:"name" query:"like"`
+	Type        string `gorm:"TYPE:VARCHAR(16);NOT NULL;DEFAULT:'misc';COMMENT:类型" json:"type" form:"type" query:"eq"`
+	Icon        string `gorm:"TYPE:VARCHAR(255);COMMENT:图标" json:"icon" form:"icon"`
+	Description string `gorm:"TYPE:VARCHAR(255);COMMENT:描述" json:"description" form:"description"`
+	// MaxStack 每个玩家最多持有的数量, 0为不限
+	MaxStack int64 `gorm:"NOT NULL;DEFAULT:0;COMMENT:堆叠上限,0为不限" json:"maxStack" form:"maxStack"`
+	Sort     int   `gorm:"NOT NULL;DEFAULT:0;COMMENT:排序" json:"sort" form:"sort"`
+	crud.TailColumns
+}
+
+// Table 返回表名
+func (*GameItem) Table() string {
+	return "game_item"
+}
+
+// NewModel 返回实例
+func (*GameItem) NewModel() crud.ModelInterface {
+	return new(GameItem)
+}
+
+// NewModels 返回实例数组
+func (*GameItem) NewModels() interface{} {
+	return make([]GameItem, 0)
+}
+
+// GetUpdateColumns 更新时的列, 不包括code
+func (*GameItem) GetUpdateColumns() []string {
+	return []string{"name", "type", "icon", "description", "max_stack", "sort", "updated_by", "updated_at", "remark"}
+}
+
+// GameInventory 玩家背包, 每个玩家每种物品一行
+type GameInventory struct {
+	crud.BaseModel
+	Uid      int64 `gorm:"NOT NULL;DEFAULT:0;uniqueIndex:uk_game_inventory;COMMENT:玩家id" json:"uid" form:"uid" query:"eq"`
+	ItemId   int64 `gorm:"NOT NULL;DEFAULT:0;uniqueIndex:uk_game_inventory;COMMENT:物品id" json:"itemId" form:"itemId" query:"eq"`
+	Quantity int64 `gorm:"NOT NULL;DEFAULT:0;COMMENT:数量" json:"quantity" form:"quantity"`
+	crud.TailColumns
+}
+
+// Table 返回表名
+func (*GameInventory) Table() string {
+	return "game_inventory"
+}
+
+// NewModel 返回实例
+func (*GameInventory) NewModel() crud.ModelInterface {
+	return new(GameInventory)
+}
+
+// NewModels 返回实例数组
+func (*GameInventory) NewModels() interface{} {
+	return make([]GameInventory, 0)
+}
+
+// GameItemLog 物品变更记录, 每次发放或消耗的每种物品一行;
+// 同一玩家的同一操作id只能执行一次, 用于幂等
+type GameItemLog struct {
+	crud.BaseModel
+	Uid            int64  `gorm:"NOT NULL;DEFAULT:0;uniqueIndex:uk_game_item_log;COMMENT:玩家id" json:"uid" form:"uid" query:"eq"`
+	OpId           string `gorm:"TYPE:VARCHAR(64);NOT NULL;uniqueIndex:uk_game_item_log;COMMENT:操作id" json:"opId" form:"opId" query:"eq"`
+	ItemId         int64  `gorm:"NOT NULL;DEFAULT:0;uniqueIndex:uk_game_item_log;COMMENT:物品id" json:"itemId" form:"itemId" query:"eq"`
+	ItemCode       string `gorm:"TYPE:VARCHAR(32);NOT NULL;COMMENT:物品代码" json:"itemCode" form:"itemCode" query:"eq"`
+	Reason         string `gorm:"TYPE:VARCHAR(32);INDEX;NOT NULL;COMMENT:原因" json:"reason" form:"reason" query:"eq"`
+	Delta          int64  `gorm:"NOT NULL;DEFAULT:0;COMMENT:变化量,消耗为负" json:"delta"`
+	QuantityBefore int64  `gorm:"NOT NULL;DEFAULT:0;COMMENT:变化前数量" json:"quantityBefore"`
+	QuantityAfter  int64  `gorm:"NOT NULL;DEFAULT:0;COMMENT:变化后数量" json:"quantityAfter"`
+	Operator       int64  `gorm:"NOT NULL;DEFAULT:0;COMMENT:操作人,后台用户id,0为游戏逻辑" json:"operator" form:"operator" query:"eq"`
+	crud.TailColumns
+}
+
+// Table 返回表名
+func (*GameItemLog) Table() string {
+	return "game_item_log"
+}
+
+// NewModel 返回实例
+func (*GameItemLog) NewModel() crud.ModelInterface {
+	return new(GameItemLog)
+}
+
+// NewModels 返回实例数组
+func (*GameItemLog) NewModels() interface{} {
+	return make([]GameItemLog, 0)
+}
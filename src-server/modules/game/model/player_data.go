@@ -0,0 +1,32 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+package model
+
+import "github.com/zhouhp1295/g3/crud"
+
+// GamePlayerData 玩家存档, 按命名空间及key保存JSON值; 每次修改版本号加1, 用于乐观并发控制
+type GamePlayerData struct {
+	crud.BaseModel
+	Uid       int64  `gorm:"NOT NULL;DEFAULT:0;uniqueIndex:uk_game_player_data;COMMENT:玩家id" json:"uid" form:"uid" query:"eq"`
+	Namespace string `gorm:"TYPE:VARCHAR(32);NOT NULL;uniqueIndex:uk_game_player_data;COMMENT:命名空间" json:"namespace" form:"namespace" query:"eq"`
+	DataKey   string `gorm:"TYPE:VARCHAR(64);NOT NULL;uniqueIndex:uk_game_player_data;COMMENT:键" json:"key" form:"key" query:"like"`
+	Value     string `gorm:"TYPE:TEXT;COMMENT:值(JSON)" json:"value" form:"value"`
+	Version   int64  `gorm:"NOT NULL;DEFAULT:0;COMMENT:版本号" json:"version" form:"version"`
+	Size      int    `gorm:"NOT NULL;DEFAULT:0;COMMENT:值的字节数" json:"size"`
+	crud.TailColumns
+}
+
+// Table 返回表名
+func (*GamePlayerData) Table() string {
+	return "game_player_data"
+}
+
+// NewModel 返回实例
+func (*GamePlayerData) NewModel() crud.ModelInterface {
+	return new(GamePlayerData)
+}
+
+// NewModels 返回实例数组
+func (*GamePlayerData) NewModels() interface{} {
+	return make([]GamePlayerData, 0)
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+package model
+
+import (
+	"github.com/zhouhp1295/g3/crud"
+	"time"
+)
+
+// GameReplay 对局回放, 回放数据保存在存储空间中
+type GameReplay struct {
+	crud.BaseModel
+	RoomId    string    `gorm:"TYPE:VARCHAR(64);UNIQUE;COMMENT:房间号" json:"roomId" form:"roomId" query:"eq"`
+	Mode      string    `gorm:"TYPE:VARCHAR(32);INDEX;COMMENT:模式" json:"mode" form:"mode" query:"eq"`
+	Logic     string    `gorm:"TYPE:VARCHAR(32);COMMENT:玩法逻辑" json:"logic" form:"logic" query:"eq"`
+	Node      string    `gorm:"TYPE:VARCHAR(32);COMMENT:websocket节点" json:"node" form:"node" query:"eq"`
+	Players   string    `gorm:"TYPE:VARCHAR(512);COMMENT:参与玩家,逗号分隔且首尾带逗号" json:"players" form:"players" query:"like"`
+	TickRate  int       `gorm:"NOT NULL;DEFAULT:0;COMMENT:帧率" json:"tickRate"`
+	Frames    int64     `gorm:"NOT NULL;DEFAULT:0;COMMENT:帧数" json:"frames"`
+	Duration  int64     `gorm:"NOT NULL;DEFAULT:0;COMMENT:时长(毫秒)" json:"duration"`
+	Reason    string    `gorm:"TYPE:VARCHAR(16);COMMENT:结束原因" json:"reason" form:"reason" query:"eq"`
+	Result    string    `gorm:"TYPE:TEXT;COMMENT:对局结果(JSON)" json:"result"`
+	Entries   int       `gorm:"NOT NULL;DEFAULT:0;COMMENT:记录条数" json:"entries"`
+	Size      int64     `gorm:"NOT NULL;DEFAULT:0;COMMENT:文件大小(字节)" json:"size"`
+	Path      string    `gorm:"TYPE:VARCHAR(255);COMMENT:存储路径" json:"-"`
+	StartedAt time.Time `gorm:"INDEX;COMMENT:开始时间" json:"startedAt"`
+	EndedAt   time.Time `gorm:"COMMENT:结束时间" json:"endedAt"`
+	crud.TailColumns
+}
+
+// Table 返回表名
+func (*GameReplay) Table() string {
+	return "game_replay"
+}
+
+// NewModel 返回实例
+func (*GameReplay) NewModel() crud.ModelInterface {
+	return new(GameReplay)
+}
+
+// NewModels 返回实例数组
+func (*GameReplay) NewModels() interface{} {
+	return make([]GameReplay, 0)
+}
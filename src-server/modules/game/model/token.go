@@ -0,0 +1,36 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+package model
+
+import (
+	"github.com/zhouhp1295/g3/crud"
+	"time"
+)
+
+// GameRefreshToken 游戏客户端的refresh token, 只保存哈希; 每次刷新轮换, 同一次登录的token属于同一家族
+// Status 为0时已吊销
+type GameRefreshToken struct {
+	crud.BaseModel
+	Uid       int64     `gorm:"INDEX;NOT NULL;DEFAULT:0;COMMENT:玩家id" json:"uid" form:"uid" query:"eq"`
+	Family    string    `gorm:"INDEX;TYPE:VARCHAR(36);COMMENT:token家族,即一次登录" json:"family" form:"family" query:"eq"`
+	TokenHash string    `gorm:"UNIQUE;TYPE:VARCHAR(64);COMMENT:token的sha256" json:"-"`
+	Used      string    `gorm:"TYPE:CHAR(1);NOT NULL;DEFAULT:0;COMMENT:是否已轮换 0=NO 1=YES" json:"used" form:"used" query:"eq"`
+	UsedAt    time.Time `gorm:"COMMENT:轮换时间" json:"usedAt"`
+	ExpiresAt time.Time `gorm:"INDEX;COMMENT:过期时间" json:"expiresAt"`
+	crud.TailColumns
+}
+
+// Table 返回表名
+func (*GameRefreshToken) Table() string {
+	return "game_refresh_token"
+}
+
+// NewModel 返回实例
+func (*GameRefreshToken) NewModel() crud.ModelInterface {
+	return new(GameRefreshToken)
+}
+
+// NewModels 返回实例数组
+func (*GameRefreshToken) NewModels() interface{} {
+	return make([]GameRefreshToken, 0)
+}
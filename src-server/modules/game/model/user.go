@@ -6,10 +6,18 @@ import "github.com/zhouhp1295/g3/crud"
 
 type GameUser struct {
 	crud.BaseModel
-	Username string `gorm:"INDEX;TYPE:VARCHAR(36);COMMENT:登录账户" json:"username" form:"username" query:"like"`
+	// Username 登录账户, 小写保存, 唯一
+	Username string `gorm:"uniqueIndex:uk_game_user_username;TYPE:VARCHAR(36);COMMENT:登录账户" json:"username" form:"username" query:"like"`
 	Nickname string `gorm:"TYPE:VARCHAR(20);COMMENT:昵称" json:"nickname" form:"nickname" query:"like"`
 	Avatar   string `gorm:"TYPE:VARCHAR(255);COMMENT:头像" json:"avatar" form:"avatar"`
 	Sex      string `gorm:"TYPE:CHAR(1);NOT NULL;DEFAULT:0;COMMENT:性别" json:"sex" form:"sex" query:"like"`
+	Password string `gorm:"TYPE:VARCHAR(60);COMMENT:密码" json:"-" form:"-"`
+	// Email Phone 经验证码验证后绑定, 唯一, 可用于登录; 没有时为NULL
+	Email *string `gorm:"uniqueIndex:uk_game_user_email;TYPE:VARCHAR(50);COMMENT:邮箱" json:"email" form:"email" query:"like"`
+	Phone *string `gorm:"uniqueIndex:uk_game_user_phone;TYPE:VARCHAR(20);COMMENT:手机号" json:"phone" form:"phone" query:"like"`
+	// DeviceId 游客设备id, 唯一; 没有时为NULL, 以免空值互相冲突
+	DeviceId *string `gorm:"uniqueIndex:uk_game_user_device;TYPE:VARCHAR(64);COMMENT:游客设备id" json:"deviceId" form:"deviceId" query:"eq"`
+	IsGuest  string  `gorm:"TYPE:CHAR(1);NOT NULL;DEFAULT:1;COMMENT:是否游客 0=NO 1=YES" json:"isGuest" form:"isGuest" query:"eq"`
 	crud.TailColumns
 }
 
@@ -0,0 +1,63 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+package model
+
+import "github.com/zhouhp1295/g3/crud"
+
+// WalletSystemUid 系统账户, 发放及回收货币的对方账户, 余额可为负
+const WalletSystemUid = 0
+
+// GameWallet 玩家钱包, 每个玩家每种货币一行; 余额只能通过账本变更
+type GameWallet struct {
+	crud.BaseModel
+	Uid      int64  `gorm:"NOT NULL;DEFAULT:0;uniqueIndex:uk_game_wallet;COMMENT:玩家id,0为系统账户" json:"uid" form:"uid" query:"eq"`
+	Currency string `gorm:"TYPE:VARCHAR(16);NOT NULL;uniqueIndex:uk_game_wallet;COMMENT:货币" json:"currency" form:"currency" query:"eq"`
+	Balance  int64  `gorm:"NOT NULL;DEFAULT:0;COMMENT:余额" json:"balance" form:"balance"`
+	crud.TailColumns
+}
+
+// Table 返回表名
+func (*GameWallet) Table() string {
+	return "game_wallet"
+}
+
+// NewModel 返回实例
+func (*GameWallet) NewModel() crud.ModelInterface {
+	return new(GameWallet)
+}
+
+// NewModels 返回实例数组
+func (*GameWallet) NewModels() interface{} {
+	return make([]GameWallet, 0)
+}
+
+// GameLedger 复式记账的账本分录, 同一交易的分录金额之和为0; 入账为正, 出账为负
+type GameLedger struct {
+	crud.BaseModel
+	TxId         string `gorm:"TYPE:VARCHAR(36);NOT NULL;uniqueIndex:uk_game_ledger;COMMENT:交易id" json:"txId" form:"txId" query:"eq"`
+	Uid          int64  `gorm:"NOT NULL;DEFAULT:0;uniqueIndex:uk_game_ledger;INDEX;COMMENT:玩家id,0为系统账户" json:"uid" form:"uid" query:"eq"`
+	Currency     string `gorm:"TYPE:VARCHAR(16);NOT NULL;uniqueIndex:uk_game_ledger;COMMENT:货币" json:"currency" form:"currency" query:"eq"`
+	Amount       int64  `gorm:"NOT NULL;DEFAULT:0;COMMENT:金额,入账为正,出账为负" json:"amount"`
+	BalanceAfter int64  `gorm:"NOT NULL;DEFAULT:0;COMMENT:变更后余额" json:"balanceAfter"`
+	Reason       string `gorm:"TYPE:VARCHAR(32);INDEX;NOT NULL;COMMENT:原因" json:"reason" form:"reason" query:"eq"`
+	RefId        string `gorm:"TYPE:VARCHAR(64);INDEX;COMMENT:关联id,如订单号" json:"refId" form:"refId" query:"eq"`
+	Operator     int64  `gorm:"NOT NULL;DEFAULT:0;COMMENT:操作人,后台用户id,0为游戏逻辑" json:"operator" form:"operator" query:"eq"`
+	// RefKey 幂等键, 同一原因及关联id对同一玩家的同一货币只入账一次; 关联id为空及系统账户的分录为NULL
+	RefKey *string `gorm:"TYPE:VARCHAR(160);uniqueIndex:uk_game_ledger_ref;COMMENT:幂等键" json:"-"`
+	crud.TailColumns
+}
+
+// Table 返回表名
+func (*GameLedger) Table() string {
+	return "game_ledger"
+}
+
+// NewModel 返回实例
+func (*GameLedger) NewModel() crud.ModelInterface {
+	return new(GameLedger)
+}
+
+// NewModels 返回实例数组
+func (*GameLedger) NewModels() interface{} {
+	return make([]GameLedger, 0)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package room
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// LockstepLogicName 在模式配置中指定 Logic 为该值即使用帧同步
+	LockstepLogicName = "lockstep"
+
+	// LockstepStartRouter 帧同步开始时推送随机种子及帧率
+	LockstepStartRouter = "room/lockstep"
+	// FrameRouter 推送的帧
+	FrameRouter = "room/frame"
+
+	// MaxFramesPerFetch 单次拉取历史帧的上限
+	MaxFramesPerFetch = 1000
+)
+
+func init() {
+	RegisterLogic(LockstepLogicName, func(string) Logic {
+		return NewLockstep()
+	})
+}
+
+// FrameInput 一帧中某个玩家的输入
+type FrameInput struct {
+	Uid  int64       `json:"uid"`
+	Data interface{} `json:"data"`
+}
+
+// Frame 一帧, 没有输入的帧同样下发以驱动客户端推进
+type Frame struct {
+	Frame  uint64       `json:"frame"`
+	Inputs []FrameInput `json:"inputs,omitempty"`
+	// Joined 及 Left 为本帧加入及离开的玩家, 客户端据此在确定的帧上增删角色
+	Joined []int64 `json:"joined,omitempty"`
+	Left   []int64 `json:"left,omitempty"`
+}
+
+// FramesResult 拉取历史帧的结果
+type FramesResult struct {
+	Seed     int64   `json:"seed"`
+	TickRate int     `json:"tickRate"`
+	Current  uint64  `json:"current"`
+	Frames   []Frame `json:"frames"`
+	More     bool    `json:"more"`
+}
+
+// Lockstep 帧同步逻辑: 服务端只收集并按固定帧率下发每帧的输入, 由客户端确定性地模拟
+// 每 BroadcastEvery 帧合并下发一次, 本局的全部帧保存在内存中供重连及后加入的玩家拉取
+type Lockstep struct {
+	BaseLogic
+
+	seed    int64
+	current Frame
+	frames  []Frame
+	// sent 已下发的帧数
+	sent int
+}
+
+// NewLockstep 创建帧同步逻辑
+func NewLockstep() *Lockstep {
+	return &Lockstep{seed: rand.Int63()}
+}
+
+// Seed 本局的随机种子, 客户端使用同一种子保证随机结果一致
+func (l *Lockstep) Seed() int64 {
+	return l.seed
+}
+
+func (l *Lockstep) OnJoin(room *Room, player *Player) error {
+	if room.Status() == StatusPlaying {
+		l.current.Joined = append(l.current.Joined, player.Uid)
+	}
+	return nil
+}
+
+func (l *Lockstep) OnLeave(room *Room, player *Player) {
+	if room.Status() == StatusPlaying {
+		l.current.Left = append(l.current.Left, player.Uid)
+	}
+}
+
+func (l *Lockstep) OnStart(room *Room) {
+	room.Broadcast(LockstepStartRouter, map[string]interface{}{
+		"roomId":   room.Id,
+		"seed":     l.seed,
+		"tickRate": room.Config().TickRate,
+	})
+}
+
+func (l *Lockstep) OnInput(room *Room, player *Player, input Input) {
+	l.current.Inputs = append(l.current.Inputs, FrameInput{Uid: player.Uid, Data: input.Data})
+}
+
+// OnTick 封存当前帧, 达到下发间隔时推送未下发的帧
+func (l *Lockstep) OnTick(room *Room, frame uint64, dt time.Duration) {
+	l.current.Frame = frame
+	l.frames = append(l.frames, l.current)
+	l.current = Frame{}
+	if frame%uint64(room.Config().BroadcastEvery) != 0 {
+		return
+	}
+	room.Broadcast(FrameRouter, map[string]interface{}{
+		"roomId": room.Id,
+		"frames": l.frames[l.sent:],
+	})
+	l.sent = len(l.frames)
+}
+
+// OnClose 下发剩余的帧
+func (l *Lockstep) OnClose(room *Room, reason string) {
+	if l.sent < len(l.frames) {
+		room.Broadcast(FrameRouter, map[string]interface{}{
+			"roomId": room.Id,
+			"frames": l.frames[l.sent:],
+		})
+		l.sent = len(l.frames)
+	}
+}
+
+// RecordBroadcast 帧由回放中的输入及加入离开记录即可重现, 不再重复记录
+func (l *Lockstep) RecordBroadcast(router string) bool {
+	return router != FrameRouter
+}
+
+// Frames 从from帧(含)开始的历史帧, 最多limit帧
+func (l *Lockstep) Frames(room *Room, from uint64, limit int) FramesResult {
+	if limit <= 0 || limit > MaxFramesPerFetch {
+		limit = MaxFramesPerFetch
+	}
+	result := FramesResult{
+		Seed:     l.seed,
+		TickRate: room.Config().TickRate,
+		Current:  uint64(len(l.frames)),
+		Frames:   make([]Frame, 0),
+	}
+	if from < 1 {
+		from = 1
+	}
+	if from > result.Current {
+		return result
+	}
+	// 帧号从1开始, 与下标相差1
+	start := int(from - 1)
+	end := start + limit
+	if end > len(l.frames) {
+		end = len(l.frames)
+	}
+	result.Frames = append(result.Frames, l.frames[start:end]...)
+	result.More = end < len(l.frames)
+	return result
+}
+
+// History 本局的全部帧
+func (l *Lockstep) History() []Frame {
+	result := make([]Frame, len(l.frames))
+	copy(result, l.frames)
+	return result
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package room
+
+import (
+	"testing"
+	"time"
+)
+
+func newLockstepManager() (*Manager, *recorder) {
+	rec := newRecorder()
+	return NewManager(rec.send, map[string]ModeConfig{
+		"test-lockstep": {Logic: LockstepLogicName, TickRate: 100, BroadcastEvery: 2, MaxPlayers: 3, MinPlayers: 2, LateJoin: true},
+	}), rec
+}
+
+func fetchFrames(t *testing.T, r *Room, from uint64, limit int) FramesResult {
+	t.Helper()
+	var result FramesResult
+	err := r.Exec(func(logic Logic) {
+		result = logic.(*Lockstep).Frames(r, from, limit)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestLockstepFrames(t *testing.T) {
+	m, rec := newLockstepManager()
+	r, _ := m.Create("ls1", "test-lockstep", nil)
+	for _, uid := range []int64{1, 2} {
+		_, _ = m.Join(uid, "ls1")
+		_ = m.Ready(uid, true)
+	}
+	if rec.count(1, LockstepStartRouter) != 1 {
+		t.Fatal("seed should be pushed on start")
+	}
+	_ = m.Input(1, "left")
+	_ = m.Input(2, "jump")
+	waitFor(t, "frames", func() bool {
+		return fetchFrames(t, r, 1, 0).Current >= 10
+	})
+
+	// 后加入的玩家在确定的帧上出现, 并可拉取历史帧
+	info, err := m.Join(3, "ls1")
+	if err != nil || info.Status != StatusPlaying {
+		t.Fatalf("late join failed: %v", err)
+	}
+	waitFor(t, "late joiner frames", func() bool {
+		return rec.count(3, FrameRouter) > 0
+	})
+	history := fetchFrames(t, r, 1, 0)
+	inputs, joined := 0, uint64(0)
+	for i, frame := range history.Frames {
+		if frame.Frame != uint64(i+1) {
+			t.Fatalf("frames should be contiguous, got %d at %d", frame.Frame, i)
+		}
+		inputs += len(frame.Inputs)
+		if len(frame.Joined) > 0 && frame.Joined[0] == 3 {
+			joined = frame.Frame
+		}
+	}
+	if inputs != 2 || joined == 0 {
+		t.Fatalf("unexpected history: %d inputs, joined at %d", inputs, joined)
+	}
+
+	page := fetchFrames(t, r, 2, 3)
+	if len(page.Frames) != 3 || page.Frames[0].Frame != 2 || !page.More || page.Seed != history.Seed {
+		t.Fatalf("unexpected page %+v", page)
+	}
+	if len(fetchFrames(t, r, page.Current+100, 0).Frames) != 0 {
+		t.Fatal("should return no frames beyond current")
+	}
+
+	// 推送的帧与历史一致且连续
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+	<-r.Done()
+	var pushed []Frame
+	for _, data := range rec.data(1, FrameRouter) {
+		pushed = append(pushed, data.(map[string]interface{})["frames"].([]Frame)...)
+	}
+	for i, frame := range pushed {
+		if frame.Frame != uint64(i+1) {
+			t.Fatalf("pushed frames should be contiguous, got %d at %d", frame.Frame, i)
+		}
+	}
+	if len(pushed) == 0 || len(pushed) < int(history.Current) {
+		t.Fatalf("all frames should be pushed, got %d", len(pushed))
+	}
+}
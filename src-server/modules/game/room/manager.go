@@ -0,0 +1,237 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package room
+
+import (
+	"github.com/google/uuid"
+	"github.com/zhouhp1295/g3"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+)
+
+// LogicFactory 为每个房间创建独立的Logic
+type LogicFactory func(mode string) Logic
+
+var (
+	logicFactories = make(map[string]LogicFactory)
+	logicMutex     sync.RWMutex
+)
+
+// RegisterLogic 注册玩法逻辑, 在init中调用; 模式通过ModeConfig.Logic或同名选择, 未注册时使用BaseLogic
+func RegisterLogic(name string, factory LogicFactory) {
+	logicMutex.Lock()
+	logicFactories[name] = factory
+	logicMutex.Unlock()
+}
+
+func newLogic(mode string, cfg ModeConfig) Logic {
+	name := cfg.Logic
+	if len(name) == 0 {
+		name = mode
+	}
+	logicMutex.RLock()
+	factory, exist := logicFactories[name]
+	logicMutex.RUnlock()
+	if !exist {
+		return BaseLogic{}
+	}
+	return factory(mode)
+}
+
+// Manager 管理当前节点的房间, 一个玩家同一时间只在一个房间中
+type Manager struct {
+	send  SendFunc
+	modes map[string]ModeConfig
+
+	mutex    sync.RWMutex
+	rooms    map[string]*Room
+	users    map[int64]*Room
+	onReplay func(replay Replay)
+}
+
+// NewManager send 用于推送房间消息
+func NewManager(send SendFunc, modes map[string]ModeConfig) *Manager {
+	m := &Manager{
+		send:  send,
+		modes: make(map[string]ModeConfig),
+		rooms: make(map[string]*Room),
+		users: make(map[int64]*Room),
+	}
+	for name, cfg := range modes {
+		m.modes[name] = cfg.normalize()
+	}
+	return m
+}
+
+// Modes 已配置的模式
+func (m *Manager) Modes() []string {
+	result := make([]string, 0, len(m.modes))
+	for name := range m.modes {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// OnReplay 开启回放的房间关闭时回调, 用于保存回放; 在房间的帧循环中执行
+func (m *Manager) OnReplay(f func(replay Replay)) {
+	m.mutex.Lock()
+	m.onReplay = f
+	m.mutex.Unlock()
+}
+
+// Create 创建房间并启动帧循环, id 为空时自动生成; teams 为匹配得到的参与玩家, 为空时任何玩家都可加入
+func (m *Manager) Create(id, mode string, teams [][]int64) (*Room, error) {
+	cfg, exist := m.modes[mode]
+	if !exist {
+		return nil, ErrUnknownMode
+	}
+	if len(id) == 0 {
+		id = uuid.NewString()
+	}
+	m.mutex.Lock()
+	if _, exist = m.rooms[id]; exist {
+		m.mutex.Unlock()
+		return nil, ErrRoomExists
+	}
+	r := newRoom(id, mode, cfg, newLogic(mode, cfg), teams, m.send)
+	r.onClosed = m.removeRoom
+	r.onLeft = m.release
+	r.onReplay = m.onReplay
+	m.rooms[id] = r
+	m.mutex.Unlock()
+	r.start()
+	g3.ZL().Info("room created",
+		zap.String("roomId", id),
+		zap.String("mode", mode),
+		zap.Reflect("teams", teams))
+	return r, nil
+}
+
+func (m *Manager) removeRoom(r *Room) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.rooms[r.Id] == r {
+		delete(m.rooms, r.Id)
+	}
+	for uid, room := range m.users {
+		if room == r {
+			delete(m.users, uid)
+		}
+	}
+}
+
+// Get 查找房间
+func (m *Manager) Get(id string) (*Room, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	r, exist := m.rooms[id]
+	return r, exist
+}
+
+// RoomOf 玩家所在的房间
+func (m *Manager) RoomOf(uid int64) (*Room, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	r, exist := m.users[uid]
+	return r, exist
+}
+
+// Count 房间数
+func (m *Manager) Count() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return len(m.rooms)
+}
+
+// Rooms 所有房间
+func (m *Manager) Rooms() []*Room {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	result := make([]*Room, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		result = append(result, r)
+	}
+	return result
+}
+
+// Join 加入房间, 已在该房间中时视为重连
+func (m *Manager) Join(uid int64, id string) (Info, error) {
+	m.mutex.Lock()
+	r, exist := m.rooms[id]
+	if !exist {
+		m.mutex.Unlock()
+		return Info{}, ErrRoomNotFound
+	}
+	if current, in := m.users[uid]; in && current != r {
+		m.mutex.Unlock()
+		return Info{}, ErrAlreadyInRoom
+	}
+	// 先占位, 防止同时加入多个房间
+	m.users[uid] = r
+	m.mutex.Unlock()
+
+	info, err := r.join(uid)
+	if err != nil {
+		m.release(r, uid)
+	}
+	return info, err
+}
+
+// release 玩家离开房间后解除关联
+func (m *Manager) release(r *Room, uid int64) {
+	m.mutex.Lock()
+	if m.users[uid] == r {
+		delete(m.users, uid)
+	}
+	m.mutex.Unlock()
+}
+
+// Leave 离开当前房间
+func (m *Manager) Leave(uid int64) error {
+	r, exist := m.RoomOf(uid)
+	if !exist {
+		return ErrNotInRoom
+	}
+	err := r.leave(uid)
+	if err == ErrRoomClosed {
+		return ErrNotInRoom
+	}
+	return err
+}
+
+// Ready 设置准备状态
+func (m *Manager) Ready(uid int64, ready bool) error {
+	r, exist := m.RoomOf(uid)
+	if !exist {
+		return ErrNotInRoom
+	}
+	return r.ready(uid, ready)
+}
+
+// Input 提交输入
+func (m *Manager) Input(uid int64, data interface{}) error {
+	r, exist := m.RoomOf(uid)
+	if !exist {
+		return ErrNotInRoom
+	}
+	return r.input(uid, data)
+}
+
+// Offline 玩家的连接全部断开后调用, 等待中的房间直接移出, 对局中保留到超时
+func (m *Manager) Offline(uid int64) {
+	r, exist := m.RoomOf(uid)
+	if !exist {
+		return
+	}
+	_ = r.offline(uid)
+}
+
+// CloseAll 关闭所有房间并等待完成, 用于停机
+func (m *Manager) CloseAll() {
+	for _, r := range m.Rooms() {
+		r.Close()
+		<-r.Done()
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package room
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zhouhp1295/g3"
+	"go.uber.org/zap"
+	"io"
+	"time"
+)
+
+const (
+	// ReplayVersion 回放格式版本
+	ReplayVersion = 1
+	// ReplayInputRouter 回放中玩家输入的路由
+	ReplayInputRouter = "room/input"
+)
+
+// ReplayHeader 回放首行
+type ReplayHeader struct {
+	Version   int       `json:"version"`
+	RoomId    string    `json:"roomId"`
+	Mode      string    `json:"mode"`
+	Logic     string    `json:"logic"`
+	TickRate  int       `json:"tickRate"`
+	Players   []Player  `json:"players"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// ReplayEntry 回放中的一条记录, 字段名取单字母以减小体积
+type ReplayEntry struct {
+	// Frame 记录时的帧号, 输入为其生效的帧
+	Frame uint64 `json:"f"`
+	// Time 距开始的毫秒数
+	Time int64 `json:"t"`
+	// Uid 输入的玩家, 广播为0
+	Uid    int64       `json:"u,omitempty"`
+	Router string      `json:"r"`
+	Data   interface{} `json:"d,omitempty"`
+}
+
+// Replay 一局的回放, 房间关闭时交给 Manager.OnReplay 保存
+type Replay struct {
+	ReplayHeader
+	EndedAt time.Time
+	Frames  uint64
+	Reason  string
+	Result  interface{}
+	Entries int
+	// Data gzip压缩的JSON Lines: 首行为ReplayHeader, 之后每行一条ReplayEntry
+	Data []byte
+}
+
+// ReplayFilter 可由 Logic 实现, 决定哪些广播写入回放; 未实现时记录所有广播
+// 玩家输入总是记录, 可由输入重现的广播(如帧同步的帧)无需重复记录
+type ReplayFilter interface {
+	RecordBroadcast(router string) bool
+}
+
+// replayRecorder 对局开始后记录广播及输入
+type replayRecorder struct {
+	header  ReplayHeader
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	entries int
+}
+
+func newReplayRecorder(header ReplayHeader) *replayRecorder {
+	rec := &replayRecorder{header: header}
+	rec.gz, _ = gzip.NewWriterLevel(&rec.buf, gzip.BestCompression)
+	rec.writeLine(header)
+	return rec
+}
+
+func (rec *replayRecorder) writeLine(v interface{}) bool {
+	line, err := jsoniter.Marshal(v)
+	if err != nil {
+		g3.ZL().Warn("replay entry skipped",
+			zap.String("roomId", rec.header.RoomId),
+			zap.Error(err))
+		return false
+	}
+	_, _ = rec.gz.Write(line)
+	_, _ = rec.gz.Write([]byte{'\n'})
+	return true
+}
+
+func (rec *replayRecorder) record(frame uint64, at time.Time, uid int64, router string, data interface{}) {
+	if rec.writeLine(ReplayEntry{
+		Frame:  frame,
+		Time:   at.Sub(rec.header.StartedAt).Milliseconds(),
+		Uid:    uid,
+		Router: router,
+		Data:   data,
+	}) {
+		rec.entries++
+	}
+}
+
+func (rec *replayRecorder) finish() []byte {
+	_ = rec.gz.Close()
+	return rec.buf.Bytes()
+}
+
+// ReadReplay 解析回放数据, 用于回放工具及排查不同步
+func ReadReplay(r io.Reader) (ReplayHeader, []ReplayEntry, error) {
+	header := ReplayHeader{}
+	entries := make([]ReplayEntry, 0)
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return header, entries, err
+	}
+	defer func() {
+		_ = gz.Close()
+	}()
+	scanner := bufio.NewScanner(gz)
+	// 单帧的状态可能较大
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err = scanner.Err(); err == nil {
+			err = errors.New("replay is empty")
+		}
+		return header, entries, err
+	}
+	if err = jsoniter.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return header, entries, err
+	}
+	for scanner.Scan() {
+		entry := ReplayEntry{}
+		if err = jsoniter.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return header, entries, err
+		}
+		entries = append(entries, entry)
+	}
+	return header, entries, scanner.Err()
+}
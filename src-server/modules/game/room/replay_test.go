@@ -0,0 +1,105 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package room
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRoomReplay(t *testing.T) {
+	logic := &counterLogic{target: 3}
+	m, _ := newTestManager("test-replay", ModeConfig{TickRate: 100, BroadcastEvery: 5, Replay: true}, logic)
+	replays := make(chan Replay, 1)
+	m.OnReplay(func(replay Replay) {
+		replays <- replay
+	})
+	r, _ := m.Create("rp1", "test-replay", [][]int64{{1}, {2}})
+	for _, uid := range []int64{1, 2} {
+		_, _ = m.Join(uid, "rp1")
+		_ = m.Ready(uid, true)
+	}
+	_ = m.Input(1, float64(1))
+	_ = m.Input(2, float64(2))
+
+	var replay Replay
+	select {
+	case replay = <-replays:
+	case <-time.After(2 * time.Second):
+		t.Fatal("replay should be saved on close")
+	}
+	<-r.Done()
+	if replay.RoomId != "rp1" || replay.Reason != CloseReasonFinished || replay.Frames == 0 {
+		t.Fatalf("unexpected replay %+v", replay.ReplayHeader)
+	}
+
+	header, entries, err := ReadReplay(bytes.NewReader(replay.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != ReplayVersion || header.Logic != "test-replay" || len(header.Players) != 2 {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if len(entries) != replay.Entries {
+		t.Fatalf("expected %d entries, got %d", replay.Entries, len(entries))
+	}
+	if entries[0].Router != StartedRouter || entries[len(entries)-1].Router != ClosedRouter {
+		t.Fatalf("replay should start with started and end with closed, got %s and %s",
+			entries[0].Router, entries[len(entries)-1].Router)
+	}
+	inputs := make(map[int64]float64)
+	for _, entry := range entries {
+		if entry.Router == ReplayInputRouter {
+			inputs[entry.Uid] = entry.Data.(float64)
+		}
+	}
+	if inputs[1] != 1 || inputs[2] != 2 {
+		t.Fatalf("inputs should be recorded, got %v", inputs)
+	}
+}
+
+func TestLockstepReplaySkipsFrames(t *testing.T) {
+	rec := newRecorder()
+	m := NewManager(rec.send, map[string]ModeConfig{
+		"test-lockstep-replay": {Logic: LockstepLogicName, TickRate: 100, BroadcastEvery: 2, Replay: true},
+	})
+	replays := make(chan Replay, 1)
+	m.OnReplay(func(replay Replay) {
+		replays <- replay
+	})
+	r, _ := m.Create("lr1", "test-lockstep-replay", nil)
+	for _, uid := range []int64{1, 2} {
+		_, _ = m.Join(uid, "lr1")
+		_ = m.Ready(uid, true)
+	}
+	_ = m.Input(1, "left")
+	waitFor(t, "frames", func() bool {
+		return rec.count(1, FrameRouter) > 1
+	})
+	m.CloseAll()
+
+	var replay Replay
+	select {
+	case replay = <-replays:
+	case <-time.After(2 * time.Second):
+		t.Fatal("replay should be saved on close")
+	}
+	<-r.Done()
+	_, entries, err := ReadReplay(bytes.NewReader(replay.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := 0
+	for _, entry := range entries {
+		if entry.Router == FrameRouter {
+			t.Fatal("lockstep frames should not be recorded twice")
+		}
+		if entry.Router == ReplayInputRouter {
+			inputs++
+		}
+	}
+	if inputs != 1 {
+		t.Fatalf("expected 1 recorded input, got %d", inputs)
+	}
+}
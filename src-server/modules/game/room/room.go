@@ -0,0 +1,737 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package room
+
+import (
+	"errors"
+	"github.com/zhouhp1295/g3"
+	"go.uber.org/zap"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// JoinedRouter 玩家加入时推送给房间成员
+	JoinedRouter = "room/joined"
+	// LeftRouter 玩家离开时推送
+	LeftRouter = "room/left"
+	// ReadyRouter 玩家准备状态变化时推送
+	ReadyRouter = "room/ready"
+	// OnlineRouter 对局中玩家掉线或重连时推送
+	OnlineRouter = "room/online"
+	// StartedRouter 全部玩家准备后开始时推送
+	StartedRouter = "room/started"
+	// StateRouter 定时广播的状态
+	StateRouter = "room/state"
+	// ClosedRouter 房间关闭时推送
+	ClosedRouter = "room/closed"
+)
+
+const (
+	CloseReasonEmpty    = "empty"
+	CloseReasonTimeout  = "timeout"
+	CloseReasonAborted  = "aborted"
+	CloseReasonFinished = "finished"
+	CloseReasonError    = "error"
+	CloseReasonShutdown = "shutdown"
+)
+
+var (
+	ErrUnknownMode   = errors.New("unknown room mode")
+	ErrRoomExists    = errors.New("room already exists")
+	ErrRoomNotFound  = errors.New("room not found")
+	ErrRoomClosed    = errors.New("room is closed")
+	ErrRoomFull      = errors.New("room is full")
+	ErrNotAllowed    = errors.New("not allowed to join this room")
+	ErrAlreadyInRoom = errors.New("already in another room")
+	ErrNotInRoom     = errors.New("not in room")
+	ErrNotPlaying    = errors.New("room is not playing")
+)
+
+// Status 房间状态
+type Status int
+
+const (
+	StatusWaiting Status = iota
+	StatusPlaying
+	StatusClosed
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusWaiting:
+		return "waiting"
+	case StatusPlaying:
+		return "playing"
+	default:
+		return "closed"
+	}
+}
+
+// MarshalText 推送及接口中以字符串表示
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ModeConfig 房间模式
+type ModeConfig struct {
+	// Logic 使用的玩法逻辑名, 为空时使用与模式同名的逻辑
+	Logic string
+	// TickRate 每秒帧数, 默认20
+	TickRate int
+	// BroadcastEvery 每隔多少帧广播一次状态, 默认1
+	BroadcastEvery int
+	// MaxPlayers 最大人数, 默认2
+	MaxPlayers int
+	// MinPlayers 开始所需的最少人数, 默认与MaxPlayers相同; 指定了参与玩家的房间为参与玩家数
+	MinPlayers int
+	// OfflineTimeout 对局中掉线的玩家超过该时长未重连则移出房间, 0为立即移出
+	OfflineTimeout time.Duration
+	// WaitTimeout 创建后超过该时长仍未开始则关闭, 默认1分钟
+	WaitTimeout time.Duration
+	// LateJoin 是否允许对局开始后加入, 指定了参与玩家的房间仍只允许参与玩家加入
+	LateJoin bool
+	// Replay 是否记录回放, 记录对局开始后的广播及玩家输入
+	Replay bool
+}
+
+func (cfg ModeConfig) normalize() ModeConfig {
+	if cfg.TickRate < 1 {
+		cfg.TickRate = 20
+	}
+	if cfg.BroadcastEvery < 1 {
+		cfg.BroadcastEvery = 1
+	}
+	if cfg.MaxPlayers < 1 {
+		cfg.MaxPlayers = 2
+	}
+	if cfg.MinPlayers < 1 || cfg.MinPlayers > cfg.MaxPlayers {
+		cfg.MinPlayers = cfg.MaxPlayers
+	}
+	if cfg.WaitTimeout <= 0 {
+		cfg.WaitTimeout = time.Minute
+	}
+	return cfg
+}
+
+// Player 房间中的玩家
+type Player struct {
+	Uid      int64     `json:"uid"`
+	Team     int       `json:"team"`
+	Ready    bool      `json:"ready"`
+	Online   bool      `json:"online"`
+	JoinedAt time.Time `json:"joinedAt"`
+	// Data 供Logic保存玩家数据
+	Data interface{} `json:"-"`
+
+	offlineAt time.Time
+}
+
+// Input 玩家输入, 在下一帧开始时按到达顺序交给Logic
+type Input struct {
+	Uid   int64       `json:"uid"`
+	Frame uint64      `json:"frame"`
+	Data  interface{} `json:"data"`
+}
+
+// Info 房间快照
+type Info struct {
+	Id      string    `json:"id"`
+	Mode    string    `json:"mode"`
+	Status  Status    `json:"status"`
+	Frame   uint64    `json:"frame"`
+	Players []Player  `json:"players"`
+	Created time.Time `json:"created"`
+}
+
+// Logic 房间的玩法逻辑, 每种模式一个实现
+// 所有回调都在房间的帧循环中串行执行, 实现中无需加锁, 但不要调用 Manager 的方法
+type Logic interface {
+	// Init 房间创建后执行
+	Init(room *Room)
+	// OnJoin 新玩家加入, 返回错误时拒绝加入; 重连不会触发
+	OnJoin(room *Room, player *Player) error
+	// OnLeave 玩家离开或掉线超时被移出
+	OnLeave(room *Room, player *Player)
+	// OnStart 全部玩家准备后开始
+	OnStart(room *Room)
+	// OnInput 对局中收到的玩家输入
+	OnInput(room *Room, player *Player, input Input)
+	// OnTick 对局中每帧执行
+	OnTick(room *Room, frame uint64, dt time.Duration)
+	// State 定时广播给房间成员的状态, 返回nil时不广播
+	State(room *Room) interface{}
+	// OnClose 房间关闭
+	OnClose(room *Room, reason string)
+}
+
+// BaseLogic 空实现, 供具体玩法嵌入
+type BaseLogic struct{}
+
+func (BaseLogic) Init(*Room) {}
+
+func (BaseLogic) OnJoin(*Room, *Player) error {
+	return nil
+}
+
+func (BaseLogic) OnLeave(*Room, *Player) {}
+
+func (BaseLogic) OnStart(*Room) {}
+
+func (BaseLogic) OnInput(*Room, *Player, Input) {}
+
+func (BaseLogic) OnTick(*Room, uint64, time.Duration) {}
+
+func (BaseLogic) State(*Room) interface{} {
+	return nil
+}
+
+func (BaseLogic) OnClose(*Room, string) {}
+
+// SendFunc 向玩家推送, 返回送达的连接数, 如 (*net.WsWorker).SendToUser
+type SendFunc func(uid int64, router string, data interface{}) int
+
+// Room 服务端权威的房间, 状态只在帧循环协程中修改
+type Room struct {
+	Id   string
+	Mode string
+
+	cfg   ModeConfig
+	logic Logic
+	send  SendFunc
+
+	// 以下字段只在帧循环中访问
+	status   Status
+	frame    uint64
+	players  map[int64]*Player
+	order    []int64
+	reserved map[int64]int
+	pending  []Input
+	created  time.Time
+	closing  string
+	result   interface{}
+	recorder *replayRecorder
+
+	cmds      chan func()
+	stop      chan struct{}
+	stopOnce  sync.Once
+	closed    chan struct{}
+	onClosed  func(room *Room)
+	onLeft    func(room *Room, uid int64)
+	onReplay  func(replay Replay)
+	now       func() time.Time
+	startOnce sync.Once
+}
+
+// newRoom teams 为匹配得到的参与玩家, 为空时任何玩家都可加入
+func newRoom(id, mode string, cfg ModeConfig, logic Logic, teams [][]int64, send SendFunc) *Room {
+	r := &Room{
+		Id:       id,
+		Mode:     mode,
+		cfg:      cfg.normalize(),
+		logic:    logic,
+		send:     send,
+		status:   StatusWaiting,
+		players:  make(map[int64]*Player),
+		order:    make([]int64, 0),
+		reserved: make(map[int64]int),
+		cmds:     make(chan func()),
+		stop:     make(chan struct{}),
+		closed:   make(chan struct{}),
+		now:      time.Now,
+	}
+	for team, uids := range teams {
+		for _, uid := range uids {
+			r.reserved[uid] = team
+		}
+	}
+	if n := len(r.reserved); n > 0 {
+		if n > r.cfg.MaxPlayers {
+			r.cfg.MaxPlayers = n
+		}
+		r.cfg.MinPlayers = n
+	}
+	return r
+}
+
+// start 启动帧循环
+func (r *Room) start() {
+	r.startOnce.Do(func() {
+		r.created = r.now()
+		r.call("init", func() {
+			r.logic.Init(r)
+		})
+		go r.run()
+	})
+}
+
+func (r *Room) run() {
+	ticker := time.NewTicker(time.Second / time.Duration(r.cfg.TickRate))
+	defer ticker.Stop()
+	last := r.now()
+	for {
+		select {
+		case <-r.stop:
+			r.closing = CloseReasonShutdown
+		case f := <-r.cmds:
+			f()
+		case <-ticker.C:
+			now := r.now()
+			r.step(now, now.Sub(last))
+			last = now
+		}
+		if len(r.closing) > 0 {
+			r.shutdown()
+			return
+		}
+	}
+}
+
+// do 在帧循环中执行f并等待完成
+func (r *Room) do(f func()) error {
+	done := make(chan struct{})
+	select {
+	case r.cmds <- func() {
+		f()
+		close(done)
+	}:
+	case <-r.closed:
+		return ErrRoomClosed
+	}
+	select {
+	case <-done:
+		return nil
+	case <-r.closed:
+		select {
+		case <-done:
+			return nil
+		default:
+			return ErrRoomClosed
+		}
+	}
+}
+
+// call 执行Logic回调, panic时记录日志并关闭房间
+func (r *Room) call(event string, f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			g3.ZL().Error("room logic panic",
+				zap.String("roomId", r.Id),
+				zap.String("mode", r.Mode),
+				zap.String("event", event),
+				zap.Reflect("error", err),
+				zap.ByteString("stack", debug.Stack()))
+			if len(r.closing) == 0 {
+				r.closing = CloseReasonError
+			}
+		}
+	}()
+	f()
+}
+
+func (r *Room) step(now time.Time, dt time.Duration) {
+	for _, uid := range r.Uids() {
+		player := r.players[uid]
+		if !player.Online && now.Sub(player.offlineAt) >= r.cfg.OfflineTimeout {
+			r.remove(player)
+		}
+	}
+	if len(r.closing) > 0 {
+		return
+	}
+	if r.status == StatusWaiting {
+		if now.Sub(r.created) >= r.cfg.WaitTimeout {
+			r.closing = CloseReasonTimeout
+		}
+		return
+	}
+	inputs := r.pending
+	r.pending = nil
+	for _, input := range inputs {
+		if player, exist := r.players[input.Uid]; exist {
+			if r.recorder != nil {
+				r.recorder.record(input.Frame, now, input.Uid, ReplayInputRouter, input.Data)
+			}
+			r.call("input", func() {
+				r.logic.OnInput(r, player, input)
+			})
+		}
+	}
+	r.frame++
+	r.call("tick", func() {
+		r.logic.OnTick(r, r.frame, dt)
+	})
+	if len(r.closing) == 0 && r.frame%uint64(r.cfg.BroadcastEvery) == 0 {
+		var state interface{}
+		r.call("state", func() {
+			state = r.logic.State(r)
+		})
+		if state != nil {
+			r.Broadcast(StateRouter, map[string]interface{}{
+				"roomId": r.Id,
+				"frame":  r.frame,
+				"state":  state,
+			})
+		}
+	}
+}
+
+func (r *Room) shutdown() {
+	r.status = StatusClosed
+	r.call("close", func() {
+		r.logic.OnClose(r, r.closing)
+	})
+	r.Broadcast(ClosedRouter, map[string]interface{}{
+		"roomId": r.Id,
+		"reason": r.closing,
+		"result": r.result,
+	})
+	if r.recorder != nil {
+		r.saveReplay()
+	}
+	close(r.closed)
+	g3.ZL().Info("room closed",
+		zap.String("roomId", r.Id),
+		zap.String("mode", r.Mode),
+		zap.String("reason", r.closing),
+		zap.Uint64("frames", r.frame))
+	if r.onClosed != nil {
+		r.onClosed(r)
+	}
+}
+
+// join 加入房间, 已在房间中(重连)时恢复在线
+func (r *Room) join(uid int64) (Info, error) {
+	var info Info
+	var err error
+	if e := r.do(func() {
+		if player, exist := r.players[uid]; exist {
+			if !player.Online {
+				player.Online = true
+				r.Broadcast(OnlineRouter, map[string]interface{}{"roomId": r.Id, "uid": uid, "online": true})
+			}
+			info = r.info()
+			return
+		}
+		team, reserved := r.reserved[uid]
+		if len(r.reserved) > 0 && !reserved {
+			err = ErrNotAllowed
+			return
+		}
+		if r.status != StatusWaiting && !(r.status == StatusPlaying && r.cfg.LateJoin) {
+			err = ErrNotAllowed
+			return
+		}
+		if len(r.players) >= r.cfg.MaxPlayers {
+			err = ErrRoomFull
+			return
+		}
+		if !reserved {
+			team = len(r.players)
+		}
+		player := &Player{Uid: uid, Team: team, Online: true, Ready: r.status == StatusPlaying, JoinedAt: r.now()}
+		r.call("join", func() {
+			err = r.logic.OnJoin(r, player)
+		})
+		if err != nil {
+			return
+		}
+		r.players[uid] = player
+		r.order = append(r.order, uid)
+		r.Broadcast(JoinedRouter, map[string]interface{}{"roomId": r.Id, "player": *player}, uid)
+		info = r.info()
+	}); e != nil {
+		return info, e
+	}
+	return info, err
+}
+
+// leave 离开房间
+func (r *Room) leave(uid int64) error {
+	var err error
+	if e := r.do(func() {
+		player, exist := r.players[uid]
+		if !exist {
+			err = ErrNotInRoom
+			return
+		}
+		r.remove(player)
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+func (r *Room) remove(player *Player) {
+	delete(r.players, player.Uid)
+	for i, uid := range r.order {
+		if uid == player.Uid {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	r.call("leave", func() {
+		r.logic.OnLeave(r, player)
+	})
+	if r.onLeft != nil {
+		r.onLeft(r, player.Uid)
+	}
+	r.Broadcast(LeftRouter, map[string]interface{}{"roomId": r.Id, "uid": player.Uid})
+	switch {
+	case len(r.players) == 0:
+		r.closing = CloseReasonEmpty
+	case r.status == StatusWaiting && len(r.reserved) > 0:
+		// 匹配的玩家未开始就离开, 本局取消
+		r.closing = CloseReasonAborted
+	default:
+		// 有人离开后剩余玩家可能已全部准备
+		r.tryStart()
+	}
+}
+
+// offline 玩家掉线, 等待中的房间直接移出, 对局中保留到OfflineTimeout
+func (r *Room) offline(uid int64) error {
+	return r.do(func() {
+		player, exist := r.players[uid]
+		if !exist || !player.Online {
+			return
+		}
+		if r.status != StatusPlaying || r.cfg.OfflineTimeout <= 0 {
+			r.remove(player)
+			return
+		}
+		player.Online = false
+		player.offlineAt = r.now()
+		r.Broadcast(OnlineRouter, map[string]interface{}{"roomId": r.Id, "uid": uid, "online": false})
+	})
+}
+
+// ready 设置准备状态, 全部准备后开始
+func (r *Room) ready(uid int64, ready bool) error {
+	var err error
+	if e := r.do(func() {
+		player, exist := r.players[uid]
+		if !exist {
+			err = ErrNotInRoom
+			return
+		}
+		if r.status != StatusWaiting {
+			err = ErrNotAllowed
+			return
+		}
+		player.Ready = ready
+		r.Broadcast(ReadyRouter, map[string]interface{}{"roomId": r.Id, "uid": uid, "ready": ready})
+		r.tryStart()
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+func (r *Room) tryStart() {
+	if r.status != StatusWaiting || len(r.players) < r.cfg.MinPlayers {
+		return
+	}
+	for _, player := range r.players {
+		if !player.Ready {
+			return
+		}
+	}
+	r.status = StatusPlaying
+	r.frame = 0
+	info := r.info()
+	if r.cfg.Replay {
+		logic := r.cfg.Logic
+		if len(logic) == 0 {
+			logic = r.Mode
+		}
+		r.recorder = newReplayRecorder(ReplayHeader{
+			Version:   ReplayVersion,
+			RoomId:    r.Id,
+			Mode:      r.Mode,
+			Logic:     logic,
+			TickRate:  r.cfg.TickRate,
+			Players:   info.Players,
+			StartedAt: r.now(),
+		})
+	}
+	r.Broadcast(StartedRouter, info)
+	r.call("start", func() {
+		r.logic.OnStart(r)
+	})
+	g3.ZL().Info("room started",
+		zap.String("roomId", r.Id),
+		zap.String("mode", r.Mode),
+		zap.Int64s("players", r.order))
+}
+
+// input 输入在下一帧交给Logic
+func (r *Room) input(uid int64, data interface{}) error {
+	var err error
+	if e := r.do(func() {
+		if _, exist := r.players[uid]; !exist {
+			err = ErrNotInRoom
+			return
+		}
+		if r.status != StatusPlaying {
+			err = ErrNotPlaying
+			return
+		}
+		r.pending = append(r.pending, Input{Uid: uid, Frame: r.frame + 1, Data: data})
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+// Info 房间快照, 可在任意协程调用
+func (r *Room) Info() (Info, error) {
+	var info Info
+	err := r.do(func() {
+		info = r.info()
+	})
+	return info, err
+}
+
+func (r *Room) info() Info {
+	info := Info{
+		Id:      r.Id,
+		Mode:    r.Mode,
+		Status:  r.status,
+		Frame:   r.frame,
+		Players: make([]Player, 0, len(r.order)),
+		Created: r.created,
+	}
+	for _, uid := range r.order {
+		info.Players = append(info.Players, *r.players[uid])
+	}
+	return info
+}
+
+// Exec 在帧循环中执行f, 用于在其它协程读取Logic的数据
+func (r *Room) Exec(f func(logic Logic)) error {
+	return r.do(func() {
+		f(r.logic)
+	})
+}
+
+// Close 关闭房间, 可在任意协程调用; Logic中结束对局请使用Finish
+func (r *Room) Close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// Done 房间关闭后返回
+func (r *Room) Done() <-chan struct{} {
+	return r.closed
+}
+
+// 以下方法只能在Logic回调中调用
+
+// Status 房间状态
+func (r *Room) Status() Status {
+	return r.status
+}
+
+// Frame 当前帧号, 开始后从1递增
+func (r *Room) Frame() uint64 {
+	return r.frame
+}
+
+// Config 房间模式配置
+func (r *Room) Config() ModeConfig {
+	return r.cfg
+}
+
+// Player 房间中的玩家
+func (r *Room) Player(uid int64) (*Player, bool) {
+	player, exist := r.players[uid]
+	return player, exist
+}
+
+// Uids 按加入顺序排列的玩家
+func (r *Room) Uids() []int64 {
+	result := make([]int64, len(r.order))
+	copy(result, r.order)
+	return result
+}
+
+// Teams 按队伍分组的玩家
+func (r *Room) Teams() map[int][]int64 {
+	result := make(map[int][]int64)
+	for _, uid := range r.order {
+		team := r.players[uid].Team
+		result[team] = append(result[team], uid)
+	}
+	for _, uids := range result {
+		sort.Slice(uids, func(i, j int) bool {
+			return uids[i] < uids[j]
+		})
+	}
+	return result
+}
+
+// Send 推送给房间中的某个玩家
+func (r *Room) Send(uid int64, router string, data interface{}) {
+	if r.send != nil {
+		r.send(uid, router, data)
+	}
+}
+
+// Broadcast 推送给房间中所有在线玩家, exclude 为不推送的玩家; 开启回放时记录, 见 ReplayFilter
+func (r *Room) Broadcast(router string, data interface{}, exclude ...int64) {
+	if r.recorder != nil {
+		if filter, ok := r.logic.(ReplayFilter); !ok || filter.RecordBroadcast(router) {
+			r.recorder.record(r.frame, r.now(), 0, router, data)
+		}
+	}
+	for _, uid := range r.order {
+		player := r.players[uid]
+		if !player.Online {
+			continue
+		}
+		skip := false
+		for _, e := range exclude {
+			skip = skip || e == uid
+		}
+		if !skip {
+			r.Send(uid, router, data)
+		}
+	}
+}
+
+// Finish 结束对局, 本帧结束后关闭房间, result 随关闭推送发给玩家
+func (r *Room) Finish(result interface{}) {
+	r.result = result
+	if len(r.closing) == 0 {
+		r.closing = CloseReasonFinished
+	}
+}
+
+// saveReplay 房间关闭时保存回放, 在房间标记为关闭前执行, 停机时会等待保存完成
+func (r *Room) saveReplay() {
+	defer func() {
+		if err := recover(); err != nil {
+			g3.ZL().Error("save replay panic",
+				zap.String("roomId", r.Id),
+				zap.Reflect("error", err),
+				zap.ByteString("stack", debug.Stack()))
+		}
+	}()
+	data := r.recorder.finish()
+	if r.onReplay == nil {
+		return
+	}
+	r.onReplay(Replay{
+		ReplayHeader: r.recorder.header,
+		EndedAt:      r.now(),
+		Frames:       r.frame,
+		Reason:       r.closing,
+		Result:       r.result,
+		Entries:      r.recorder.entries,
+		Data:         data,
+	})
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package room
+
+import (
+	"github.com/zhouhp1295/g3"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type push struct {
+	router string
+	data   interface{}
+}
+
+// recorder 记录推送给每个玩家的消息
+type recorder struct {
+	mutex  sync.Mutex
+	pushes map[int64][]push
+}
+
+func newRecorder() *recorder {
+	return &recorder{pushes: make(map[int64][]push)}
+}
+
+func (r *recorder) send(uid int64, router string, data interface{}) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pushes[uid] = append(r.pushes[uid], push{router: router, data: data})
+	return 1
+}
+
+func (r *recorder) count(uid int64, router string) int {
+	return len(r.data(uid, router))
+}
+
+func (r *recorder) data(uid int64, router string) []interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	result := make([]interface{}, 0)
+	for _, pushed := range r.pushes[uid] {
+		if pushed.router == router {
+			result = append(result, pushed.data)
+		}
+	}
+	return result
+}
+
+// counterLogic 累加玩家输入, 达到目标后结束
+type counterLogic struct {
+	BaseLogic
+	mutex  sync.Mutex
+	target int
+	total  int
+	ticks  int
+	closed string
+}
+
+func (l *counterLogic) OnInput(room *Room, player *Player, input Input) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.total += int(input.Data.(float64))
+}
+
+func (l *counterLogic) OnTick(room *Room, frame uint64, dt time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.ticks++
+	if l.target > 0 && l.total >= l.target {
+		room.Finish(map[string]int{"total": l.total})
+	}
+}
+
+func (l *counterLogic) State(room *Room) interface{} {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.total
+}
+
+func (l *counterLogic) OnClose(room *Room, reason string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.closed = reason
+}
+
+func (l *counterLogic) reason() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.closed
+}
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "g3-game-room")
+	if err != nil {
+		panic(err)
+	}
+	g3.Boot(&g3.Cfg{HomeDir: dir})
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestManager(mode string, cfg ModeConfig, logic Logic) (*Manager, *recorder) {
+	RegisterLogic(mode, func(string) Logic {
+		return logic
+	})
+	rec := newRecorder()
+	return NewManager(rec.send, map[string]ModeConfig{mode: cfg}), rec
+}
+
+func TestRoomLifecycle(t *testing.T) {
+	logic := &counterLogic{target: 5}
+	m, rec := newTestManager("test-lifecycle", ModeConfig{TickRate: 100, MaxPlayers: 2}, logic)
+	if _, err := m.Create("", "unknown", nil); err != ErrUnknownMode {
+		t.Fatalf("expected ErrUnknownMode, got %v", err)
+	}
+	r, err := m.Create("r1", "test-lifecycle", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Create("r1", "test-lifecycle", nil); err != ErrRoomExists {
+		t.Fatalf("expected ErrRoomExists, got %v", err)
+	}
+	for _, uid := range []int64{1, 2} {
+		if _, err = m.Join(uid, "r1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = m.Join(3, "r1"); err != ErrRoomFull {
+		t.Fatalf("expected ErrRoomFull, got %v", err)
+	}
+	if rec.count(1, JoinedRouter) != 1 || rec.count(2, JoinedRouter) != 0 {
+		t.Fatal("joined should be pushed to the other members only")
+	}
+	if err = m.Input(1, float64(1)); err != ErrNotPlaying {
+		t.Fatalf("expected ErrNotPlaying, got %v", err)
+	}
+	_ = m.Ready(1, true)
+	if info, _ := r.Info(); info.Status != StatusWaiting {
+		t.Fatal("should wait for all players")
+	}
+	_ = m.Ready(2, true)
+	if info, _ := r.Info(); info.Status != StatusPlaying {
+		t.Fatal("should start after all ready")
+	}
+	waitFor(t, "state broadcast", func() bool {
+		return rec.count(1, StateRouter) > 0 && rec.count(2, StateRouter) > 0
+	})
+	_ = m.Input(1, float64(2))
+	_ = m.Input(2, float64(3))
+	select {
+	case <-r.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("room should finish")
+	}
+	if logic.reason() != CloseReasonFinished || rec.count(1, ClosedRouter) != 1 {
+		t.Fatalf("unexpected close reason %q", logic.reason())
+	}
+	if m.Count() != 0 {
+		t.Fatal("closed room should be removed")
+	}
+	if _, in := m.RoomOf(1); in {
+		t.Fatal("players should be released")
+	}
+	if err = m.Input(1, float64(1)); err != ErrNotInRoom {
+		t.Fatalf("expected ErrNotInRoom, got %v", err)
+	}
+}
+
+func TestRoomReserved(t *testing.T) {
+	logic := &counterLogic{}
+	m, _ := newTestManager("test-reserved", ModeConfig{TickRate: 100}, logic)
+	r, _ := m.Create("r2", "test-reserved", [][]int64{{1}, {2}})
+	if _, err := m.Join(3, "r2"); err != ErrNotAllowed {
+		t.Fatalf("expected ErrNotAllowed, got %v", err)
+	}
+	info, err := m.Join(2, "r2")
+	if err != nil || len(info.Players) != 1 || info.Players[0].Team != 1 {
+		t.Fatalf("unexpected join result %+v %v", info, err)
+	}
+	if _, err = m.Join(2, "r2"); err != nil {
+		t.Fatal("joining again should be treated as reconnect")
+	}
+	if err = m.Leave(2); err != nil {
+		t.Fatal(err)
+	}
+	<-r.Done()
+	if logic.reason() != CloseReasonEmpty {
+		t.Fatalf("unexpected close reason %q", logic.reason())
+	}
+}
+
+func TestRoomOffline(t *testing.T) {
+	logic := &counterLogic{}
+	m, rec := newTestManager("test-offline", ModeConfig{TickRate: 100, OfflineTimeout: 50 * time.Millisecond}, logic)
+	r, _ := m.Create("r3", "test-offline", [][]int64{{1}, {2}})
+	for _, uid := range []int64{1, 2} {
+		_, _ = m.Join(uid, "r3")
+		_ = m.Ready(uid, true)
+	}
+	if _, err := m.Create("r4", "test-offline", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Join(1, "r4"); err != ErrAlreadyInRoom {
+		t.Fatalf("expected ErrAlreadyInRoom, got %v", err)
+	}
+
+	// 掉线后在超时前重连
+	m.Offline(1)
+	if rec.count(2, OnlineRouter) != 1 {
+		t.Fatal("offline should be pushed")
+	}
+	if info, _ := m.Join(1, "r3"); !info.Players[0].Online {
+		t.Fatal("should be online after rejoin")
+	}
+
+	// 超时未重连被移出
+	m.Offline(1)
+	waitFor(t, "offline player removed", func() bool {
+		return rec.count(2, LeftRouter) == 1
+	})
+	if _, in := m.RoomOf(1); in {
+		t.Fatal("removed player should be released")
+	}
+	if info, _ := r.Info(); info.Status != StatusPlaying || len(info.Players) != 1 {
+		t.Fatalf("unexpected room %+v", info)
+	}
+	m.CloseAll()
+	if logic.reason() != CloseReasonShutdown || m.Count() != 0 {
+		t.Fatalf("unexpected close reason %q", logic.reason())
+	}
+}
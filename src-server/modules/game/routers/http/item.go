@@ -0,0 +1,177 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build http
+// +build http
+
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/dao"
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/auth"
+	"github.com/zhouhp1295/g3/crud"
+	"github.com/zhouhp1295/g3/net"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+type _gameItemApi struct {
+	net.BaseApi
+}
+
+var GameItemApi = &_gameItemApi{
+	net.BaseApi{Dao: dao.GameItemDao},
+}
+
+type _gameInventoryApi struct {
+	net.BaseApi
+}
+
+var GameInventoryApi = &_gameInventoryApi{
+	net.BaseApi{Dao: dao.GameInventoryDao},
+}
+
+var GameItemLogApi = &net.BaseApi{Dao: dao.GameItemLogDao}
+
+const (
+	PermGameItemList   = "game:item:list"
+	PermGameItemQuery  = "game:item:query"
+	PermGameItemAdd    = "game:item:add"
+	PermGameItemEdit   = "game:item:edit"
+	PermGameItemRemove = "game:item:remove"
+
+	PermGameInventoryList  = "game:inventory:list"
+	PermGameInventoryQuery = "game:inventory:query"
+	PermGameInventoryEdit  = "game:inventory:edit"
+
+	PermGameItemLogList  = "game:itemLog:list"
+	PermGameItemLogQuery = "game:itemLog:query"
+)
+
+// gameInventoryChangeParams 后台发放或扣除, remark 必填; opId 为空时自动生成, 重复提交同一opId只执行一次
+type gameInventoryChangeParams struct {
+	Uid    int64                `json:"uid"`
+	OpId   string               `json:"opId"`
+	Items  []service.ItemChange `json:"items"`
+	Remark string               `json:"remark"`
+}
+
+func init() {
+	boot.RegisterAfterInstallFunction(func() {
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/item/page", GameItemApi.HandlePage, PermGameItemQuery)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/item/get", GameItemApi.HandleGet, PermGameItemQuery)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodPost, "/admin/game/item/insert", GameItemApi.HandleInsert, PermGameItemAdd)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodPut, "/admin/game/item/update", GameItemApi.HandleUpdate, PermGameItemEdit)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodPut, "/admin/game/item/status", GameItemApi.HandleUpdateStatus, PermGameItemEdit)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodDelete, "/admin/game/item/delete", GameItemApi.HandleDelete, PermGameItemRemove)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/item/listSimple", GameItemApi.HandleListSimple)
+
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/inventory/page", GameInventoryApi.HandlePage, PermGameInventoryQuery)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodPost, "/admin/game/inventory/grant", GameInventoryApi.HandleGrant, PermGameInventoryEdit)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodPost, "/admin/game/inventory/consume", GameInventoryApi.HandleConsume, PermGameInventoryEdit)
+
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/itemLog/page", GameItemLogApi.HandlePage, PermGameItemLogQuery)
+
+		// 游戏接口
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/item/list", onGameItemList)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/item/inventory", onGameItemInventory)
+	})
+}
+
+// HandleListSimple 所有未删除的物品, 供后台下拉选择及展示名称
+func (api *_gameItemApi) HandleListSimple(ctx *gin.Context) {
+	items := make([]model.GameItem, 0)
+	crud.DbSess().
+		Select("id", "code", "name", "max_stack", "status").
+		Where("deleted = ?", crud.FlagNo).
+		Order("sort, id").
+		Find(&items)
+	rows := make([]gin.H, 0, len(items))
+	for _, item := range items {
+		rows = append(rows, gin.H{
+			"id":       item.Id,
+			"code":     item.Code,
+			"name":     item.Name,
+			"maxStack": item.MaxStack,
+			"status":   item.Status,
+		})
+	}
+	net.SuccessList(ctx, rows)
+}
+
+func (api *_gameInventoryApi) HandleGrant(ctx *gin.Context) {
+	api.handleChange(ctx, service.ItemService.Grant)
+}
+
+func (api *_gameInventoryApi) HandleConsume(ctx *gin.Context) {
+	api.handleChange(ctx, service.ItemService.Consume)
+}
+
+func (api *_gameInventoryApi) handleChange(ctx *gin.Context, apply func(service.ItemOperation) (service.ItemOperationResult, error)) {
+	params := new(gameInventoryChangeParams)
+	if err := net.ShouldBind(ctx, params); err != nil {
+		net.FailedMessage(ctx, "参数错误")
+		return
+	}
+	if dao.GameUserDao.CountByPk(params.Uid) == 0 {
+		net.FailedMessage(ctx, service.ErrAccountNotFound.Error())
+		return
+	}
+	params.Remark = strings.TrimSpace(params.Remark)
+	if len(params.Remark) == 0 || utf8.RuneCountInString(params.Remark) > 100 {
+		net.FailedMessage(ctx, "备注不能为空且不超过100字")
+		return
+	}
+	params.OpId = strings.TrimSpace(params.OpId)
+	if len(params.OpId) == 0 {
+		params.OpId = "admin-" + uuid.NewString()
+	}
+	result, err := apply(service.ItemOperation{
+		Uid:      params.Uid,
+		OpId:     params.OpId,
+		Reason:   service.ItemReasonAdmin,
+		Remark:   params.Remark,
+		Operator: ctx.GetInt64(auth.CtxJwtUid),
+		Items:    params.Items,
+	})
+	if err != nil {
+		failedItem(ctx, err)
+		return
+	}
+	net.SuccessData(ctx, result)
+}
+
+func onGameItemList(ctx *gin.Context) {
+	net.SuccessList(ctx, service.ItemService.Items())
+}
+
+func onGameItemInventory(ctx *gin.Context) {
+	net.SuccessList(ctx, service.ItemService.Inventory(ctx.GetInt64(auth.CtxJwtUid)))
+}
+
+func failedItem(ctx *gin.Context, err error) {
+	if err == service.ErrItemSaveFailure {
+		net.FailedServerError(ctx, err.Error(), "")
+		return
+	}
+	net.FailedMessage(ctx, err.Error())
+}
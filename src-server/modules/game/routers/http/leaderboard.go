@@ -0,0 +1,133 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build http
+// +build http
+
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/leaderboard"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/auth"
+	"github.com/zhouhp1295/g3/net"
+	"net/http"
+)
+
+// leaderboardQueryParams 排行榜查询参数, season 为空或current时为当前赛季, previous 为上一赛季, 也可指定赛季id
+type leaderboardQueryParams struct {
+	Board  string `json:"board" form:"board"`
+	Season string `json:"season" form:"season"`
+	Limit  int    `json:"limit" form:"limit"`
+}
+
+type leaderboardSubmitParams struct {
+	Board string   `json:"board" form:"board"`
+	Score *float64 `json:"score" form:"score"`
+}
+
+func init() {
+	boot.RegisterPreFunction(service.LeaderboardService.Init)
+	boot.RegisterAfterInstallFunction(func() {
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/leaderboard/boards", onLeaderboardBoards)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/leaderboard/submit", onLeaderboardSubmit)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/leaderboard/top", onLeaderboardTop)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/leaderboard/rank", onLeaderboardRank)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/leaderboard/around", onLeaderboardAround)
+	})
+}
+
+func onLeaderboardBoards(ctx *gin.Context) {
+	net.SuccessList(ctx, service.LeaderboardService.Boards())
+}
+
+// onLeaderboardSubmit 客户端提交分数, 仅CLIENT_SUBMIT = true的排行榜可用
+func onLeaderboardSubmit(ctx *gin.Context) {
+	if !leaderboardShared(ctx) {
+		return
+	}
+	params := new(leaderboardSubmitParams)
+	if err := net.ShouldBind(ctx, params); err != nil || params.Board == "" || params.Score == nil {
+		net.FailedBadRequest(ctx, "board and score are required", "")
+		return
+	}
+	entry, err := service.LeaderboardService.Submit(params.Board, ctx.GetInt64(auth.CtxJwtUid), *params.Score, true)
+	if err != nil {
+		failedLeaderboard(ctx, err)
+		return
+	}
+	net.SuccessData(ctx, entry)
+}
+
+func onLeaderboardTop(ctx *gin.Context) {
+	if !leaderboardShared(ctx) {
+		return
+	}
+	params := new(leaderboardQueryParams)
+	_ = net.ShouldBind(ctx, params)
+	result, err := service.LeaderboardService.Top(params.Board, params.Season, params.Limit)
+	if err != nil {
+		failedLeaderboard(ctx, err)
+		return
+	}
+	net.SuccessData(ctx, result)
+}
+
+// onLeaderboardRank 当前玩家的排名
+func onLeaderboardRank(ctx *gin.Context) {
+	if !leaderboardShared(ctx) {
+		return
+	}
+	params := new(leaderboardQueryParams)
+	_ = net.ShouldBind(ctx, params)
+	result, err := service.LeaderboardService.RankOf(params.Board, params.Season, ctx.GetInt64(auth.CtxJwtUid))
+	if err != nil {
+		failedLeaderboard(ctx, err)
+		return
+	}
+	net.SuccessData(ctx, result)
+}
+
+// onLeaderboardAround 当前玩家附近的排名
+func onLeaderboardAround(ctx *gin.Context) {
+	if !leaderboardShared(ctx) {
+		return
+	}
+	params := new(leaderboardQueryParams)
+	_ = net.ShouldBind(ctx, params)
+	result, err := service.LeaderboardService.Around(params.Board, params.Season, ctx.GetInt64(auth.CtxJwtUid), params.Limit)
+	if err != nil {
+		failedLeaderboard(ctx, err)
+		return
+	}
+	net.SuccessData(ctx, result)
+}
+
+// leaderboardShared 本地缓存时数据在websocket进程内, http进程读写的是另一份数据, 直接拒绝
+func leaderboardShared(ctx *gin.Context) bool {
+	if service.LeaderboardService.Shared() {
+		return true
+	}
+	net.FailedMessage(ctx, service.ErrLeaderboardNotShared.Error())
+	return false
+}
+
+func failedLeaderboard(ctx *gin.Context, err error) {
+	switch err {
+	case leaderboard.ErrUnknownBoard:
+		net.FailedNotFound(ctx)
+	case leaderboard.ErrSubmitForbidden:
+		net.FailedMessage(ctx, err.Error())
+	case leaderboard.ErrInvalidSeason, leaderboard.ErrScoreNotFinite, leaderboard.ErrNegativeSumScore:
+		net.FailedBadRequest(ctx, err.Error(), "")
+	default:
+		net.FailedServerError(ctx, "排行榜暂不可用", "")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build http
+// +build http
+
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/dao"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/auth"
+	"github.com/zhouhp1295/g3/net"
+	"net/http"
+	"strings"
+)
+
+type _gamePlayerDataApi struct {
+	net.BaseApi
+}
+
+var GamePlayerDataApi = &_gamePlayerDataApi{
+	net.BaseApi{Dao: dao.GamePlayerDataDao},
+}
+
+const (
+	PermGamePlayerDataList   = "game:data:list"
+	PermGamePlayerDataQuery  = "game:data:query"
+	PermGamePlayerDataEdit   = "game:data:edit"
+	PermGamePlayerDataRemove = "game:data:remove"
+)
+
+// gamePlayerDataGetParams keys 为逗号分隔的键, 为空时返回命名空间下的全部
+type gamePlayerDataGetParams struct {
+	Namespace string `json:"namespace" form:"namespace"`
+	Keys      string `json:"keys" form:"keys"`
+}
+
+type gamePlayerDataSetParams struct {
+	Namespace string `json:"namespace"`
+	service.PlayerDataWrite
+}
+
+type gamePlayerDataBatchParams struct {
+	Namespace string                    `json:"namespace"`
+	Items     []service.PlayerDataWrite `json:"items"`
+}
+
+type gamePlayerDataUpdateParams struct {
+	Id    int64  `json:"id"`
+	Value string `json:"value"`
+}
+
+func init() {
+	boot.RegisterPreFunction(service.PlayerDataService.Init)
+	boot.RegisterAfterInstallFunction(func() {
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/data/page", GamePlayerDataApi.HandlePage, PermGamePlayerDataQuery)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/data/get", GamePlayerDataApi.HandleGet, PermGamePlayerDataQuery)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodPut, "/admin/game/data/update", GamePlayerDataApi.HandleUpdate, PermGamePlayerDataEdit)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodDelete, "/admin/game/data/delete", GamePlayerDataApi.HandleDelete, PermGamePlayerDataRemove)
+
+		// 游戏接口, 只能读写自己的存档
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/data/get", GamePlayerDataApi.HandleMyGet)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/data/set", GamePlayerDataApi.HandleMySet)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/data/batch", GamePlayerDataApi.HandleMyBatch)
+	})
+}
+
+// HandleUpdate 后台修改存档的值, 版本号加1
+func (api *_gamePlayerDataApi) HandleUpdate(ctx *gin.Context) {
+	params := new(gamePlayerDataUpdateParams)
+	if err := net.ShouldBind(ctx, params); err != nil {
+		net.FailedMessage(ctx, "参数错误")
+		return
+	}
+	m, err := service.PlayerDataService.AdminUpdate(params.Id, params.Value, ctx.GetInt64(auth.CtxJwtUid))
+	if err != nil {
+		failedPlayerData(ctx, err, nil)
+		return
+	}
+	net.SuccessData(ctx, m)
+}
+
+// HandleDelete 后台删除存档条目
+func (api *_gamePlayerDataApi) HandleDelete(ctx *gin.Context) {
+	params := net.IdParams{}
+	_ = net.ShouldBind(ctx, &params)
+	if err := service.PlayerDataService.AdminDelete(params.Id); err != nil {
+		failedPlayerData(ctx, err, nil)
+		return
+	}
+	net.SuccessDefault(ctx)
+}
+
+func (api *_gamePlayerDataApi) HandleMyGet(ctx *gin.Context) {
+	params := new(gamePlayerDataGetParams)
+	_ = net.ShouldBind(ctx, params)
+	keys := make([]string, 0)
+	for _, key := range strings.Split(params.Keys, ",") {
+		if key = strings.TrimSpace(key); len(key) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	result, err := service.PlayerDataService.Get(ctx.GetInt64(auth.CtxJwtUid), params.Namespace, keys)
+	if err != nil {
+		failedPlayerData(ctx, err, nil)
+		return
+	}
+	net.SuccessList(ctx, result)
+}
+
+// HandleMySet 写入一个键, version 见 service.PlayerDataWrite
+func (api *_gamePlayerDataApi) HandleMySet(ctx *gin.Context) {
+	params := new(gamePlayerDataSetParams)
+	if err := net.ShouldBind(ctx, params); err != nil {
+		net.FailedMessage(ctx, "参数错误")
+		return
+	}
+	result, err := service.PlayerDataService.Set(ctx.GetInt64(auth.CtxJwtUid), params.Namespace, params.PlayerDataWrite)
+	if err != nil {
+		failedPlayerData(ctx, err, result)
+		return
+	}
+	net.SuccessData(ctx, result)
+}
+
+// HandleMyBatch 批量写入, 全部成功或全部失败
+func (api *_gamePlayerDataApi) HandleMyBatch(ctx *gin.Context) {
+	params := new(gamePlayerDataBatchParams)
+	if err := net.ShouldBind(ctx, params); err != nil {
+		net.FailedMessage(ctx, "参数错误")
+		return
+	}
+	result, err := service.PlayerDataService.Write(ctx.GetInt64(auth.CtxJwtUid), params.Namespace, params.Items)
+	if err != nil {
+		failedPlayerData(ctx, err, result)
+		return
+	}
+	net.SuccessList(ctx, result)
+}
+
+// failedPlayerData 版本冲突时在data中返回冲突键的当前值
+func failedPlayerData(ctx *gin.Context, err error, current interface{}) {
+	switch err {
+	case service.ErrDataNotFound:
+		net.FailedNotFound(ctx)
+	case service.ErrDataVersionConflict:
+		net.Result(ctx, http.StatusConflict, err.Error(), current)
+	case service.ErrDataSaveFailure:
+		net.FailedServerError(ctx, err.Error(), "")
+	default:
+		net.FailedMessage(ctx, err.Error())
+	}
+}
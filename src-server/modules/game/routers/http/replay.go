@@ -0,0 +1,141 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build http
+// +build http
+
+package http
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/dao"
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/auth"
+	"github.com/zhouhp1295/g3/crud"
+	"github.com/zhouhp1295/g3/net"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+type _gameReplayApi struct {
+	net.BaseApi
+}
+
+var GameReplayApi = &_gameReplayApi{
+	net.BaseApi{Dao: dao.GameReplayDao},
+}
+
+const (
+	PermGameReplayQuery  = "game:replay:query"
+	PermGameReplayRemove = "game:replay:remove"
+)
+
+// gameReplayQueryParams 回放查询参数, uid 为参与的玩家
+type gameReplayQueryParams struct {
+	Uid int64 `json:"uid" form:"uid"`
+}
+
+func init() {
+	boot.RegisterAfterInstallFunction(func() {
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/replay/page", GameReplayApi.HandlePage, PermGameReplayQuery)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/replay/get", GameReplayApi.HandleGet, PermGameReplayQuery)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/replay/download", GameReplayApi.HandleDownload, PermGameReplayQuery)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodDelete, "/admin/game/replay/delete", GameReplayApi.HandleDelete, PermGameReplayRemove)
+
+		// 游戏接口, 玩家只能查看自己参与的对局
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/replay/page", GameReplayApi.HandleMyPage)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/replay/get", GameReplayApi.HandleMyGet)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/replay/download", GameReplayApi.HandleMyDownload)
+	})
+}
+
+// HandlePage 分页查询回放, 支持按参与玩家uid查询
+func (api *_gameReplayApi) HandlePage(ctx *gin.Context) {
+	params := new(gameReplayQueryParams)
+	_ = net.ShouldBind(ctx, params)
+	api.page(ctx, params.Uid)
+}
+
+// HandleMyPage 当前玩家参与的对局
+func (api *_gameReplayApi) HandleMyPage(ctx *gin.Context) {
+	api.page(ctx, ctx.GetInt64(auth.CtxJwtUid))
+}
+
+func (api *_gameReplayApi) page(ctx *gin.Context, uid int64) {
+	modelParams := new(model.GameReplay)
+	baseParams := new(crud.BaseQueryParams)
+	_ = net.ShouldBind(ctx, modelParams)
+	_ = net.ShouldBind(ctx, baseParams)
+	if uid > 0 {
+		modelParams.Players = service.ReplayService.PlayersColumn(uid)
+	}
+	rows, pageData := dao.GameReplayDao.FindPage(modelParams, baseParams)
+	net.SuccessPage(ctx, rows, pageData)
+}
+
+// HandleMyGet 查询当前玩家参与的对局
+func (api *_gameReplayApi) HandleMyGet(ctx *gin.Context) {
+	replay, ok := api.findMine(ctx)
+	if !ok {
+		return
+	}
+	net.SuccessData(ctx, replay)
+}
+
+// HandleDownload 下载回放
+func (api *_gameReplayApi) HandleDownload(ctx *gin.Context) {
+	params := net.IdParams{}
+	_ = net.ShouldBind(ctx, &params)
+	replay := service.ReplayService.Find(params.Id)
+	if replay == nil {
+		net.FailedNotFound(ctx)
+		return
+	}
+	api.download(ctx, replay)
+}
+
+// HandleMyDownload 下载当前玩家参与的对局的回放
+func (api *_gameReplayApi) HandleMyDownload(ctx *gin.Context) {
+	replay, ok := api.findMine(ctx)
+	if !ok {
+		return
+	}
+	api.download(ctx, replay)
+}
+
+func (api *_gameReplayApi) findMine(ctx *gin.Context) (*model.GameReplay, bool) {
+	params := net.IdParams{}
+	_ = net.ShouldBind(ctx, &params)
+	replay := service.ReplayService.Find(params.Id)
+	if replay == nil || !service.ReplayService.HasPlayer(replay, ctx.GetInt64(auth.CtxJwtUid)) {
+		net.FailedNotFound(ctx)
+		return nil, false
+	}
+	return replay, true
+}
+
+// download 返回gzip压缩的JSON Lines, 首行为回放信息, 之后每行一条记录
+func (api *_gameReplayApi) download(ctx *gin.Context, replay *model.GameReplay) {
+	buf := bytes.NewBuffer(make([]byte, 0, replay.Size))
+	if err := service.ReplayService.Download(replay, buf); err != nil {
+		g3.ZL().Error("read replay failed",
+			zap.Int64("id", replay.Id),
+			zap.String("path", replay.Path),
+			zap.Error(err))
+		net.FailedServerError(ctx, "读取回放失败", "")
+		return
+	}
+	ctx.Header("Content-Disposition", "attachment; filename="+strconv.Quote(service.ReplayService.Filename(replay)))
+	ctx.Data(http.StatusOK, "application/gzip", buf.Bytes())
+}
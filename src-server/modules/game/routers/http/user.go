@@ -6,18 +6,19 @@
 package http
 
 import (
-	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/zhouhp1295/g3"
 	"github.com/zhouhp1295/g3-game/boot"
 	"github.com/zhouhp1295/g3-game/modules/game/dao"
 	"github.com/zhouhp1295/g3-game/modules/game/helpers"
 	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/auth"
 	"github.com/zhouhp1295/g3/crud"
 	"github.com/zhouhp1295/g3/net"
 	"go.uber.org/zap"
 	"net/http"
+	"time"
 )
 
 type _gameUserApi struct {
@@ -37,6 +38,7 @@ const (
 )
 
 func init() {
+	boot.RegisterPreFunction(service.VerifyCodeService.Init)
 	boot.RegisterAfterInstallFunction(func() {
 		g3.GetGin().Group("/api").
 			Bind(http.MethodGet, "/admin/game/user/page", GameUserApi.HandlePage, PermGameUserQuery)
@@ -50,6 +52,8 @@ func init() {
 			Bind(http.MethodPut, "/admin/game/user/status", GameUserApi.HandleUpdateStatus, PermGameUserEdit)
 		g3.GetGin().Group("/api").
 			Bind(http.MethodDelete, "/admin/game/user/delete", GameUserApi.HandleDelete, PermGameUserRemove)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/user/online", GameUserApi.HandleOnline, PermGameUserQuery)
 
 		// 游戏接口
 		g3.GetGin().Group("/api/game").MakeOpen("/user/fast")
@@ -57,28 +61,273 @@ func init() {
 			Bind(http.MethodGet, "/user/fast", onGameUserFastLogin)
 		g3.GetGin().Group("/api/game").
 			Bind(http.MethodPost, "/user/fast", onGameUserFastLogin)
+		g3.GetGin().Group("/api/game").MakeOpen("/user/login")
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/user/login", onGameUserLogin)
+		g3.GetGin().Group("/api/game").MakeOpen("/user/refresh", "/user/logout")
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/user/refresh", onGameUserRefresh)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/user/logout", onGameUserLogout)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/user/bind/account", onGameUserBindAccount)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/user/bind/email/code", onGameUserBindEmailCode)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/user/bind/email", onGameUserBindEmail)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/user/bind/phone/code", onGameUserBindPhoneCode)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodPost, "/user/bind/phone", onGameUserBindPhone)
 	})
 }
 
+// gameUserFastLoginParams 游客登录参数, deviceId 为空时每次创建新的游客
+type gameUserFastLoginParams struct {
+	DeviceId string `form:"deviceId" json:"deviceId"`
+}
+
+// gameUserLoginParams 账号登录参数, account 为用户名、已绑定的邮箱或手机号
+type gameUserLoginParams struct {
+	Account  string `form:"account" json:"account"`
+	Password string `form:"password" json:"password"`
+}
+
+type gameUserRefreshParams struct {
+	RefreshToken string `form:"refreshToken" json:"refreshToken"`
+}
+
+type gameUserBindParams struct {
+	Username string `form:"username" json:"username"`
+	Password string `form:"password" json:"password"`
+	Email    string `form:"email" json:"email"`
+	Phone    string `form:"phone" json:"phone"`
+	// Code 绑定邮箱或手机号时的验证码
+	Code string `form:"code" json:"code"`
+}
+
+// onGameUserFastLogin 游客登录, 传入deviceId时复用该设备的游客账号
 func onGameUserFastLogin(ctx *gin.Context) {
-	user := new(model.GameUser)
-	user.Username = fmt.Sprintf("%v", uuid.New())
-	user.Nickname = "游客"
-	crud.DbSess().Create(user)
+	params := new(gameUserFastLoginParams)
+	_ = net.ShouldBind(ctx, params)
+	var user *model.GameUser
+	if len(params.DeviceId) > 0 {
+		var err error
+		if user, err = service.AccountService.GuestLogin(params.DeviceId); err != nil {
+			failedGameAccount(ctx, err)
+			return
+		}
+	} else {
+		user = service.AccountService.NewGuest()
+		crud.DbSess().Create(user)
+	}
+	responseGameUserTokens(ctx, user)
+}
+
+// onGameUserLogin 使用用户名、已绑定的邮箱或手机号及密码登录
+func onGameUserLogin(ctx *gin.Context) {
+	params := new(gameUserLoginParams)
+	if err := net.ShouldBind(ctx, params); err != nil {
+		net.FailedMessage(ctx, "参数错误")
+		return
+	}
+	user, err := service.AccountService.Login(params.Account, params.Password)
+	if err != nil {
+		failedGameAccount(ctx, err)
+		return
+	}
+	responseGameUserTokens(ctx, user)
+}
+
+// onGameUserRefresh 使用refresh token换取新的token, 旧的refresh token随即失效
+func onGameUserRefresh(ctx *gin.Context) {
+	params := new(gameUserRefreshParams)
+	if err := net.ShouldBind(ctx, params); err != nil || len(params.RefreshToken) == 0 {
+		net.FailedBadRequest(ctx, "refreshToken is required", "")
+		return
+	}
+	user, refreshToken, err := service.RefreshTokenService.Rotate(params.RefreshToken)
+	if err != nil {
+		failedGameAccount(ctx, err)
+		return
+	}
+	responseGameUserTokenPair(ctx, user, refreshToken)
+}
+
+// onGameUserLogout 注销, 同一次登录签发的refresh token全部失效
+func onGameUserLogout(ctx *gin.Context) {
+	params := new(gameUserRefreshParams)
+	_ = net.ShouldBind(ctx, params)
+	if len(params.RefreshToken) > 0 {
+		_ = service.RefreshTokenService.Revoke(params.RefreshToken)
+	}
+	net.SuccessDefault(ctx)
+}
+
+// onGameUserBindAccount 游客绑定用户名及密码, 升级为正式账号; 之前签发的refresh token全部失效, 需使用账号密码重新登录
+func onGameUserBindAccount(ctx *gin.Context) {
+	params := new(gameUserBindParams)
+	_ = net.ShouldBind(ctx, params)
+	user, err := service.AccountService.BindAccount(ctx.GetInt64(auth.CtxJwtUid), params.Username, params.Password)
+	if err != nil {
+		failedGameAccount(ctx, err)
+		return
+	}
+	net.SuccessData(ctx, user)
+}
+
+// onGameUserBindEmailCode 向待绑定的邮箱发送验证码
+func onGameUserBindEmailCode(ctx *gin.Context) {
+	params := new(gameUserBindParams)
+	_ = net.ShouldBind(ctx, params)
+	if err := service.AccountService.SendBindCode(ctx.GetInt64(auth.CtxJwtUid), service.VerifyChannelEmail, params.Email); err != nil {
+		failedGameAccount(ctx, err)
+		return
+	}
+	net.SuccessDefault(ctx)
+}
+
+// onGameUserBindEmail 使用验证码绑定邮箱, 之后可使用邮箱及密码登录
+func onGameUserBindEmail(ctx *gin.Context) {
+	params := new(gameUserBindParams)
+	_ = net.ShouldBind(ctx, params)
+	user, err := service.AccountService.BindEmail(ctx.GetInt64(auth.CtxJwtUid), params.Email, params.Code)
+	if err != nil {
+		failedGameAccount(ctx, err)
+		return
+	}
+	net.SuccessData(ctx, user)
+}
+
+// onGameUserBindPhoneCode 向待绑定的手机号发送验证码
+func onGameUserBindPhoneCode(ctx *gin.Context) {
+	params := new(gameUserBindParams)
+	_ = net.ShouldBind(ctx, params)
+	if err := service.AccountService.SendBindCode(ctx.GetInt64(auth.CtxJwtUid), service.VerifyChannelPhone, params.Phone); err != nil {
+		failedGameAccount(ctx, err)
+		return
+	}
+	net.SuccessDefault(ctx)
+}
+
+// onGameUserBindPhone 使用验证码绑定手机号, 之后可使用手机号及密码登录
+func onGameUserBindPhone(ctx *gin.Context) {
+	params := new(gameUserBindParams)
+	_ = net.ShouldBind(ctx, params)
+	user, err := service.AccountService.BindPhone(ctx.GetInt64(auth.CtxJwtUid), params.Phone, params.Code)
+	if err != nil {
+		failedGameAccount(ctx, err)
+		return
+	}
+	net.SuccessData(ctx, user)
+}
+
+func failedGameAccount(ctx *gin.Context, err error) {
+	switch err {
+	case service.ErrAccountNotFound:
+		net.FailedNotFound(ctx)
+	case service.ErrRefreshTokenInvalid, service.ErrRefreshTokenExpired,
+		service.ErrRefreshTokenRevoked, service.ErrRefreshTokenReused:
+		// 与鉴权失败一致, 客户端需重新登录
+		net.Result(ctx, http.StatusUnauthorized, err.Error(), "")
+	case service.ErrVerifyCodeTooFrequent:
+		net.Result(ctx, http.StatusTooManyRequests, err.Error(), "")
+	case service.ErrAccountSaveFailure, service.ErrVerifyCodeSendFailure:
+		net.FailedServerError(ctx, err.Error(), "")
+	default:
+		net.FailedMessage(ctx, err.Error())
+	}
+}
+
+// responseGameUserTokens 登录成功, 签发refresh token并返回token
+func responseGameUserTokens(ctx *gin.Context, user *model.GameUser) {
+	refreshToken, err := service.RefreshTokenService.Issue(user.Id)
+	if err != nil {
+		g3.ZL().Error("create refresh token failed", zap.Int64("uid", user.Id), zap.Error(err))
+		net.FailedServerError(ctx, "create refresh token failed", "")
+		return
+	}
+	responseGameUserTokenPair(ctx, user, refreshToken)
+}
+
+// responseGameUserTokenPair 返回短期的http及websocket token, 以及refresh token
+func responseGameUserTokenPair(ctx *gin.Context, user *model.GameUser, refreshToken string) {
 	httpToken, err := g3.GetGin().Group("/api/game").NewJwtToken(user.Id, "")
 	if err != nil {
 		g3.ZL().Error("create game token failed. please check")
 		net.FailedServerError(ctx, err.Error(), "")
 		return
 	}
-	wsToken, err := helpers.NewWsJwtToken([]byte(boot.JwtCfg.WsSecret), user.Id)
+	wsToken, err := helpers.NewWsJwtToken([]byte(boot.JwtCfg.WsSecret), user.Id, boot.JwtCfg.GameAccessTTL())
 	if err != nil {
 		g3.ZL().Error("create websocket token failed", zap.Error(err))
 		net.FailedMessage(ctx, "create jwt wsToken failed")
 		return
 	}
 	net.SuccessData(ctx, gin.H{
+		"uid":            user.Id,
+		"isGuest":        user.IsGuest == crud.FlagYes,
 		"httpToken":      httpToken,
 		"websocketToken": wsToken,
+		"expiresIn":      int64(boot.JwtCfg.GameAccessTTL() / time.Second),
+		"refreshToken":   refreshToken,
 	})
 }
+
+// HandleUpdateStatus 修改状态, 禁用时吊销该玩家的全部refresh token
+func (api *_gameUserApi) HandleUpdateStatus(ctx *gin.Context) {
+	params := net.UpdateStatusParams{}
+	if err := net.ShouldBind(ctx, &params); err != nil || len(params.Status) == 0 {
+		net.FailedMessage(ctx, "参数错误")
+		return
+	}
+	if api.Dao.CountByPk(params.Id) == 0 {
+		net.FailedNotFound(ctx)
+		return
+	}
+	if !api.Dao.UpdateStatus(params.Id, params.Status, ctx.GetInt64(auth.CtxJwtUid)) {
+		g3.ZL().Error("update game user status failed", zap.Int64("id", params.Id))
+		net.FailedMessage(ctx, "操作失败, 请稍后重试")
+		return
+	}
+	if params.Status != crud.FlagYes {
+		service.RefreshTokenService.RevokeUser(params.Id)
+	}
+	net.SuccessDefault(ctx)
+}
+
+// GameUserOnline 在线玩家
+type GameUserOnline struct {
+	service.Presence
+	Username string `json:"username"`
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar"`
+}
+
+// HandleOnline 分页查询在线玩家
+func (api *_gameUserApi) HandleOnline(ctx *gin.Context) {
+	if !service.PresenceService.Shared() {
+		net.FailedMessage(ctx, service.ErrPresenceNotShared.Error())
+		return
+	}
+	params := new(crud.BaseQueryParams)
+	_ = net.ShouldBind(ctx, params)
+	if params.PageNum < 1 {
+		params.PageNum = 1
+	}
+	if params.PageSize < 1 {
+		params.PageSize = 10
+	}
+	presences, total := service.PresenceService.OnlinePage(params.PageNum, params.PageSize)
+	rows := make([]GameUserOnline, 0, len(presences))
+	for _, p := range presences {
+		row := GameUserOnline{Presence: p}
+		if user, ok := dao.GameUserDao.FindByPk(p.Uid).(*model.GameUser); ok {
+			row.Username = user.Username
+			row.Nickname = user.Nickname
+			row.Avatar = user.Avatar
+		}
+		rows = append(rows, row)
+	}
+	net.SuccessPage(ctx, rows, crud.PageResult(params.PageNum, params.PageSize, total))
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build http
+// +build http
+
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/dao"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/auth"
+	"github.com/zhouhp1295/g3/net"
+	"net/http"
+)
+
+type _gameWalletApi struct {
+	net.BaseApi
+}
+
+var GameWalletApi = &_gameWalletApi{
+	net.BaseApi{Dao: dao.GameWalletDao},
+}
+
+var GameLedgerApi = &net.BaseApi{Dao: dao.GameLedgerDao}
+
+const (
+	PermGameWalletList  = "game:wallet:list"
+	PermGameWalletQuery = "game:wallet:query"
+	PermGameWalletEdit  = "game:wallet:edit"
+
+	PermGameLedgerList  = "game:ledger:list"
+	PermGameLedgerQuery = "game:ledger:query"
+)
+
+// gameWalletAdjustParams 后台调整余额, amount 为负时扣除, remark 必填
+type gameWalletAdjustParams struct {
+	Uid      int64  `json:"uid"`
+	Currency string `json:"currency"`
+	Amount   int64  `json:"amount"`
+	Remark   string `json:"remark"`
+}
+
+type gameLedgerParams struct {
+	Currency string `json:"currency" form:"currency"`
+	Limit    int    `json:"limit" form:"limit"`
+}
+
+func init() {
+	boot.RegisterPreFunction(service.WalletService.Init)
+	boot.RegisterAfterInstallFunction(func() {
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/wallet/page", GameWalletApi.HandlePage, PermGameWalletQuery)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/wallet/currencies", GameWalletApi.HandleCurrencies)
+		g3.GetGin().Group("/api").
+			Bind(http.MethodPost, "/admin/game/wallet/adjust", GameWalletApi.HandleAdjust, PermGameWalletEdit)
+
+		g3.GetGin().Group("/api").
+			Bind(http.MethodGet, "/admin/game/ledger/page", GameLedgerApi.HandlePage, PermGameLedgerQuery)
+
+		// 游戏接口
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/wallet/balances", onGameWalletBalances)
+		g3.GetGin().Group("/api/game").
+			Bind(http.MethodGet, "/wallet/ledger", onGameWalletLedger)
+	})
+}
+
+func (api *_gameWalletApi) HandleCurrencies(ctx *gin.Context) {
+	net.SuccessList(ctx, service.WalletService.Currencies())
+}
+
+func (api *_gameWalletApi) HandleAdjust(ctx *gin.Context) {
+	params := new(gameWalletAdjustParams)
+	if err := net.ShouldBind(ctx, params); err != nil {
+		net.FailedMessage(ctx, "参数错误")
+		return
+	}
+	if dao.GameUserDao.CountByPk(params.Uid) == 0 {
+		net.FailedMessage(ctx, service.ErrAccountNotFound.Error())
+		return
+	}
+	if params.Amount == 0 {
+		net.FailedMessage(ctx, service.ErrInvalidAmount.Error())
+		return
+	}
+	result, err := service.WalletService.AdminAdjust(params.Uid, params.Currency, params.Amount, params.Remark, ctx.GetInt64(auth.CtxJwtUid))
+	if err != nil {
+		failedWallet(ctx, err)
+		return
+	}
+	net.SuccessData(ctx, result)
+}
+
+func onGameWalletBalances(ctx *gin.Context) {
+	net.SuccessList(ctx, service.WalletService.Balances(ctx.GetInt64(auth.CtxJwtUid)))
+}
+
+// onGameWalletLedger 自己最近的分录, 参数 currency 可选, limit 默认及最大100
+func onGameWalletLedger(ctx *gin.Context) {
+	params := new(gameLedgerParams)
+	_ = net.ShouldBind(ctx, params)
+	net.SuccessList(ctx, service.WalletService.Ledger(ctx.GetInt64(auth.CtxJwtUid), params.Currency, params.Limit))
+}
+
+func failedWallet(ctx *gin.Context, err error) {
+	if err == service.ErrWalletSaveFailure {
+		net.FailedServerError(ctx, err.Error(), "")
+		return
+	}
+	net.FailedMessage(ctx, err.Error())
+}
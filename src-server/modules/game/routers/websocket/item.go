@@ -0,0 +1,33 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build websocket
+// +build websocket
+
+package websocket
+
+import (
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/net"
+)
+
+const (
+	itemListRouter      = "item/list"
+	itemInventoryRouter = "item/inventory"
+)
+
+// 发放及消耗由游戏逻辑调用 service.ItemService, 不直接开放给客户端
+func init() {
+	boot.RegisterWsRouterHandler(itemListRouter, onItemList)
+	boot.RegisterWsRouterHandler(itemInventoryRouter, onItemInventory)
+}
+
+// onItemList 所有启用的物品定义
+func onItemList(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	msg.Ok(conn, service.ItemService.Items())
+}
+
+// onItemInventory 自己的背包
+func onItemInventory(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	msg.Ok(conn, service.ItemService.Inventory(conn.Uid))
+}
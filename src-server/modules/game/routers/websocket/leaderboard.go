@@ -0,0 +1,103 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build websocket
+// +build websocket
+
+package websocket
+
+import (
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/leaderboard"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/net"
+)
+
+const (
+	leaderboardSubmitRouter = "leaderboard/submit"
+	leaderboardTopRouter    = "leaderboard/top"
+	leaderboardRankRouter   = "leaderboard/rank"
+	leaderboardAroundRouter = "leaderboard/around"
+)
+
+func init() {
+	boot.RegisterWsRouterHandler(leaderboardSubmitRouter, onLeaderboardSubmit)
+	boot.RegisterWsRouterHandler(leaderboardTopRouter, onLeaderboardTop)
+	boot.RegisterWsRouterHandler(leaderboardRankRouter, onLeaderboardRank)
+	boot.RegisterWsRouterHandler(leaderboardAroundRouter, onLeaderboardAround)
+	boot.RegisterPreFunction(service.LeaderboardService.Init)
+}
+
+// leaderboardParams 参数 board 排行榜, season 可选的赛季, limit 可选的条数
+func leaderboardParams(msg boot.WsRequestMsg) (board, season string, limit int) {
+	board, _ = msg.Params["board"].(string)
+	season, _ = msg.Params["season"].(string)
+	if _, exist := msg.Params["limit"]; exist {
+		n, _ := msg.GetInt64("limit")
+		limit = int(n)
+	}
+	return
+}
+
+// onLeaderboardSubmit 客户端提交分数, 参数 board, score; 仅CLIENT_SUBMIT = true的排行榜可用
+func onLeaderboardSubmit(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	board, err := msg.GetString("board")
+	if err != nil {
+		msg.Failed(conn, net.WsErrorBadRequest, "board is required")
+		return
+	}
+	score, err := msg.GetFloat64("score")
+	if err != nil {
+		msg.Failed(conn, net.WsErrorBadRequest, "score is required")
+		return
+	}
+	entry, err := service.LeaderboardService.Submit(board, conn.Uid, score, true)
+	if err != nil {
+		failedLeaderboard(conn, msg, err)
+		return
+	}
+	msg.Ok(conn, entry)
+}
+
+func onLeaderboardTop(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	board, season, limit := leaderboardParams(msg)
+	result, err := service.LeaderboardService.Top(board, season, limit)
+	if err != nil {
+		failedLeaderboard(conn, msg, err)
+		return
+	}
+	msg.Ok(conn, result)
+}
+
+func onLeaderboardRank(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	board, season, _ := leaderboardParams(msg)
+	result, err := service.LeaderboardService.RankOf(board, season, conn.Uid)
+	if err != nil {
+		failedLeaderboard(conn, msg, err)
+		return
+	}
+	msg.Ok(conn, result)
+}
+
+func onLeaderboardAround(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	board, season, limit := leaderboardParams(msg)
+	result, err := service.LeaderboardService.Around(board, season, conn.Uid, limit)
+	if err != nil {
+		failedLeaderboard(conn, msg, err)
+		return
+	}
+	msg.Ok(conn, result)
+}
+
+// failedLeaderboard 排行榜错误对应的错误码
+func failedLeaderboard(conn *net.WsConn, msg boot.WsRequestMsg, err error) {
+	switch err {
+	case leaderboard.ErrUnknownBoard:
+		msg.Failed(conn, net.WsErrorNotFound, err.Error())
+	case leaderboard.ErrSubmitForbidden:
+		msg.Failed(conn, net.WsErrorForbidden, err.Error())
+	case leaderboard.ErrInvalidSeason, leaderboard.ErrScoreNotFinite, leaderboard.ErrNegativeSumScore:
+		msg.Failed(conn, net.WsErrorBadRequest, err.Error())
+	default:
+		msg.Failed(conn, net.WsErrorInternal, "leaderboard is unavailable")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build websocket
+// +build websocket
+
+package websocket
+
+import (
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/match"
+	"github.com/zhouhp1295/g3-game/modules/game/room"
+	"github.com/zhouhp1295/g3/net"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+const (
+	matchEnqueueRouter = "match/enqueue"
+	matchCancelRouter  = "match/cancel"
+
+	matchModeSectionPrefix = "match.mode."
+)
+
+type matchModeConfig struct {
+	TeamSize     int `ini:"TEAM_SIZE"`
+	Teams        int `ini:"TEAMS"`
+	RatingWindow int `ini:"RATING_WINDOW"`
+	WindowStep   int `ini:"WINDOW_STEP"`
+	WidenEvery   int `ini:"WIDEN_EVERY"`
+	MaxWindow    int `ini:"MAX_WINDOW"`
+	Timeout      int `ini:"TIMEOUT"`
+}
+
+// Matcher 当前节点的匹配器
+var Matcher *match.Matcher
+
+func init() {
+	boot.RegisterWsRouterHandler(matchEnqueueRouter, onMatchEnqueue)
+	boot.RegisterWsRouterHandler(matchCancelRouter, onMatchCancel)
+	boot.RegisterWsClosedHandler(onMatchClosed)
+	boot.RegisterPreFunction(func() {
+		Matcher = newMatcher()
+		Matcher.Start()
+	})
+	boot.RegisterShutdownFunction(func() {
+		if Matcher != nil {
+			Matcher.Stop()
+		}
+	})
+}
+
+// newMatcher 读取websocket.ini中的[match]及[match.mode.*]
+func newMatcher() *match.Matcher {
+	interval := time.Second
+	if seconds := boot.WebsocketFile.Section("match").Key("INTERVAL").MustInt(1); seconds > 0 {
+		interval = time.Duration(seconds) * time.Second
+	}
+	modes := make(map[string]match.ModeConfig)
+	for _, section := range boot.WebsocketFile.Sections() {
+		if !strings.HasPrefix(section.Name(), matchModeSectionPrefix) {
+			continue
+		}
+		cfg := matchModeConfig{}
+		if err := section.MapTo(&cfg); err != nil {
+			panic(err)
+		}
+		modes[strings.TrimPrefix(section.Name(), matchModeSectionPrefix)] = match.ModeConfig{
+			TeamSize:     cfg.TeamSize,
+			Teams:        cfg.Teams,
+			RatingWindow: cfg.RatingWindow,
+			WindowStep:   cfg.WindowStep,
+			WidenEvery:   time.Duration(cfg.WidenEvery) * time.Second,
+			MaxWindow:    cfg.MaxWindow,
+			Timeout:      time.Duration(cfg.Timeout) * time.Second,
+		}
+	}
+	m := match.NewMatcher(interval, modes, func(uid int64, router string, data interface{}) {
+		boot.Worker().SendToUser(uid, router, data)
+	})
+	m.OnMatched(onMatchedCreateRoom)
+	g3.ZL().Info("matcher started", zap.Strings("modes", m.Modes()))
+	return m
+}
+
+// onMatchEnqueue 加入匹配, 参数 mode 匹配模式, rating 可选的分数
+func onMatchEnqueue(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	mode, err := msg.GetString("mode")
+	if err != nil {
+		msg.Failed(conn, net.WsErrorBadRequest, "mode is required")
+		return
+	}
+	if _, in := Rooms.RoomOf(conn.Uid); in {
+		msg.Failed(conn, net.WsErrorConflict, room.ErrAlreadyInRoom.Error())
+		return
+	}
+	rating := int64(match.DefaultRating)
+	if _, exist := msg.Params["rating"]; exist {
+		if rating, err = msg.GetInt64("rating"); err != nil {
+			msg.Failed(conn, net.WsErrorBadRequest, "rating is incorrect")
+			return
+		}
+	}
+	if _, err = Matcher.Enqueue(conn.Uid, mode, int(rating)); err != nil {
+		switch err {
+		case match.ErrAlreadyQueued:
+			msg.Failed(conn, net.WsErrorConflict, err.Error())
+		default:
+			msg.Failed(conn, net.WsErrorBadRequest, err.Error())
+		}
+		return
+	}
+	msg.Ok(conn, map[string]interface{}{"mode": mode, "queued": Matcher.QueueSize(mode)})
+}
+
+func onMatchCancel(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	msg.Ok(conn, map[string]interface{}{"cancelled": Matcher.Cancel(conn.Uid)})
+}
+
+// onMatchClosed 玩家的连接全部断开后退出匹配
+func onMatchClosed(worker *net.WsWorker, conn *net.WsConn) {
+	if conn.Uid <= 0 || Matcher == nil || worker.IsUserOnline(conn.Uid) {
+		return
+	}
+	if Matcher.Cancel(conn.Uid) {
+		g3.ZL().Info("match cancelled on disconnect", zap.Int64("uid", conn.Uid))
+	}
+}
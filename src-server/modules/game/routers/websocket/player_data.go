@@ -0,0 +1,99 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build websocket
+// +build websocket
+
+package websocket
+
+import (
+	"encoding/json"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/net"
+)
+
+const (
+	dataGetRouter   = "data/get"
+	dataSetRouter   = "data/set"
+	dataBatchRouter = "data/batch"
+)
+
+func init() {
+	boot.RegisterWsRouterHandler(dataGetRouter, onDataGet)
+	boot.RegisterWsRouterHandler(dataSetRouter, onDataSet)
+	boot.RegisterWsRouterHandler(dataBatchRouter, onDataBatch)
+	boot.RegisterPreFunction(service.PlayerDataService.Init)
+}
+
+// decodeParams 将解码后的参数重新转换为结构体, 兼容不同的编解码
+func decodeParams(v interface{}, target interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, target)
+}
+
+// onDataGet 参数 namespace, keys 可选的键数组, 为空时返回命名空间下的全部
+func onDataGet(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	namespace, _ := msg.Params["namespace"].(string)
+	keys := make([]string, 0)
+	if v, exist := msg.Params["keys"]; exist && v != nil {
+		if err := decodeParams(v, &keys); err != nil {
+			msg.Failed(conn, net.WsErrorBadRequest, "keys must be an array of string")
+			return
+		}
+	}
+	result, err := service.PlayerDataService.Get(conn.Uid, namespace, keys)
+	if err != nil {
+		failedPlayerData(conn, msg, err)
+		return
+	}
+	msg.Ok(conn, result)
+}
+
+// onDataSet 参数 namespace, key, value, 可选的 version 及 delete, 见 service.PlayerDataWrite
+func onDataSet(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	namespace, _ := msg.Params["namespace"].(string)
+	write := service.PlayerDataWrite{}
+	if err := decodeParams(msg.Params, &write); err != nil {
+		msg.Failed(conn, net.WsErrorBadRequest, "invalid params")
+		return
+	}
+	result, err := service.PlayerDataService.Set(conn.Uid, namespace, write)
+	if err != nil {
+		failedPlayerData(conn, msg, err)
+		return
+	}
+	msg.Ok(conn, result)
+}
+
+// onDataBatch 参数 namespace, items 写入数组, 全部成功或全部失败
+func onDataBatch(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	namespace, _ := msg.Params["namespace"].(string)
+	writes := make([]service.PlayerDataWrite, 0)
+	if err := decodeParams(msg.Params["items"], &writes); err != nil {
+		msg.Failed(conn, net.WsErrorBadRequest, "items must be an array")
+		return
+	}
+	result, err := service.PlayerDataService.Write(conn.Uid, namespace, writes)
+	if err != nil {
+		failedPlayerData(conn, msg, err)
+		return
+	}
+	msg.Ok(conn, result)
+}
+
+// failedPlayerData 存档错误对应的错误码, 版本冲突时客户端需重新获取后合并
+func failedPlayerData(conn *net.WsConn, msg boot.WsRequestMsg, err error) {
+	switch err {
+	case service.ErrDataVersionConflict:
+		msg.Failed(conn, net.WsErrorConflict, err.Error())
+	case service.ErrDataNotFound:
+		msg.Failed(conn, net.WsErrorNotFound, err.Error())
+	case service.ErrDataSaveFailure:
+		msg.Failed(conn, net.WsErrorInternal, err.Error())
+	default:
+		msg.Failed(conn, net.WsErrorBadRequest, err.Error())
+	}
+}
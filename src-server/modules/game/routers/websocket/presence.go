@@ -0,0 +1,88 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build websocket
+// +build websocket
+
+package websocket
+
+import (
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/net"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	userPresenceRouter = "user/presence"
+	// maxPresenceQuery 单次最多查询的玩家数
+	maxPresenceQuery = 200
+)
+
+var presenceStop = make(chan struct{})
+
+func init() {
+	boot.RegisterWsAuthedHandler(onUserOnline)
+	boot.RegisterWsClosedHandler(onUserOffline)
+	boot.RegisterWsRouterHandler(userPresenceRouter, onUserPresence)
+	boot.RegisterPreFunction(func() {
+		go refreshPresence()
+	})
+	boot.RegisterShutdownFunction(func() {
+		close(presenceStop)
+		service.PresenceService.RemoveNode(boot.App.Identifier)
+	})
+}
+
+func onUserOnline(worker *net.WsWorker, conn *net.WsConn, resumed bool) {
+	g3.ZL().Info("user online",
+		zap.Int64("uid", conn.Uid),
+		zap.String("uuid", conn.Uuid),
+		zap.Bool("resumed", resumed))
+	service.PresenceService.MarkOnline(conn.Uid, boot.App.Identifier, len(worker.UserConns(conn.Uid)), resumed)
+	service.PresenceService.SetNodeUsers(boot.App.Identifier, worker.OnlineUsers())
+}
+
+func onUserOffline(worker *net.WsWorker, conn *net.WsConn) {
+	if conn.Uid <= 0 {
+		return
+	}
+	conns := len(worker.UserConns(conn.Uid))
+	g3.ZL().Info("user offline",
+		zap.Int64("uid", conn.Uid),
+		zap.String("uuid", conn.Uuid),
+		zap.Int("conns", conns))
+	service.PresenceService.MarkOffline(conn.Uid, boot.App.Identifier, conns)
+	service.PresenceService.SetNodeUsers(boot.App.Identifier, worker.OnlineUsers())
+}
+
+// refreshPresence 定时刷新本节点在线玩家, 节点宕机后其玩家会在超时后被视为离线
+func refreshPresence() {
+	ticker := time.NewTicker(service.PresenceRefreshInterval)
+	defer ticker.Stop()
+	service.PresenceService.RefreshNode(boot.App.Identifier, boot.Worker().OnlineUsers())
+	for {
+		select {
+		case <-presenceStop:
+			return
+		case <-ticker.C:
+			service.PresenceService.RefreshNode(boot.App.Identifier, boot.Worker().OnlineUsers())
+		}
+	}
+}
+
+// onUserPresence 查询玩家(如好友)的在线状态, 参数 uids 为玩家id数组
+func onUserPresence(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	uids, err := msg.GetInt64Slice("uids")
+	if err != nil || len(uids) > maxPresenceQuery {
+		msg.Failed(conn, net.WsErrorBadRequest, "uids is incorrect")
+		return
+	}
+	presences := service.PresenceService.GetMany(uids)
+	rows := make([]service.Presence, 0, len(uids))
+	for _, uid := range uids {
+		rows = append(rows, presences[uid])
+	}
+	msg.Ok(conn, map[string]interface{}{"rows": rows})
+}
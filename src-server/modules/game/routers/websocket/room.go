@@ -0,0 +1,248 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build websocket
+// +build websocket
+
+package websocket
+
+import (
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/match"
+	"github.com/zhouhp1295/g3-game/modules/game/room"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/net"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+const (
+	roomJoinRouter  = "room/join"
+	roomLeaveRouter = "room/leave"
+	roomReadyRouter = "room/ready"
+	roomInputRouter = "room/input"
+	roomInfoRouter  = "room/info"
+	// roomFramesRouter 帧同步模式拉取历史帧
+	roomFramesRouter = "room/frames"
+
+	roomModeSectionPrefix = "room.mode."
+)
+
+type roomModeConfig struct {
+	Logic          string `ini:"LOGIC"`
+	TickRate       int    `ini:"TICK_RATE"`
+	BroadcastEvery int    `ini:"BROADCAST_EVERY"`
+	MaxPlayers     int    `ini:"MAX_PLAYERS"`
+	MinPlayers     int    `ini:"MIN_PLAYERS"`
+	OfflineTimeout int    `ini:"OFFLINE_TIMEOUT"`
+	WaitTimeout    int    `ini:"WAIT_TIMEOUT"`
+	LateJoin       bool   `ini:"LATE_JOIN"`
+	Replay         bool   `ini:"REPLAY"`
+}
+
+// Rooms 当前节点的房间
+var Rooms *room.Manager
+
+func init() {
+	boot.RegisterWsRouterHandler(roomJoinRouter, onRoomJoin)
+	boot.RegisterWsRouterHandler(roomLeaveRouter, onRoomLeave)
+	boot.RegisterWsRouterHandler(roomReadyRouter, onRoomReady)
+	boot.RegisterWsRouterHandler(roomInputRouter, onRoomInput)
+	boot.RegisterWsRouterHandler(roomInfoRouter, onRoomInfo)
+	boot.RegisterWsRouterHandler(roomFramesRouter, onRoomFrames)
+	boot.RegisterWsClosedHandler(onRoomClosed)
+	boot.RegisterPreFunction(func() {
+		Rooms = newRooms()
+	})
+	boot.RegisterShutdownFunction(func() {
+		if Rooms != nil {
+			Rooms.CloseAll()
+		}
+	})
+}
+
+// newRooms 读取websocket.ini中的[room.mode.*]
+func newRooms() *room.Manager {
+	modes := make(map[string]room.ModeConfig)
+	for _, section := range boot.WebsocketFile.Sections() {
+		if !strings.HasPrefix(section.Name(), roomModeSectionPrefix) {
+			continue
+		}
+		cfg := roomModeConfig{}
+		if err := section.MapTo(&cfg); err != nil {
+			panic(err)
+		}
+		modes[strings.TrimPrefix(section.Name(), roomModeSectionPrefix)] = room.ModeConfig{
+			Logic:          cfg.Logic,
+			TickRate:       cfg.TickRate,
+			BroadcastEvery: cfg.BroadcastEvery,
+			MaxPlayers:     cfg.MaxPlayers,
+			MinPlayers:     cfg.MinPlayers,
+			OfflineTimeout: time.Duration(cfg.OfflineTimeout) * time.Second,
+			WaitTimeout:    time.Duration(cfg.WaitTimeout) * time.Second,
+			LateJoin:       cfg.LateJoin,
+			Replay:         cfg.Replay,
+		}
+	}
+	m := room.NewManager(func(uid int64, router string, data interface{}) int {
+		return boot.Worker().SendToUser(uid, router, data)
+	}, modes)
+	m.OnReplay(onRoomReplay)
+	g3.ZL().Info("room manager started", zap.Strings("modes", m.Modes()))
+	return m
+}
+
+// onMatchedCreateRoom 匹配成功后以匹配的房间号创建房间, 玩家收到match/matched后加入
+func onMatchedCreateRoom(result match.Match) {
+	if _, err := Rooms.Create(result.RoomId, result.Mode, result.Teams); err != nil {
+		g3.ZL().Error("create room for match failed",
+			zap.String("roomId", result.RoomId),
+			zap.String("mode", result.Mode),
+			zap.Error(err))
+	}
+}
+
+// onRoomReplay 保存回放
+func onRoomReplay(replay room.Replay) {
+	saved, err := service.ReplayService.Save(replay, boot.App.Identifier)
+	if err != nil {
+		g3.ZL().Error("save replay failed",
+			zap.String("roomId", replay.RoomId),
+			zap.Error(err))
+		return
+	}
+	g3.ZL().Info("replay saved",
+		zap.String("roomId", replay.RoomId),
+		zap.Int64("id", saved.Id),
+		zap.Int64("size", saved.Size))
+}
+
+// failedRoom 房间错误对应的错误码
+func failedRoom(conn *net.WsConn, msg boot.WsRequestMsg, err error) {
+	switch err {
+	case room.ErrRoomNotFound, room.ErrNotInRoom:
+		msg.Failed(conn, net.WsErrorNotFound, err.Error())
+	case room.ErrAlreadyInRoom, room.ErrRoomFull, room.ErrRoomClosed:
+		msg.Failed(conn, net.WsErrorConflict, err.Error())
+	case room.ErrNotAllowed, room.ErrNotPlaying:
+		msg.Failed(conn, net.WsErrorForbidden, err.Error())
+	default:
+		msg.Failed(conn, net.WsErrorBadRequest, err.Error())
+	}
+}
+
+// onRoomJoin 加入房间, 参数 roomId; 对局中掉线后再次加入即为重连
+func onRoomJoin(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	roomId, err := msg.GetString("roomId")
+	if err != nil || len(roomId) == 0 {
+		msg.Failed(conn, net.WsErrorBadRequest, "roomId is required")
+		return
+	}
+	// 加入房间后不再参与匹配
+	Matcher.Cancel(conn.Uid)
+	info, err := Rooms.Join(conn.Uid, roomId)
+	if err != nil {
+		failedRoom(conn, msg, err)
+		return
+	}
+	msg.Ok(conn, info)
+}
+
+func onRoomLeave(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	if err := Rooms.Leave(conn.Uid); err != nil {
+		failedRoom(conn, msg, err)
+		return
+	}
+	msg.Ok(conn, nil)
+}
+
+// onRoomReady 设置准备状态, 参数 ready 可选, 默认为true
+func onRoomReady(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	ready := true
+	if _, exist := msg.Params["ready"]; exist {
+		var err error
+		if ready, err = msg.GetBool("ready"); err != nil {
+			msg.Failed(conn, net.WsErrorBadRequest, "ready is incorrect")
+			return
+		}
+	}
+	if err := Rooms.Ready(conn.Uid, ready); err != nil {
+		failedRoom(conn, msg, err)
+		return
+	}
+	msg.Ok(conn, map[string]interface{}{"ready": ready})
+}
+
+// onRoomInput 提交输入, 参数 input 由玩法逻辑解析; 不回复以减少流量, 失败时返回错误
+func onRoomInput(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	input, err := msg.Get("input")
+	if err != nil {
+		msg.Failed(conn, net.WsErrorBadRequest, "input is required")
+		return
+	}
+	if err = Rooms.Input(conn.Uid, input); err != nil {
+		failedRoom(conn, msg, err)
+	}
+}
+
+func onRoomInfo(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	r, exist := Rooms.RoomOf(conn.Uid)
+	if !exist {
+		failedRoom(conn, msg, room.ErrNotInRoom)
+		return
+	}
+	info, err := r.Info()
+	if err != nil {
+		failedRoom(conn, msg, room.ErrNotInRoom)
+		return
+	}
+	msg.Ok(conn, info)
+}
+
+// onRoomFrames 拉取历史帧, 用于重连或后加入的玩家追帧
+// 参数 from 起始帧(含), 可选 limit; 返回的 more 为true时需继续拉取
+func onRoomFrames(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	from, err := msg.GetInt64("from")
+	if err != nil || from < 0 {
+		msg.Failed(conn, net.WsErrorBadRequest, "from is incorrect")
+		return
+	}
+	limit := int64(room.MaxFramesPerFetch)
+	if _, exist := msg.Params["limit"]; exist {
+		if limit, err = msg.GetInt64("limit"); err != nil {
+			msg.Failed(conn, net.WsErrorBadRequest, "limit is incorrect")
+			return
+		}
+	}
+	r, exist := Rooms.RoomOf(conn.Uid)
+	if !exist {
+		failedRoom(conn, msg, room.ErrNotInRoom)
+		return
+	}
+	var result room.FramesResult
+	lockstep := false
+	err = r.Exec(func(logic room.Logic) {
+		if l, ok := logic.(*room.Lockstep); ok {
+			lockstep = true
+			result = l.Frames(r, uint64(from), int(limit))
+		}
+	})
+	if err != nil {
+		failedRoom(conn, msg, room.ErrNotInRoom)
+		return
+	}
+	if !lockstep {
+		msg.Failed(conn, net.WsErrorBadRequest, "room is not lockstep")
+		return
+	}
+	msg.Ok(conn, result)
+}
+
+// onRoomClosed 玩家的连接全部断开后标记掉线
+func onRoomClosed(worker *net.WsWorker, conn *net.WsConn) {
+	if conn.Uid <= 0 || Rooms == nil || worker.IsUserOnline(conn.Uid) {
+		return
+	}
+	Rooms.Offline(conn.Uid)
+}
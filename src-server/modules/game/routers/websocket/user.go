@@ -28,7 +28,7 @@ func onUserAuth(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
 	if err != nil {
 		g3.ZL().Error("user auth failed . parse token failed. please check.",
 			zap.Error(err))
-		conn.Failed(msg.Router, "parse token failed")
+		msg.Failed(conn, net.WsErrorBadRequest, "parse token failed")
 		worker.Close(conn)
 		return
 	}
@@ -37,16 +37,33 @@ func onUserAuth(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
 		g3.ZL().Error("user auth failed .token is incorrect. please check.",
 			zap.Reflect("token", token),
 			zap.Error(err))
-		conn.Failed(msg.Router, "incorrect token")
+		msg.Failed(conn, net.WsErrorUnauthorized, "incorrect token")
+		worker.Close(conn)
+		return
+	}
+	if err = worker.BindUser(conn, claims.Uid); err != nil {
+		if err == net.ErrWsConnNotFound {
+			// 授权期间连接已关闭
+			return
+		}
+		g3.ZL().Info("user auth failed . user already logged in.",
+			zap.Int64("uid", claims.Uid),
+			zap.Error(err))
+		msg.Failed(conn, net.WsErrorConflict, "already logged in")
 		worker.Close(conn)
 		return
 	}
 	conn.Data["uid"] = claims.Uid
 	g3.ZL().Info("connection auth success", zap.String("uuid", conn.Uuid))
 	conn.Status = net.WsConnected
-	conn.Ok(msg.Router, gin.H{"uid": claims.Uid})
+	resp := gin.H{"uid": claims.Uid}
+	// 开启会话恢复时返回会话token, 断线后凭它调用user/resume
+	if session := worker.NewSession(conn); session != nil {
+		resp["session"] = session.Token
+	}
+	msg.Ok(conn, resp)
 }
 
 func onUserInfo(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
-	conn.Ok(msg.Router, msg)
+	msg.Ok(conn, msg)
 }
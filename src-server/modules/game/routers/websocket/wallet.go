@@ -0,0 +1,40 @@
+// Copyright (c) 554949297@qq.com . 2022-2022. All rights reserved
+
+//go:build websocket
+// +build websocket
+
+package websocket
+
+import (
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/service"
+	"github.com/zhouhp1295/g3/net"
+)
+
+const (
+	walletBalancesRouter = "wallet/balances"
+	walletLedgerRouter   = "wallet/ledger"
+)
+
+// 入账、出账及转账由游戏逻辑调用 service.WalletService, 不直接开放给客户端
+func init() {
+	boot.RegisterWsRouterHandler(walletBalancesRouter, onWalletBalances)
+	boot.RegisterWsRouterHandler(walletLedgerRouter, onWalletLedger)
+	boot.RegisterPreFunction(service.WalletService.Init)
+}
+
+// onWalletBalances 自己所有货币的余额
+func onWalletBalances(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	msg.Ok(conn, service.WalletService.Balances(conn.Uid))
+}
+
+// onWalletLedger 自己最近的分录, 参数 currency 可选, limit 默认及最大100
+func onWalletLedger(worker *net.WsWorker, conn *net.WsConn, msg boot.WsRequestMsg) {
+	currency, _ := msg.Params["currency"].(string)
+	limit := 0
+	if _, exist := msg.Params["limit"]; exist {
+		n, _ := msg.GetInt64("limit")
+		limit = int(n)
+	}
+	msg.Ok(conn, service.WalletService.Ledger(conn.Uid, currency, limit))
+}
@@ -0,0 +1,259 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/zhouhp1295/g3-game/modules/game/dao"
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3/crud"
+	"github.com/zhouhp1295/g3/helpers"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidDeviceId    = errors.New("设备id格式错误")
+	ErrInvalidUsername    = errors.New("用户名须以字母开头, 由4-36位字母、数字或下划线组成")
+	ErrInvalidPassword    = errors.New("密码长度须为6-64位")
+	ErrInvalidEmail       = errors.New("邮箱格式错误")
+	ErrInvalidPhone       = errors.New("手机号格式错误")
+	ErrUsernameExists     = errors.New("用户名已存在")
+	ErrEmailExists        = errors.New("邮箱已被其它账号绑定")
+	ErrPhoneExists        = errors.New("手机号已被其它账号绑定")
+	ErrAlreadyRegistered  = errors.New("已绑定账号")
+	ErrAccountNotFound    = errors.New("账号不存在")
+	ErrPasswordIncorrect  = errors.New("账号或密码错误")
+	ErrPasswordNotSet     = errors.New("该账号未设置密码, 请先绑定用户名及密码")
+	ErrAccountDisabled    = errors.New("账号已被禁用")
+	ErrAccountSaveFailure = errors.New("保存账号失败")
+)
+
+var (
+	deviceIdPattern = regexp.MustCompile(`^[A-Za-z0-9_\-:.]{8,64}$`)
+	usernamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{3,35}$`)
+	phonePattern    = regexp.MustCompile(`^\+?[0-9]{6,19}$`)
+)
+
+type accountService struct {
+	// mutex 本进程内串行创建游客及绑定, 避免同一设备或账号并发写入
+	mutex sync.Mutex
+}
+
+// AccountService 游客登录及绑定账号
+var AccountService = new(accountService)
+
+// GuestLogin 按设备id登录游客, 设备已有游客账号时复用, 否则创建
+func (service *accountService) GuestLogin(deviceId string) (*model.GameUser, error) {
+	deviceId = strings.TrimSpace(deviceId)
+	if !deviceIdPattern.MatchString(deviceId) {
+		return nil, ErrInvalidDeviceId
+	}
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	if user, ok := service.findGuest(deviceId); ok {
+		return service.checkGuest(user)
+	}
+	user := service.NewGuest()
+	user.DeviceId = &deviceId
+	if err := crud.DbSess().Create(user).Error; err != nil {
+		// 其它进程已为该设备创建游客, 唯一索引冲突
+		if user, ok := service.findGuest(deviceId); ok {
+			return service.checkGuest(user)
+		}
+		return nil, ErrAccountSaveFailure
+	}
+	return user, nil
+}
+
+func (service *accountService) findGuest(deviceId string) (*model.GameUser, bool) {
+	user, ok := dao.GameUserDao.FindOneByColumn("device_id", deviceId).(*model.GameUser)
+	return user, ok
+}
+
+func (service *accountService) checkGuest(user *model.GameUser) (*model.GameUser, error) {
+	if user.Status != crud.FlagYes {
+		return nil, ErrAccountDisabled
+	}
+	return user, nil
+}
+
+// NewGuest 新的游客, 用户名为随机uuid
+func (service *accountService) NewGuest() *model.GameUser {
+	user := new(model.GameUser)
+	user.Username = fmt.Sprintf("%v", uuid.New())
+	user.Nickname = "游客"
+	user.IsGuest = crud.FlagYes
+	return user
+}
+
+// BindAccount 为游客设置用户名及密码, 升级为正式账号; 设备id随之解绑并吊销全部refresh token, 之后需使用账号密码登录
+func (service *accountService) BindAccount(uid int64, username, password string) (*model.GameUser, error) {
+	username = strings.TrimSpace(username)
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+	if len(password) < 6 || len(password) > 64 {
+		return nil, ErrInvalidPassword
+	}
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	user, err := service.find(uid)
+	if err != nil {
+		return nil, err
+	}
+	if user.IsGuest != crud.FlagYes || len(user.Password) > 0 {
+		return nil, ErrAlreadyRegistered
+	}
+	// 用户名不区分大小写, 统一小写保存
+	username = strings.ToLower(username)
+	if dao.GameUserDao.Taken("username", username, uid) {
+		return nil, ErrUsernameExists
+	}
+	hashed, err := helpers.PasswordHash(password)
+	if err != nil {
+		return nil, ErrAccountSaveFailure
+	}
+	if err = crud.DbSess().Table(user.Table()).Where("id = ?", uid).Updates(map[string]interface{}{
+		"username":   username,
+		"password":   hashed,
+		"device_id":  nil,
+		"is_guest":   crud.FlagNo,
+		"updated_by": uid,
+	}).Error; err != nil {
+		// 其它进程已占用该用户名, 唯一索引冲突
+		if dao.GameUserDao.Taken("username", username, uid) {
+			return nil, ErrUsernameExists
+		}
+		return nil, ErrAccountSaveFailure
+	}
+	user.Username, user.Password, user.DeviceId, user.IsGuest = username, hashed, nil, crud.FlagNo
+	// 游客凭设备id取得的登录状态随之失效
+	RefreshTokenService.RevokeUser(uid)
+	return user, nil
+}
+
+// SendBindCode 向待绑定的邮箱或手机号发送验证码, 已被其它账号绑定时返回错误
+func (service *accountService) SendBindCode(uid int64, channel, target string) error {
+	column, value, err := service.bindTarget(channel, target)
+	if err != nil {
+		return err
+	}
+	if _, err = service.find(uid); err != nil {
+		return err
+	}
+	if dao.GameUserDao.Taken(column, value, uid) {
+		return service.bindExists(column)
+	}
+	return VerifyCodeService.Send(channel, value, uid)
+}
+
+// BindEmail 使用发送到邮箱的验证码绑定邮箱, 绑定后可使用邮箱及密码登录
+func (service *accountService) BindEmail(uid int64, email, code string) (*model.GameUser, error) {
+	return service.bind(uid, VerifyChannelEmail, email, code)
+}
+
+// BindPhone 使用发送到手机的验证码绑定手机号, 绑定后可使用手机号及密码登录
+func (service *accountService) BindPhone(uid int64, phone, code string) (*model.GameUser, error) {
+	return service.bind(uid, VerifyChannelPhone, phone, code)
+}
+
+// bindTarget 校验格式, 返回对应的列及规范化后的值
+func (service *accountService) bindTarget(channel, target string) (column, value string, err error) {
+	target = strings.TrimSpace(target)
+	switch channel {
+	case VerifyChannelEmail:
+		address, e := mail.ParseAddress(target)
+		if e != nil || address.Address != target || len(address.Address) > 50 {
+			return "", "", ErrInvalidEmail
+		}
+		return "email", strings.ToLower(address.Address), nil
+	case VerifyChannelPhone:
+		if !phonePattern.MatchString(target) {
+			return "", "", ErrInvalidPhone
+		}
+		return "phone", target, nil
+	}
+	return "", "", ErrVerifyCodeUnavailable
+}
+
+func (service *accountService) bindExists(column string) error {
+	if column == "email" {
+		return ErrEmailExists
+	}
+	return ErrPhoneExists
+}
+
+func (service *accountService) bind(uid int64, channel, target, code string) (*model.GameUser, error) {
+	column, value, err := service.bindTarget(channel, target)
+	if err != nil {
+		return nil, err
+	}
+	user, err := service.find(uid)
+	if err != nil {
+		return nil, err
+	}
+	if err = VerifyCodeService.Verify(channel, value, uid, code); err != nil {
+		return nil, err
+	}
+	if !dao.GameUserDao.UpdateColumn(uid, column, value, uid) {
+		// 唯一索引冲突, 发送验证码后已被其它账号绑定
+		if dao.GameUserDao.Taken(column, value, uid) {
+			return nil, service.bindExists(column)
+		}
+		return nil, ErrAccountSaveFailure
+	}
+	if column == "email" {
+		user.Email = &value
+	} else {
+		user.Phone = &value
+	}
+	return user, nil
+}
+
+// Login 使用用户名、已绑定的邮箱或手机号及密码登录
+func (service *accountService) Login(account, password string) (*model.GameUser, error) {
+	account = strings.TrimSpace(account)
+	if len(account) == 0 || len(password) == 0 {
+		return nil, ErrPasswordIncorrect
+	}
+	// 用户名须以字母开头且不含@, 与邮箱及手机号不会混淆
+	column := "username"
+	if strings.Contains(account, "@") {
+		column = "email"
+	} else if phonePattern.MatchString(account) {
+		column = "phone"
+	}
+	if column != "phone" {
+		account = strings.ToLower(account)
+	}
+	user, ok := dao.GameUserDao.FindOneByColumn(column, account).(*model.GameUser)
+	if !ok {
+		return nil, ErrPasswordIncorrect
+	}
+	if len(user.Password) == 0 {
+		return nil, ErrPasswordNotSet
+	}
+	if !helpers.PasswordVerify(user.Password, password) {
+		return nil, ErrPasswordIncorrect
+	}
+	if user.Status != crud.FlagYes {
+		return nil, ErrAccountDisabled
+	}
+	return user, nil
+}
+
+func (service *accountService) find(uid int64) (*model.GameUser, error) {
+	user, ok := dao.GameUserDao.FindByPk(uid).(*model.GameUser)
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	if user.Status != crud.FlagYes {
+		return nil, ErrAccountDisabled
+	}
+	return user, nil
+}
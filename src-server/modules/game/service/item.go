@@ -0,0 +1,290 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package service
+
+import (
+	"errors"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/modules/game/dao"
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3/crud"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"regexp"
+	"sort"
+)
+
+var (
+	ErrInvalidOpId         = errors.New("操作id须为1-64位字母、数字或_.:-")
+	ErrInvalidItemReason   = errors.New("原因须以小写字母开头, 由1-32位小写字母、数字或下划线组成")
+	ErrInvalidItemQuantity = errors.New("物品数量错误")
+	ErrItemChangesSize     = errors.New("物品种数须为1-50")
+	ErrDuplicateItem       = errors.New("物品列表中存在重复的物品")
+	ErrItemNotFound        = errors.New("物品不存在或已停用")
+	ErrItemStackLimit      = errors.New("超出物品堆叠上限")
+	ErrItemNotEnough       = errors.New("物品数量不足")
+	// ErrItemSaveFailure 保存失败, 可使用相同的操作id重试
+	ErrItemSaveFailure = errors.New("保存物品失败, 请重试")
+)
+
+const (
+	// ItemReasonAdmin 后台调整
+	ItemReasonAdmin = "admin"
+	// MaxItemQuantity 单次变更的最大数量
+	MaxItemQuantity = 1000000000
+	// MaxItemChanges 单次操作的最大物品种数
+	MaxItemChanges = 50
+)
+
+var (
+	opIdPattern       = regexp.MustCompile(`^[A-Za-z0-9_.:\-]{1,64}$`)
+	itemReasonPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+)
+
+// ItemChange 一种物品的变更
+type ItemChange struct {
+	Code     string `json:"code"`
+	Quantity int64  `json:"quantity"`
+}
+
+// ItemOperation 一次发放或消耗, 同一玩家的同一 OpId 只执行一次
+type ItemOperation struct {
+	Uid    int64
+	OpId   string
+	Reason string
+	// Remark 写入变更记录的备注
+	Remark string
+	// Operator 后台用户id, 游戏逻辑为0
+	Operator int64
+	Items    []ItemChange
+}
+
+// ItemChangeResult 一种物品的变更结果
+type ItemChangeResult struct {
+	Code           string `json:"code"`
+	Name           string `json:"name"`
+	Delta          int64  `json:"delta"`
+	QuantityBefore int64  `json:"quantityBefore"`
+	QuantityAfter  int64  `json:"quantityAfter"`
+}
+
+// ItemOperationResult 操作结果, Replayed 为true时操作id已执行过, 返回的是当时的结果
+type ItemOperationResult struct {
+	OpId     string             `json:"opId"`
+	Replayed bool               `json:"replayed"`
+	Items    []ItemChangeResult `json:"items"`
+}
+
+// InventoryItem 背包中的物品
+type InventoryItem struct {
+	ItemId   int64  `json:"itemId"`
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Icon     string `json:"icon"`
+	MaxStack int64  `json:"maxStack"`
+	Quantity int64  `json:"quantity"`
+}
+
+type itemService struct {
+}
+
+// ItemService 物品及背包, 发放及消耗在事务中执行并记录每次变更
+var ItemService = new(itemService)
+
+// Items 所有启用的物品定义
+func (service *itemService) Items() []model.GameItem {
+	return dao.GameItemDao.FindEnabled()
+}
+
+// Inventory 玩家的背包
+func (service *itemService) Inventory(uid int64) []InventoryItem {
+	rows := dao.GameInventoryDao.FindByUid(uid)
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ItemId)
+	}
+	items := dao.GameItemDao.FindByIds(ids)
+	result := make([]InventoryItem, 0, len(rows))
+	for _, row := range rows {
+		item := items[row.ItemId]
+		result = append(result, InventoryItem{
+			ItemId:   row.ItemId,
+			Code:     item.Code,
+			Name:     item.Name,
+			Type:     item.Type,
+			Icon:     item.Icon,
+			MaxStack: item.MaxStack,
+			Quantity: row.Quantity,
+		})
+	}
+	return result
+}
+
+// Grant 发放物品, 任一物品超出堆叠上限时全部不发放
+func (service *itemService) Grant(op ItemOperation) (ItemOperationResult, error) {
+	return service.apply(op, 1)
+}
+
+// Consume 消耗物品, 任一物品数量不足时全部不消耗
+func (service *itemService) Consume(op ItemOperation) (ItemOperationResult, error) {
+	return service.apply(op, -1)
+}
+
+// itemApply 待执行的变更, 按物品id排序以免并发事务互相等待
+type itemApply struct {
+	item  *model.GameItem
+	delta int64
+}
+
+func (service *itemService) apply(op ItemOperation, sign int64) (ItemOperationResult, error) {
+	result := ItemOperationResult{OpId: op.OpId, Items: make([]ItemChangeResult, 0, len(op.Items))}
+	if !opIdPattern.MatchString(op.OpId) {
+		return result, ErrInvalidOpId
+	}
+	if !itemReasonPattern.MatchString(op.Reason) {
+		return result, ErrInvalidItemReason
+	}
+	if logs := dao.GameItemLogDao.FindByOpId(op.Uid, op.OpId); len(logs) > 0 {
+		return service.replay(result, logs), nil
+	}
+	applies, err := service.prepare(op.Items, sign)
+	if err != nil {
+		return result, err
+	}
+	err = crud.DbSess().Transaction(func(tx *gorm.DB) error {
+		for _, a := range applies {
+			changed, err := service.change(tx, op, a)
+			if err != nil {
+				return err
+			}
+			result.Items = append(result.Items, changed)
+		}
+		return nil
+	})
+	if err == nil {
+		return result, nil
+	}
+	if err == ErrItemStackLimit || err == ErrItemNotEnough {
+		return ItemOperationResult{OpId: op.OpId, Items: make([]ItemChangeResult, 0)}, err
+	}
+	// 并发执行同一操作id时唯一索引冲突, 返回已执行的结果
+	if logs := dao.GameItemLogDao.FindByOpId(op.Uid, op.OpId); len(logs) > 0 {
+		return service.replay(result, logs), nil
+	}
+	g3.ZL().Error("apply item operation failed",
+		zap.Int64("uid", op.Uid),
+		zap.String("opId", op.OpId),
+		zap.Error(err))
+	return ItemOperationResult{OpId: op.OpId, Items: make([]ItemChangeResult, 0)}, ErrItemSaveFailure
+}
+
+func (service *itemService) prepare(changes []ItemChange, sign int64) ([]itemApply, error) {
+	if len(changes) == 0 || len(changes) > MaxItemChanges {
+		return nil, ErrItemChangesSize
+	}
+	applies := make([]itemApply, 0, len(changes))
+	codes := make(map[string]struct{}, len(changes))
+	for _, change := range changes {
+		if change.Quantity <= 0 || change.Quantity > MaxItemQuantity {
+			return nil, ErrInvalidItemQuantity
+		}
+		if _, exist := codes[change.Code]; exist {
+			return nil, ErrDuplicateItem
+		}
+		codes[change.Code] = struct{}{}
+		item := dao.GameItemDao.FindByCode(change.Code)
+		// 已停用的物品不再发放, 但仍可消耗
+		if item == nil || (sign > 0 && item.Status != crud.FlagYes) {
+			return nil, ErrItemNotFound
+		}
+		applies = append(applies, itemApply{item: item, delta: sign * change.Quantity})
+	}
+	sort.Slice(applies, func(i, j int) bool {
+		return applies[i].item.Id < applies[j].item.Id
+	})
+	return applies, nil
+}
+
+// change 变更一种物品的数量, 以条件更新保证并发时不超出上限且不为负
+func (service *itemService) change(tx *gorm.DB, op ItemOperation, a itemApply) (ItemChangeResult, error) {
+	changed := ItemChangeResult{Code: a.item.Code, Name: a.item.Name, Delta: a.delta}
+	rows := make([]model.GameInventory, 0, 1)
+	if err := tx.Where("uid = ? AND item_id = ?", op.Uid, a.item.Id).Limit(1).Find(&rows).Error; err != nil {
+		return changed, err
+	}
+	if len(rows) == 0 {
+		if a.delta < 0 {
+			return changed, ErrItemNotEnough
+		}
+		// 先插入数量为0的行, 并发时由唯一索引保证只有一行, 之后统一走条件更新
+		m := &model.GameInventory{Uid: op.Uid, ItemId: a.item.Id}
+		m.SetCreatedBy(op.Operator)
+		m.SetUpdatedBy(op.Operator)
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(m).Error; err != nil {
+			return changed, err
+		}
+	}
+	sess := tx.Model(new(model.GameInventory)).Where("uid = ? AND item_id = ?", op.Uid, a.item.Id)
+	if a.delta < 0 {
+		sess = sess.Where("quantity >= ?", -a.delta)
+	} else if a.item.MaxStack > 0 {
+		sess = sess.Where("quantity <= ?", a.item.MaxStack-a.delta)
+	}
+	updated := sess.Updates(map[string]interface{}{
+		"quantity":   gorm.Expr("quantity + ?", a.delta),
+		"updated_by": op.Operator,
+	})
+	if updated.Error != nil {
+		return changed, updated.Error
+	}
+	if updated.RowsAffected != 1 {
+		if a.delta < 0 {
+			return changed, ErrItemNotEnough
+		}
+		return changed, ErrItemStackLimit
+	}
+	// 事务中已持有该行的写锁, 读到的即本次更新后的数量
+	if err := tx.Model(new(model.GameInventory)).
+		Where("uid = ? AND item_id = ?", op.Uid, a.item.Id).
+		Pluck("quantity", &changed.QuantityAfter).Error; err != nil {
+		return changed, err
+	}
+	changed.QuantityBefore = changed.QuantityAfter - a.delta
+	log := &model.GameItemLog{
+		Uid:            op.Uid,
+		OpId:           op.OpId,
+		ItemId:         a.item.Id,
+		ItemCode:       a.item.Code,
+		Reason:         op.Reason,
+		Delta:          a.delta,
+		QuantityBefore: changed.QuantityBefore,
+		QuantityAfter:  changed.QuantityAfter,
+		Operator:       op.Operator,
+	}
+	log.Remark = op.Remark
+	log.SetCreatedBy(op.Operator)
+	log.SetUpdatedBy(op.Operator)
+	return changed, tx.Create(log).Error
+}
+
+func (service *itemService) replay(result ItemOperationResult, logs []model.GameItemLog) ItemOperationResult {
+	ids := make([]int64, 0, len(logs))
+	for _, log := range logs {
+		ids = append(ids, log.ItemId)
+	}
+	items := dao.GameItemDao.FindByIds(ids)
+	result.Replayed = true
+	result.Items = make([]ItemChangeResult, 0, len(logs))
+	for _, log := range logs {
+		result.Items = append(result.Items, ItemChangeResult{
+			Code:           log.ItemCode,
+			Name:           items[log.ItemId].Name,
+			Delta:          log.Delta,
+			QuantityBefore: log.QuantityBefore,
+			QuantityAfter:  log.QuantityAfter,
+		})
+	}
+	return result
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package service
+
+import (
+	"errors"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/leaderboard"
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3/crud"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// leaderboardSectionPrefix app.ini中排行榜的配置段, 段名后缀为排行榜名称
+const leaderboardSectionPrefix = "leaderboard.board."
+
+// ErrLeaderboardNotShared 缓存未使用redis, 排行榜只保存在websocket节点的进程内
+var ErrLeaderboardNotShared = errors.New("http接口查询排行榜需要将[cache] TYPE设置为redis")
+
+type leaderboardConfig struct {
+	Policy       string `ini:"POLICY"`
+	Period       string `ini:"PERIOD"`
+	Keep         int    `ini:"KEEP"`
+	ClientSubmit bool   `ini:"CLIENT_SUBMIT"`
+}
+
+// LeaderboardEntry 带玩家昵称及头像的排名
+type LeaderboardEntry struct {
+	leaderboard.Entry
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar"`
+}
+
+// LeaderboardResult 排行榜查询结果
+type LeaderboardResult struct {
+	Board   string             `json:"board"`
+	Season  string             `json:"season"`
+	Total   int64              `json:"total"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// LeaderboardRank 玩家的排名, Ranked 为false时未上榜
+type LeaderboardRank struct {
+	Board  string           `json:"board"`
+	Season string           `json:"season"`
+	Ranked bool             `json:"ranked"`
+	Entry  LeaderboardEntry `json:"entry"`
+}
+
+type leaderboardService struct {
+	boards *leaderboard.Leaderboards
+}
+
+// LeaderboardService 排行榜, 缓存使用redis时数据存放在redis有序集合中, 否则为进程内的跳表
+var LeaderboardService = new(leaderboardService)
+
+// Init 读取app.ini中的[leaderboard.board.*], 在 boot.RegisterPreFunction 中调用
+func (service *leaderboardService) Init() {
+	boards := make([]leaderboard.Board, 0)
+	for _, section := range boot.File.Sections() {
+		if !strings.HasPrefix(section.Name(), leaderboardSectionPrefix) {
+			continue
+		}
+		cfg := leaderboardConfig{Keep: 1}
+		if err := section.MapTo(&cfg); err != nil {
+			panic(err)
+		}
+		boards = append(boards, leaderboard.Board{
+			Name:         strings.TrimPrefix(section.Name(), leaderboardSectionPrefix),
+			Policy:       leaderboard.Policy(strings.ToLower(cfg.Policy)),
+			Period:       leaderboard.Period(strings.ToLower(cfg.Period)),
+			Keep:         cfg.Keep,
+			ClientSubmit: cfg.ClientSubmit,
+		})
+	}
+	var store leaderboard.Store
+	if boot.Redis != nil {
+		store = leaderboard.NewRedisStore(boot.Redis)
+	} else {
+		store = leaderboard.NewMemoryStore()
+	}
+	lbs, err := leaderboard.NewLeaderboards(store, boards)
+	if err != nil {
+		panic(err)
+	}
+	service.boards = lbs
+	g3.ZL().Info("leaderboards loaded",
+		zap.Int("boards", len(boards)),
+		zap.Bool("redis", boot.Redis != nil))
+}
+
+// Shared 排行榜是否可被其它进程读写, 仅缓存使用redis时为true
+// 否则http及websocket进程各自持有独立的数据, 只能通过websocket使用
+func (service *leaderboardService) Shared() bool {
+	return boot.Redis != nil
+}
+
+// Boards 所有排行榜
+func (service *leaderboardService) Boards() []leaderboard.Board {
+	return service.boards.Boards()
+}
+
+// Submit 提交分数, client 为true时表示来自客户端, 需排行榜允许
+func (service *leaderboardService) Submit(board string, uid int64, score float64, client bool) (leaderboard.Entry, error) {
+	entry, err := service.boards.Submit(board, uid, score, client)
+	if err != nil && err != leaderboard.ErrUnknownBoard && err != leaderboard.ErrSubmitForbidden {
+		g3.ZL().Error("submit leaderboard score failed",
+			zap.String("board", board),
+			zap.Int64("uid", uid),
+			zap.Error(err))
+	}
+	return entry, err
+}
+
+// Top 前limit名
+func (service *leaderboardService) Top(board, season string, limit int) (LeaderboardResult, error) {
+	result, err := service.boards.Top(board, season, limit)
+	return service.withUsers(result), err
+}
+
+// Around 玩家附近的limit名
+func (service *leaderboardService) Around(board, season string, uid int64, limit int) (LeaderboardResult, error) {
+	result, err := service.boards.Around(board, season, uid, limit)
+	return service.withUsers(result), err
+}
+
+// RankOf 玩家的排名
+func (service *leaderboardService) RankOf(board, season string, uid int64) (LeaderboardRank, error) {
+	result := LeaderboardRank{Board: board}
+	var err error
+	if result.Season, err = service.boards.Season(board, season); err != nil {
+		return result, err
+	}
+	entry, ranked, err := service.boards.RankOf(board, season, uid)
+	if err != nil {
+		return result, err
+	}
+	result.Ranked = ranked
+	result.Entry = service.users([]leaderboard.Entry{entry})[0]
+	return result, nil
+}
+
+func (service *leaderboardService) withUsers(result leaderboard.Result) LeaderboardResult {
+	return LeaderboardResult{
+		Board:   result.Board,
+		Season:  result.Season,
+		Total:   result.Total,
+		Entries: service.users(result.Entries),
+	}
+}
+
+// users 查询昵称及头像
+func (service *leaderboardService) users(entries []leaderboard.Entry) []LeaderboardEntry {
+	result := make([]LeaderboardEntry, 0, len(entries))
+	if len(entries) == 0 {
+		return result
+	}
+	uids := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		uids = append(uids, entry.Uid)
+	}
+	users := make([]model.GameUser, 0, len(uids))
+	if err := crud.DbSess().Where("id IN ?", uids).Find(&users).Error; err != nil {
+		g3.ZL().Error("find leaderboard users failed", zap.Error(err))
+	}
+	byUid := make(map[int64]model.GameUser, len(users))
+	for _, user := range users {
+		byUid[user.Id] = user
+	}
+	for _, entry := range entries {
+		user := byUid[entry.Uid]
+		result = append(result, LeaderboardEntry{Entry: entry, Nickname: user.Nickname, Avatar: user.Avatar})
+	}
+	return result
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/dao"
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3/crud"
+	"gorm.io/gorm"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrInvalidNamespace    = errors.New("命名空间须为1-32位字母、数字或_.-")
+	ErrInvalidDataKey      = errors.New("键须为1-64位字母、数字或_.:-")
+	ErrInvalidDataValue    = errors.New("值须为合法的JSON")
+	ErrDataValueTooLarge   = errors.New("值超出大小限制")
+	ErrTooManyDataKeys     = errors.New("存档条目超出数量限制")
+	ErrDataBatchSize       = errors.New("批量写入的条目数超出限制")
+	ErrDuplicateDataKey    = errors.New("批量写入中存在重复的键")
+	ErrDataVersionConflict = errors.New("存档版本冲突, 请获取最新数据后重试")
+	ErrDataNotFound        = errors.New("存档不存在")
+	ErrDataSaveFailure     = errors.New("保存存档失败")
+)
+
+var (
+	namespacePattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,32}$`)
+	dataKeyPattern   = regexp.MustCompile(`^[A-Za-z0-9_.:\-]{1,64}$`)
+)
+
+type playerDataConfig struct {
+	// MaxValueBytes 单个值的最大字节数
+	MaxValueBytes int `ini:"MAX_VALUE_BYTES"`
+	// MaxKeys 每个玩家最多的条目数
+	MaxKeys int `ini:"MAX_KEYS"`
+	// MaxBatch 单次批量写入的最大条目数
+	MaxBatch int `ini:"MAX_BATCH"`
+}
+
+// PlayerData 存档条目
+type PlayerData struct {
+	Namespace string          `json:"namespace"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Version   int64           `json:"version"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// PlayerDataWrite 一条写入
+type PlayerDataWrite struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+	// Version 为nil时不检查版本; 0 表示只允许新建; 其他值须与当前版本一致
+	Version *int64 `json:"version"`
+	// Delete 为true时删除该键
+	Delete bool `json:"delete"`
+}
+
+type playerDataService struct {
+	cfg playerDataConfig
+}
+
+// PlayerDataService 玩家存档
+var PlayerDataService = &playerDataService{
+	cfg: playerDataConfig{MaxValueBytes: 60000, MaxKeys: 200, MaxBatch: 50},
+}
+
+// Init 读取app.ini中的[player_data], 在 boot.RegisterPreFunction 中调用
+func (service *playerDataService) Init() {
+	cfg := service.cfg
+	if err := boot.File.Section("player_data").MapTo(&cfg); err != nil {
+		panic(err)
+	}
+	if cfg.MaxValueBytes > 0 {
+		service.cfg.MaxValueBytes = cfg.MaxValueBytes
+	}
+	if cfg.MaxKeys > 0 {
+		service.cfg.MaxKeys = cfg.MaxKeys
+	}
+	if cfg.MaxBatch > 0 {
+		service.cfg.MaxBatch = cfg.MaxBatch
+	}
+}
+
+func (service *playerDataService) toPlayerData(m *model.GamePlayerData) PlayerData {
+	return PlayerData{
+		Namespace: m.Namespace,
+		Key:       m.DataKey,
+		Value:     json.RawMessage(m.Value),
+		Version:   m.Version,
+		UpdatedAt: m.UpdatedAt,
+	}
+}
+
+// Get 查询命名空间下的条目, keys 为空时返回全部; 不存在的键不返回
+func (service *playerDataService) Get(uid int64, namespace string, keys []string) ([]PlayerData, error) {
+	result := make([]PlayerData, 0, len(keys))
+	if !namespacePattern.MatchString(namespace) {
+		return result, ErrInvalidNamespace
+	}
+	if len(keys) > service.cfg.MaxKeys {
+		return result, ErrTooManyDataKeys
+	}
+	for _, key := range keys {
+		if !dataKeyPattern.MatchString(key) {
+			return result, ErrInvalidDataKey
+		}
+	}
+	rows := make([]model.GamePlayerData, 0)
+	sess := crud.DbSess().Where("uid = ? AND namespace = ? AND deleted = ?", uid, namespace, crud.FlagNo)
+	if len(keys) > 0 {
+		sess = sess.Where("data_key IN ?", keys)
+	}
+	if err := sess.Order("data_key").Limit(service.cfg.MaxKeys).Find(&rows).Error; err != nil {
+		return result, err
+	}
+	for i := range rows {
+		result = append(result, service.toPlayerData(&rows[i]))
+	}
+	return result, nil
+}
+
+// Set 写入一个键, 见 Write
+func (service *playerDataService) Set(uid int64, namespace string, write PlayerDataWrite) (PlayerData, error) {
+	result, err := service.Write(uid, namespace, []PlayerDataWrite{write})
+	if len(result) == 0 {
+		return PlayerData{Namespace: namespace, Key: write.Key}, err
+	}
+	return result[0], err
+}
+
+// Write 在一个事务中批量写入, 全部成功或全部失败; 返回写入后的条目, 删除的条目版本为0
+// 版本冲突时返回 ErrDataVersionConflict 及冲突键的当前值(不存在时版本为0), 客户端据此合并后重试
+func (service *playerDataService) Write(uid int64, namespace string, writes []PlayerDataWrite) ([]PlayerData, error) {
+	if err := service.check(namespace, writes); err != nil {
+		return nil, err
+	}
+	result := make([]PlayerData, 0, len(writes))
+	conflicts := make([]string, 0)
+	err := crud.DbSess().Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(new(model.GamePlayerData)).Where("uid = ? AND deleted = ?", uid, crud.FlagNo).Count(&count).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, write := range writes {
+			current := service.find(tx, uid, namespace, write.Key)
+			version := int64(0)
+			if current != nil {
+				version = current.Version
+			}
+			if write.Version != nil && *write.Version != version {
+				conflicts = append(conflicts, write.Key)
+				continue
+			}
+			switch {
+			case write.Delete:
+				if current != nil {
+					if err := tx.Delete(current).Error; err != nil {
+						return err
+					}
+					count--
+				}
+				result = append(result, PlayerData{Namespace: namespace, Key: write.Key, Value: json.RawMessage("null"), UpdatedAt: now})
+			case current == nil:
+				if count++; count > int64(service.cfg.MaxKeys) {
+					return ErrTooManyDataKeys
+				}
+				m := &model.GamePlayerData{
+					Uid:       uid,
+					Namespace: namespace,
+					DataKey:   write.Key,
+					Value:     string(write.Value),
+					Version:   1,
+					Size:      len(write.Value),
+				}
+				m.SetCreatedBy(uid)
+				m.SetUpdatedBy(uid)
+				// 并发新建同一个键时唯一索引冲突, 视为版本冲突
+				if err := tx.Create(m).Error; err != nil {
+					conflicts = append(conflicts, write.Key)
+					return ErrDataVersionConflict
+				}
+				result = append(result, service.toPlayerData(m))
+			default:
+				updated := tx.Model(current).
+					Where("version = ?", current.Version).
+					Updates(map[string]interface{}{
+						"value":      string(write.Value),
+						"size":       len(write.Value),
+						"version":    current.Version + 1,
+						"updated_by": uid,
+					})
+				if updated.Error != nil {
+					return updated.Error
+				}
+				if updated.RowsAffected != 1 {
+					conflicts = append(conflicts, write.Key)
+					continue
+				}
+				current.Value, current.Version = string(write.Value), current.Version+1
+				result = append(result, service.toPlayerData(current))
+			}
+		}
+		if len(conflicts) > 0 {
+			return ErrDataVersionConflict
+		}
+		return nil
+	})
+	if err == nil {
+		return result, nil
+	}
+	if err != ErrDataVersionConflict {
+		if err != ErrTooManyDataKeys {
+			err = ErrDataSaveFailure
+		}
+		return nil, err
+	}
+	// 事务已回滚, 返回冲突键的当前值
+	current := make([]PlayerData, 0, len(conflicts))
+	for _, key := range conflicts {
+		if m := service.find(crud.DbSess(), uid, namespace, key); m != nil {
+			current = append(current, service.toPlayerData(m))
+		} else {
+			current = append(current, PlayerData{Namespace: namespace, Key: key, Value: json.RawMessage("null")})
+		}
+	}
+	return current, ErrDataVersionConflict
+}
+
+func (service *playerDataService) check(namespace string, writes []PlayerDataWrite) error {
+	if !namespacePattern.MatchString(namespace) {
+		return ErrInvalidNamespace
+	}
+	if len(writes) == 0 || len(writes) > service.cfg.MaxBatch {
+		return ErrDataBatchSize
+	}
+	keys := make(map[string]struct{}, len(writes))
+	for _, write := range writes {
+		if !dataKeyPattern.MatchString(write.Key) {
+			return ErrInvalidDataKey
+		}
+		if _, exist := keys[write.Key]; exist {
+			return ErrDuplicateDataKey
+		}
+		keys[write.Key] = struct{}{}
+		if write.Delete {
+			continue
+		}
+		if err := service.checkValue(write.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (service *playerDataService) checkValue(value []byte) error {
+	if len(value) == 0 || !json.Valid(value) {
+		return ErrInvalidDataValue
+	}
+	if len(value) > service.cfg.MaxValueBytes {
+		return ErrDataValueTooLarge
+	}
+	return nil
+}
+
+func (service *playerDataService) find(db *gorm.DB, uid int64, namespace, key string) *model.GamePlayerData {
+	rows := make([]model.GamePlayerData, 0, 1)
+	db.Where("uid = ? AND namespace = ? AND data_key = ? AND deleted = ?", uid, namespace, key, crud.FlagNo).
+		Limit(1).
+		Find(&rows)
+	if len(rows) == 0 {
+		return nil
+	}
+	return &rows[0]
+}
+
+// AdminUpdate 后台修改存档的值, 版本号加1使客户端缓存的旧版本失效
+func (service *playerDataService) AdminUpdate(id int64, value string, operator int64) (*model.GamePlayerData, error) {
+	if err := service.checkValue([]byte(value)); err != nil {
+		return nil, err
+	}
+	m, ok := dao.GamePlayerDataDao.FindByPk(id).(*model.GamePlayerData)
+	if !ok {
+		return nil, ErrDataNotFound
+	}
+	if err := crud.DbSess().Model(m).Updates(map[string]interface{}{
+		"value":      value,
+		"size":       len(value),
+		"version":    gorm.Expr("version + 1"),
+		"updated_by": operator,
+	}).Error; err != nil {
+		return nil, ErrDataSaveFailure
+	}
+	m.Value, m.Size, m.Version = value, len(value), m.Version+1
+	return m, nil
+}
+
+// AdminDelete 后台删除存档条目, 物理删除以便玩家重新创建同名的键
+func (service *playerDataService) AdminDelete(id int64) error {
+	if dao.GamePlayerDataDao.CountByPk(id) == 0 {
+		return ErrDataNotFound
+	}
+	if err := crud.DbSess().Delete(new(model.GamePlayerData), id).Error; err != nil {
+		return ErrDataSaveFailure
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3/helpers"
+	"github.com/zhouhp1295/lache/driver"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	presenceUserKey  = "K-Game-Presence-User-"
+	presenceNodeKey  = "K-Game-Presence-Node-"
+	presenceNodesKey = "K-Game-Presence-Nodes"
+	// presenceNodeSetKey 缓存使用redis时, 节点列表存放在该redis集合中
+	presenceNodeSetKey = "K-Game-Presence-NodeSet"
+
+	// PresenceRefreshInterval websocket节点刷新在线玩家的间隔
+	PresenceRefreshInterval = 30 * time.Second
+	// presenceStaleAfter 超过该时长未刷新视为离线, 用于节点宕机的情况
+	presenceStaleAfter = 3 * PresenceRefreshInterval
+	// presenceRetention 离线后保留最后在线时间的时长
+	presenceRetention = 30 * 24 * time.Hour
+)
+
+// ErrPresenceNotShared 缓存未使用redis, 在线状态只保存在websocket节点的进程内
+var ErrPresenceNotShared = errors.New("在线状态需要将[cache] TYPE设置为redis")
+
+// Presence 玩家在线状态
+type Presence struct {
+	Uid      int64     `json:"uid"`
+	Online   bool      `json:"online"`
+	Node     string    `json:"node"`
+	Conns    int       `json:"conns"`
+	OnlineAt time.Time `json:"onlineAt"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// alive 在线且节点仍在刷新
+func (p Presence) alive(now time.Time) bool {
+	return p.Online && now.Sub(p.LastSeen) < presenceStaleAfter
+}
+
+type presenceService struct {
+	// mutex 本进程内的读改写串行执行
+	mutex sync.Mutex
+}
+
+// PresenceService 玩家在线状态, 数据存放在boot.Lache, 多节点部署时缓存需使用redis
+var PresenceService = new(presenceService)
+
+// Shared 在线状态是否可被其它进程读取, 仅缓存使用redis时为true
+// 本地缓存时只有websocket节点自身能查询, http节点查询将始终为空
+func (service *presenceService) Shared() bool {
+	return boot.Redis != nil
+}
+
+func (service *presenceService) load(uid int64) (Presence, bool) {
+	var payload string
+	if !boot.Lache.GetT(fmt.Sprintf("%s%d", presenceUserKey, uid), &payload) {
+		return Presence{Uid: uid}, false
+	}
+	result := Presence{}
+	if err := jsoniter.UnmarshalFromString(payload, &result); err != nil {
+		g3.ZL().Error("decode presence failed", zap.Int64("uid", uid), zap.Error(err))
+		return Presence{Uid: uid}, false
+	}
+	return result, true
+}
+
+func (service *presenceService) save(p Presence) {
+	payload, err := jsoniter.MarshalToString(p)
+	if err != nil {
+		g3.ZL().Error("encode presence failed", zap.Int64("uid", p.Uid), zap.Error(err))
+		return
+	}
+	boot.Lache.Set(fmt.Sprintf("%s%d", presenceUserKey, p.Uid), payload, presenceRetention)
+}
+
+// Get 玩家在线状态, 节点宕机遗留的在线状态按离线返回
+func (service *presenceService) Get(uid int64) Presence {
+	p, _ := service.load(uid)
+	p.Online = p.alive(time.Now())
+	return p
+}
+
+// GetMany 批量查询, 如好友列表
+func (service *presenceService) GetMany(uids []int64) map[int64]Presence {
+	result := make(map[int64]Presence, len(uids))
+	for _, uid := range uids {
+		result[uid] = service.Get(uid)
+	}
+	return result
+}
+
+// IsOnline 是否在线
+func (service *presenceService) IsOnline(uid int64) bool {
+	return service.Get(uid).Online
+}
+
+// MarkOnline 授权成功后调用, conns 为该玩家在本节点的连接数
+// 已在线(多端登录)或 keepSince 为true(如恢复会话)时保留原上线时间
+func (service *presenceService) MarkOnline(uid int64, node string, conns int, keepSince bool) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	now := time.Now()
+	p, _ := service.load(uid)
+	if p.OnlineAt.IsZero() || !(keepSince || p.alive(now)) {
+		p.OnlineAt = now
+	}
+	p.Uid = uid
+	p.Online = true
+	p.Node = node
+	p.Conns = conns
+	p.LastSeen = now
+	service.save(p)
+}
+
+// MarkOffline 连接关闭后调用, conns 为该玩家在本节点剩余的连接数
+func (service *presenceService) MarkOffline(uid int64, node string, conns int) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	now := time.Now()
+	p, exist := service.load(uid)
+	if exist && p.alive(now) && p.Node != node {
+		// 玩家已在其它节点上线
+		return
+	}
+	p.Uid = uid
+	p.Node = node
+	p.Conns = conns
+	p.Online = conns > 0
+	p.LastSeen = now
+	service.save(p)
+}
+
+// SetNodeUsers 记录节点当前在线的玩家, 玩家上下线时调用
+func (service *presenceService) SetNodeUsers(node string, uids []int64) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.setNodeUsers(node, uids)
+}
+
+func (service *presenceService) setNodeUsers(node string, uids []int64) {
+	payload, _ := jsoniter.MarshalToString(uids)
+	boot.Lache.Set(presenceNodeKey+node, payload, presenceStaleAfter)
+	if boot.Redis != nil {
+		// 每次刷新都加入集合, 被其它节点当作过期移除后可自动恢复
+		if err := boot.Redis.SAdd(context.Background(), presenceNodeSetKey, node).Err(); err != nil {
+			g3.ZL().Error("add presence node failed", zap.String("node", node), zap.Error(err))
+		}
+		return
+	}
+	nodes := service.nodes()
+	if helpers.IndexOf[string](nodes, node) < 0 {
+		nodes = append(nodes, node)
+		service.saveNodes(nodes)
+	}
+}
+
+// RefreshNode 记录节点当前在线的玩家并刷新其最后在线时间, 由websocket节点定时调用
+func (service *presenceService) RefreshNode(node string, uids []int64) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	service.setNodeUsers(node, uids)
+	now := time.Now()
+	for _, uid := range uids {
+		p, _ := service.load(uid)
+		if p.Online && p.Node != node && p.alive(now) {
+			continue
+		}
+		p.Uid = uid
+		p.Online = true
+		p.Node = node
+		p.LastSeen = now
+		if p.OnlineAt.IsZero() {
+			p.OnlineAt = now
+		}
+		service.save(p)
+	}
+}
+
+// RemoveNode 节点停止时移除
+func (service *presenceService) RemoveNode(node string) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	boot.Lache.Delete(presenceNodeKey + node)
+	if boot.Redis != nil {
+		service.removeNodes(node)
+		return
+	}
+	nodes := service.nodes()
+	if idx := helpers.IndexOf[string](nodes, node); idx >= 0 {
+		nodes = append(nodes[:idx], nodes[idx+1:]...)
+		service.saveNodes(nodes)
+	}
+}
+
+// Nodes 已注册的websocket节点
+func (service *presenceService) Nodes() []string {
+	return service.nodes()
+}
+
+// nodes 缓存使用redis时读取集合, 多个节点并发增删互不覆盖
+// 本地缓存只有本进程读写, 列表的读改写由mutex串行
+func (service *presenceService) nodes() []string {
+	if boot.Redis != nil {
+		nodes, err := boot.Redis.SMembers(context.Background(), presenceNodeSetKey).Result()
+		if err != nil {
+			g3.ZL().Error("load presence nodes failed", zap.Error(err))
+			return make([]string, 0)
+		}
+		sort.Strings(nodes)
+		return nodes
+	}
+	var payload string
+	nodes := make([]string, 0)
+	if boot.Lache.GetT(presenceNodesKey, &payload) {
+		_ = jsoniter.UnmarshalFromString(payload, &nodes)
+	}
+	return nodes
+}
+
+func (service *presenceService) saveNodes(nodes []string) {
+	payload, _ := jsoniter.MarshalToString(nodes)
+	boot.Lache.Set(presenceNodesKey, payload, driver.NotExpired)
+}
+
+// removeNodes 从redis集合中移除节点
+func (service *presenceService) removeNodes(nodes ...string) {
+	members := make([]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		members = append(members, node)
+	}
+	if err := boot.Redis.SRem(context.Background(), presenceNodeSetKey, members...).Err(); err != nil {
+		g3.ZL().Error("remove presence nodes failed", zap.Strings("nodes", nodes), zap.Error(err))
+	}
+}
+
+// OnlineUids 所有存活节点上在线的玩家, 按uid升序
+func (service *presenceService) OnlineUids() []int64 {
+	seen := make(map[int64]bool)
+	result := make([]int64, 0)
+	stale := make([]string, 0)
+	for _, node := range service.nodes() {
+		var payload string
+		if !boot.Lache.GetT(presenceNodeKey+node, &payload) {
+			// 节点宕机未能移除自己, 其数据已过期
+			stale = append(stale, node)
+			continue
+		}
+		uids := make([]int64, 0)
+		if err := jsoniter.UnmarshalFromString(payload, &uids); err != nil {
+			continue
+		}
+		for _, uid := range uids {
+			if !seen[uid] {
+				seen[uid] = true
+				result = append(result, uid)
+			}
+		}
+	}
+	if len(stale) > 0 && boot.Redis != nil {
+		service.removeNodes(stale...)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i] < result[j]
+	})
+	return result
+}
+
+// OnlinePage 分页查询在线玩家
+func (service *presenceService) OnlinePage(page, size int) ([]Presence, int) {
+	uids := service.OnlineUids()
+	total := len(uids)
+	start := (page - 1) * size
+	if start < 0 || start >= total {
+		return make([]Presence, 0), total
+	}
+	end := start + size
+	if end > total {
+		end = total
+	}
+	rows := make([]Presence, 0, end-start)
+	for _, uid := range uids[start:end] {
+		rows = append(rows, service.Get(uid))
+	}
+	return rows, total
+}
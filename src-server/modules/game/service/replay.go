@@ -0,0 +1,98 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/dao"
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3-game/modules/game/room"
+	"github.com/zhouhp1295/g3/crud"
+	"io"
+	"path"
+	"strings"
+)
+
+// replayDir 回放在存储空间中的目录, 按开始日期分目录
+const replayDir = "replay"
+
+type replayService struct {
+}
+
+// ReplayService 对局回放
+var ReplayService = new(replayService)
+
+// PlayersColumn 参与玩家列的格式, 首尾带逗号便于按玩家模糊查询
+func (service *replayService) PlayersColumn(uids ...int64) string {
+	var sb strings.Builder
+	sb.WriteString(",")
+	for _, uid := range uids {
+		sb.WriteString(fmt.Sprintf("%d,", uid))
+	}
+	return sb.String()
+}
+
+// HasPlayer 玩家是否参与了该局
+func (service *replayService) HasPlayer(replay *model.GameReplay, uid int64) bool {
+	return strings.Contains(replay.Players, service.PlayersColumn(uid))
+}
+
+// Save 写入存储空间并记录到game_replay, 由websocket节点在房间关闭时调用
+func (service *replayService) Save(replay room.Replay, node string) (*model.GameReplay, error) {
+	filename := path.Join(replayDir, replay.StartedAt.Format("20060102"), replay.RoomId+".jsonl.gz")
+	if _, err := boot.Storager.Write(filename, bytes.NewReader(replay.Data), int64(len(replay.Data))); err != nil {
+		return nil, err
+	}
+	uids := make([]int64, 0, len(replay.Players))
+	for _, player := range replay.Players {
+		uids = append(uids, player.Uid)
+	}
+	result := ""
+	if replay.Result != nil {
+		result, _ = jsoniter.MarshalToString(replay.Result)
+	}
+	m := &model.GameReplay{
+		RoomId:    replay.RoomId,
+		Mode:      replay.Mode,
+		Logic:     replay.Logic,
+		Node:      node,
+		Players:   service.PlayersColumn(uids...),
+		TickRate:  replay.TickRate,
+		Frames:    int64(replay.Frames),
+		Duration:  replay.EndedAt.Sub(replay.StartedAt).Milliseconds(),
+		Reason:    replay.Reason,
+		Result:    result,
+		Entries:   replay.Entries,
+		Size:      int64(len(replay.Data)),
+		Path:      filename,
+		StartedAt: replay.StartedAt,
+		EndedAt:   replay.EndedAt,
+	}
+	if !dao.GameReplayDao.Insert(m, 0) {
+		return nil, errors.New("insert game replay failed")
+	}
+	return m, nil
+}
+
+// Find 按id查询, 不存在或已删除时返回nil
+func (service *replayService) Find(id int64) *model.GameReplay {
+	if replay, ok := dao.GameReplayDao.FindByPk(id).(*model.GameReplay); ok && replay.Deleted == crud.FlagNo {
+		return replay
+	}
+	return nil
+}
+
+// Download 将回放数据(gzip压缩的JSON Lines)写入w
+func (service *replayService) Download(replay *model.GameReplay, w io.Writer) error {
+	_, err := boot.Storager.Read(replay.Path, w)
+	return err
+}
+
+// Filename 下载时的文件名
+func (service *replayService) Filename(replay *model.GameReplay) string {
+	return path.Base(replay.Path)
+}
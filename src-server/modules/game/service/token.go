@@ -0,0 +1,119 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/dao"
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3/crud"
+	"go.uber.org/zap"
+	"time"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token无效")
+	ErrRefreshTokenExpired = errors.New("refresh token已过期")
+	ErrRefreshTokenRevoked = errors.New("refresh token已失效, 请重新登录")
+	// ErrRefreshTokenReused 已轮换的token被再次使用, 可能已泄露, 整个家族被吊销
+	ErrRefreshTokenReused = errors.New("refresh token已被使用, 请重新登录")
+)
+
+// refreshTokenBytes refresh token的随机字节数
+const refreshTokenBytes = 32
+
+type refreshTokenService struct {
+}
+
+// RefreshTokenService 游戏客户端的refresh token, 数据库中只保存哈希
+var RefreshTokenService = new(refreshTokenService)
+
+func (service *refreshTokenService) hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue 登录时签发, 开始新的家族
+func (service *refreshTokenService) Issue(uid int64) (string, error) {
+	dao.GameRefreshTokenDao.PurgeExpired(uid, time.Now())
+	return service.issue(uid, uuid.NewString())
+}
+
+func (service *refreshTokenService) issue(uid int64, family string) (string, error) {
+	buf := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(buf)
+	m := &model.GameRefreshToken{
+		Uid:       uid,
+		Family:    family,
+		TokenHash: service.hash(raw),
+		Used:      crud.FlagNo,
+		ExpiresAt: time.Now().Add(boot.JwtCfg.GameRefreshTTL()),
+	}
+	m.Status = crud.FlagYes
+	if !dao.GameRefreshTokenDao.Insert(m, uid) {
+		return "", errors.New("insert refresh token failed")
+	}
+	return raw, nil
+}
+
+// Rotate 使用refresh token换取新的token, 旧token随即失效;
+// 已轮换的token再次出现时视为泄露, 吊销整个家族
+func (service *refreshTokenService) Rotate(raw string) (*model.GameUser, string, error) {
+	token := dao.GameRefreshTokenDao.FindByHash(service.hash(raw))
+	if token == nil {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+	if token.Status != crud.FlagYes {
+		return nil, "", ErrRefreshTokenRevoked
+	}
+	if token.Used == crud.FlagYes || !dao.GameRefreshTokenDao.MarkUsed(token.Id, time.Now()) {
+		revoked := dao.GameRefreshTokenDao.RevokeFamily(token.Family)
+		g3.ZL().Warn("refresh token reused, family revoked",
+			zap.Int64("uid", token.Uid),
+			zap.String("family", token.Family),
+			zap.Int64("revoked", revoked))
+		return nil, "", ErrRefreshTokenReused
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return nil, "", ErrRefreshTokenExpired
+	}
+	user, ok := dao.GameUserDao.FindByPk(token.Uid).(*model.GameUser)
+	if !ok {
+		dao.GameRefreshTokenDao.RevokeFamily(token.Family)
+		return nil, "", ErrAccountNotFound
+	}
+	if user.Status != crud.FlagYes {
+		dao.GameRefreshTokenDao.RevokeFamily(token.Family)
+		return nil, "", ErrAccountDisabled
+	}
+	next, err := service.issue(token.Uid, token.Family)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, next, nil
+}
+
+// Revoke 注销, 吊销该token所在的家族
+func (service *refreshTokenService) Revoke(raw string) error {
+	token := dao.GameRefreshTokenDao.FindByHash(service.hash(raw))
+	if token == nil {
+		return ErrRefreshTokenInvalid
+	}
+	dao.GameRefreshTokenDao.RevokeFamily(token.Family)
+	return nil
+}
+
+// RevokeUser 吊销玩家的全部refresh token, 如修改密码或禁用账号后
+func (service *refreshTokenService) RevokeUser(uid int64) {
+	dao.GameRefreshTokenDao.RevokeUser(uid)
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"go.uber.org/zap"
+	"math/big"
+	"mime"
+	stdnet "net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// VerifyChannelEmail 邮件验证码
+	VerifyChannelEmail = "email"
+	// VerifyChannelPhone 短信验证码
+	VerifyChannelPhone = "phone"
+
+	verifyCodeKey     = "K-Game-VerifyCode-"
+	verifyCodeSentKey = "K-Game-VerifyCode-Sent-"
+)
+
+var (
+	ErrVerifyCodeInvalid     = errors.New("验证码错误或已过期")
+	ErrVerifyCodeTooFrequent = errors.New("验证码发送过于频繁, 请稍后再试")
+	ErrVerifyCodeUnavailable = errors.New("未配置验证码发送渠道")
+	ErrVerifyCodeSendFailure = errors.New("验证码发送失败")
+)
+
+// VerifyCodeSender 向邮箱或手机号发送验证码
+type VerifyCodeSender func(target, code string) error
+
+type verifyCodeConfig struct {
+	// TTL 验证码有效秒数
+	TTL int `ini:"TTL"`
+	// ResendInterval 同一目标两次发送的最小间隔秒数
+	ResendInterval int `ini:"RESEND_INTERVAL"`
+	// MaxAttempts 验证码最多可尝试的次数, 超过后作废
+	MaxAttempts int `ini:"MAX_ATTEMPTS"`
+	// LogOnly 仅将验证码写入日志, 用于开发环境, 未配置其它渠道时生效
+	LogOnly bool `ini:"LOG_ONLY"`
+	// SMTP 邮件验证码, SMTP_HOST 为空时不发送邮件
+	SmtpHost     string `ini:"SMTP_HOST"`
+	SmtpPort     int    `ini:"SMTP_PORT"`
+	SmtpUser     string `ini:"SMTP_USER"`
+	SmtpPassword string `ini:"SMTP_PASSWORD"`
+	SmtpFrom     string `ini:"SMTP_FROM"`
+	SmtpSubject  string `ini:"SMTP_SUBJECT"`
+}
+
+// verifyCode 缓存中的验证码, 绑定到请求发送的玩家
+type verifyCode struct {
+	Uid      int64     `json:"uid"`
+	Code     string    `json:"code"`
+	Attempts int       `json:"attempts"`
+	ExpireAt time.Time `json:"expireAt"`
+}
+
+type verifyCodeService struct {
+	cfg verifyCodeConfig
+
+	// mutex 本进程内串行校验, 避免并发尝试绕过次数限制
+	mutex   sync.Mutex
+	senders map[string]VerifyCodeSender
+}
+
+// VerifyCodeService 邮箱及手机号验证码, 存放在boot.Lache
+var VerifyCodeService = &verifyCodeService{
+	cfg:     verifyCodeConfig{TTL: 600, ResendInterval: 60, MaxAttempts: 5},
+	senders: make(map[string]VerifyCodeSender),
+}
+
+// Init 读取app.ini中的[verify_code], 在 boot.RegisterPreFunction 中调用
+func (service *verifyCodeService) Init() {
+	cfg := service.cfg
+	if err := boot.File.Section("verify_code").MapTo(&cfg); err != nil {
+		panic(err)
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 600
+	}
+	if cfg.ResendInterval < 0 {
+		cfg.ResendInterval = 0
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	service.cfg = cfg
+	if len(cfg.SmtpHost) > 0 && service.sender(VerifyChannelEmail) == nil {
+		service.SetSender(VerifyChannelEmail, service.smtpSender())
+	}
+	if cfg.LogOnly {
+		for _, channel := range []string{VerifyChannelEmail, VerifyChannelPhone} {
+			if service.sender(channel) == nil {
+				service.SetSender(channel, logVerifyCode(channel))
+			}
+		}
+	}
+	g3.ZL().Info("verify code channels loaded",
+		zap.Bool("email", service.sender(VerifyChannelEmail) != nil),
+		zap.Bool("phone", service.sender(VerifyChannelPhone) != nil))
+}
+
+// SetSender 设置发送渠道, 如接入短信服务商; 优先于配置中的渠道
+func (service *verifyCodeService) SetSender(channel string, sender VerifyCodeSender) {
+	service.mutex.Lock()
+	service.senders[channel] = sender
+	service.mutex.Unlock()
+}
+
+func (service *verifyCodeService) sender(channel string) VerifyCodeSender {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	return service.senders[channel]
+}
+
+// Send 生成验证码并发送给target, 只有uid本人可使用
+func (service *verifyCodeService) Send(channel, target string, uid int64) error {
+	sender := service.sender(channel)
+	if sender == nil {
+		return ErrVerifyCodeUnavailable
+	}
+	sentKey := verifyCodeSentKey + channel + "-" + target
+	if service.cfg.ResendInterval > 0 {
+		if _, sent := boot.Lache.Get(sentKey); sent {
+			return ErrVerifyCodeTooFrequent
+		}
+	}
+	code, err := randomVerifyCode()
+	if err != nil {
+		g3.ZL().Error("generate verify code failed", zap.Error(err))
+		return ErrVerifyCodeSendFailure
+	}
+	ttl := time.Duration(service.cfg.TTL) * time.Second
+	if !service.save(channel, target, verifyCode{Uid: uid, Code: code, ExpireAt: time.Now().Add(ttl)}) {
+		return ErrVerifyCodeSendFailure
+	}
+	if service.cfg.ResendInterval > 0 {
+		boot.Lache.Set(sentKey, "1", time.Duration(service.cfg.ResendInterval)*time.Second)
+	}
+	if err = sender(target, code); err != nil {
+		g3.ZL().Error("send verify code failed",
+			zap.String("channel", channel),
+			zap.Int64("uid", uid),
+			zap.Error(err))
+		boot.Lache.Delete(verifyCodeKey + channel + "-" + target)
+		return ErrVerifyCodeSendFailure
+	}
+	return nil
+}
+
+// Verify 校验验证码, 成功后作废; 错误次数超过限制同样作废
+func (service *verifyCodeService) Verify(channel, target string, uid int64, code string) error {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+	key := verifyCodeKey + channel + "-" + target
+	var payload string
+	if !boot.Lache.GetT(key, &payload) {
+		return ErrVerifyCodeInvalid
+	}
+	saved := verifyCode{}
+	if err := jsoniter.UnmarshalFromString(payload, &saved); err != nil || saved.Uid != uid || time.Now().After(saved.ExpireAt) {
+		return ErrVerifyCodeInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(saved.Code), []byte(strings.TrimSpace(code))) != 1 {
+		saved.Attempts++
+		if saved.Attempts >= service.cfg.MaxAttempts {
+			boot.Lache.Delete(key)
+		} else {
+			service.save(channel, target, saved)
+		}
+		return ErrVerifyCodeInvalid
+	}
+	boot.Lache.Delete(key)
+	return nil
+}
+
+func (service *verifyCodeService) save(channel, target string, code verifyCode) bool {
+	payload, err := jsoniter.MarshalToString(code)
+	if err != nil {
+		g3.ZL().Error("encode verify code failed", zap.Error(err))
+		return false
+	}
+	return boot.Lache.Set(verifyCodeKey+channel+"-"+target, payload, time.Until(code.ExpireAt))
+}
+
+// randomVerifyCode 6位数字
+func randomVerifyCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func logVerifyCode(channel string) VerifyCodeSender {
+	return func(target, code string) error {
+		g3.ZL().Warn("verify code (LOG_ONLY)",
+			zap.String("channel", channel),
+			zap.String("target", target),
+			zap.String("code", code))
+		return nil
+	}
+}
+
+// smtpSender 465端口使用TLS直连, 其它端口在服务端支持时使用STARTTLS
+func (service *verifyCodeService) smtpSender() VerifyCodeSender {
+	cfg := service.cfg
+	port := cfg.SmtpPort
+	if port <= 0 {
+		port = 465
+	}
+	from := cfg.SmtpFrom
+	if len(from) == 0 {
+		from = cfg.SmtpUser
+	}
+	subject := cfg.SmtpSubject
+	if len(subject) == 0 {
+		subject = "验证码"
+	}
+	addr := stdnet.JoinHostPort(cfg.SmtpHost, strconv.Itoa(port))
+	return func(target, code string) error {
+		message := []byte("From: " + from + "\r\n" +
+			"To: " + target + "\r\n" +
+			"Subject: " + mime.QEncoding.Encode("UTF-8", subject) + "\r\n" +
+			"MIME-Version: 1.0\r\n" +
+			"Content-Type: text/plain; charset=UTF-8\r\n\r\n" +
+			fmt.Sprintf("您的验证码为 %s, %d秒内有效。\r\n", code, cfg.TTL))
+		var auth smtp.Auth
+		if len(cfg.SmtpUser) > 0 {
+			auth = smtp.PlainAuth("", cfg.SmtpUser, cfg.SmtpPassword, cfg.SmtpHost)
+		}
+		if port != 465 {
+			return smtp.SendMail(addr, auth, from, []string{target}, message)
+		}
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: cfg.SmtpHost})
+		if err != nil {
+			return err
+		}
+		client, err := smtp.NewClient(conn, cfg.SmtpHost)
+		if err != nil {
+			_ = conn.Close()
+			return err
+		}
+		defer client.Close()
+		if auth != nil {
+			if err = client.Auth(auth); err != nil {
+				return err
+			}
+		}
+		if err = client.Mail(from); err != nil {
+			return err
+		}
+		if err = client.Rcpt(target); err != nil {
+			return err
+		}
+		writer, err := client.Data()
+		if err != nil {
+			return err
+		}
+		if _, err = writer.Write(message); err != nil {
+			return err
+		}
+		if err = writer.Close(); err != nil {
+			return err
+		}
+		return client.Quit()
+	}
+}
@@ -0,0 +1,336 @@
+// Copyright (c) 554949297@qq.com . 2022-2022 . All rights reserved
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/zhouhp1295/g3"
+	"github.com/zhouhp1295/g3-game/boot"
+	"github.com/zhouhp1295/g3-game/modules/game/dao"
+	"github.com/zhouhp1295/g3-game/modules/game/model"
+	"github.com/zhouhp1295/g3/crud"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrUnknownCurrency     = errors.New("货币不存在")
+	ErrInvalidAmount       = errors.New("金额错误")
+	ErrInsufficientBalance = errors.New("余额不足")
+	ErrInvalidLedgerReason = errors.New("原因须以小写字母开头, 由1-32位小写字母、数字或下划线组成")
+	ErrInvalidRefId        = errors.New("关联id不能超过64位")
+	ErrLedgerRemark        = errors.New("备注不能为空且不超过100字")
+	ErrSameWallet          = errors.New("不能转账给自己")
+	ErrWalletSaveFailure   = errors.New("保存钱包失败")
+	// errUnbalancedLedger 分录金额之和不为0, 属于程序错误
+	errUnbalancedLedger = errors.New("unbalanced ledger transaction")
+)
+
+const (
+	// LedgerReasonAdmin 后台调整
+	LedgerReasonAdmin = "admin"
+	// LedgerReasonTransfer 玩家间转账
+	LedgerReasonTransfer = "transfer"
+	// MaxWalletAmount 单笔交易的最大金额
+	MaxWalletAmount = 1000000000000
+	// MaxLedgerLimit 查询分录的最大条数
+	MaxLedgerLimit = 100
+)
+
+var (
+	currencyPattern     = regexp.MustCompile(`^[a-z][a-z0-9_]{0,15}$`)
+	ledgerReasonPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+)
+
+type walletConfig struct {
+	// Currencies 逗号分隔的货币代码
+	Currencies string `ini:"CURRENCIES"`
+}
+
+// LedgerMeta 交易的说明, 写入每条分录
+type LedgerMeta struct {
+	Reason string
+	// RefId 关联id, 如订单号, 可为空; 不为空时同一原因及关联id对同一玩家的同一货币只执行一次
+	RefId string
+	// Remark 备注, 后台调整时必填
+	Remark string
+	// Operator 后台用户id, 游戏逻辑为0
+	Operator int64
+}
+
+// WalletBalance 一种货币的余额
+type WalletBalance struct {
+	Currency string `json:"currency"`
+	Balance  int64  `json:"balance"`
+}
+
+// LedgerEntry 一条分录
+type LedgerEntry struct {
+	Uid          int64  `json:"uid"`
+	Currency     string `json:"currency"`
+	Amount       int64  `json:"amount"`
+	BalanceAfter int64  `json:"balanceAfter"`
+}
+
+// LedgerTx 一笔交易, Entries 不包括系统账户的分录
+// Replayed 为true时关联id已执行过, 返回的是当时的交易
+type LedgerTx struct {
+	TxId     string        `json:"txId"`
+	Replayed bool          `json:"replayed"`
+	Entries  []LedgerEntry `json:"entries"`
+}
+
+type walletService struct {
+	currencies []string
+}
+
+// WalletService 玩家钱包, 每笔交易在事务中按复式记账写入账本, 玩家余额不能为负
+var WalletService = new(walletService)
+
+// Init 读取app.ini中的[wallet], 在 boot.RegisterPreFunction 中调用
+func (service *walletService) Init() {
+	cfg := walletConfig{}
+	if err := boot.File.Section("wallet").MapTo(&cfg); err != nil {
+		panic(err)
+	}
+	currencies := make([]string, 0)
+	for _, currency := range strings.Split(cfg.Currencies, ",") {
+		if currency = strings.TrimSpace(currency); len(currency) == 0 {
+			continue
+		}
+		if !currencyPattern.MatchString(currency) {
+			panic("invalid wallet currency: " + currency)
+		}
+		currencies = append(currencies, currency)
+	}
+	service.currencies = currencies
+	g3.ZL().Info("wallet currencies loaded", zap.Strings("currencies", currencies))
+}
+
+// Currencies 所有货币
+func (service *walletService) Currencies() []string {
+	return service.currencies
+}
+
+func (service *walletService) checkCurrency(currency string) error {
+	for _, c := range service.currencies {
+		if c == currency {
+			return nil
+		}
+	}
+	return ErrUnknownCurrency
+}
+
+// Balances 玩家所有货币的余额, 没有钱包的货币余额为0
+func (service *walletService) Balances(uid int64) []WalletBalance {
+	wallets := dao.GameWalletDao.FindByUid(uid)
+	balances := make(map[string]int64, len(wallets))
+	for _, wallet := range wallets {
+		balances[wallet.Currency] = wallet.Balance
+	}
+	result := make([]WalletBalance, 0, len(service.currencies))
+	for _, currency := range service.currencies {
+		result = append(result, WalletBalance{Currency: currency, Balance: balances[currency]})
+	}
+	return result
+}
+
+// Ledger 玩家最近的分录, currency 为空时查询所有货币
+func (service *walletService) Ledger(uid int64, currency string, limit int) []model.GameLedger {
+	if limit <= 0 || limit > MaxLedgerLimit {
+		limit = MaxLedgerLimit
+	}
+	return dao.GameLedgerDao.FindRecent(uid, currency, limit)
+}
+
+// Credit 由系统账户转入玩家钱包
+func (service *walletService) Credit(uid int64, currency string, amount int64, meta LedgerMeta) (LedgerTx, error) {
+	return service.post(currency, amount, model.WalletSystemUid, uid, meta)
+}
+
+// Debit 由玩家钱包转入系统账户, 余额不足时失败
+func (service *walletService) Debit(uid int64, currency string, amount int64, meta LedgerMeta) (LedgerTx, error) {
+	return service.post(currency, amount, uid, model.WalletSystemUid, meta)
+}
+
+// Transfer 玩家间转账, from 余额不足时失败
+func (service *walletService) Transfer(from, to int64, currency string, amount int64, meta LedgerMeta) (LedgerTx, error) {
+	if from == to {
+		return LedgerTx{Entries: make([]LedgerEntry, 0)}, ErrSameWallet
+	}
+	return service.post(currency, amount, from, to, meta)
+}
+
+// AdminAdjust 后台调整余额, amount 为正时增加, 为负时扣除; 备注必填并写入账本
+func (service *walletService) AdminAdjust(uid int64, currency string, amount int64, remark string, operator int64) (LedgerTx, error) {
+	meta := LedgerMeta{Reason: LedgerReasonAdmin, Remark: strings.TrimSpace(remark), Operator: operator}
+	if len(meta.Remark) == 0 || utf8.RuneCountInString(meta.Remark) > 100 {
+		return LedgerTx{Entries: make([]LedgerEntry, 0)}, ErrLedgerRemark
+	}
+	if amount < 0 {
+		return service.Debit(uid, currency, -amount, meta)
+	}
+	return service.Credit(uid, currency, amount, meta)
+}
+
+// post 从from转amount到to, 两条分录在同一事务中写入
+func (service *walletService) post(currency string, amount int64, from, to int64, meta LedgerMeta) (LedgerTx, error) {
+	result := LedgerTx{TxId: uuid.NewString(), Entries: make([]LedgerEntry, 0, 2)}
+	if err := service.checkCurrency(currency); err != nil {
+		return result, err
+	}
+	if amount <= 0 || amount > MaxWalletAmount {
+		return result, ErrInvalidAmount
+	}
+	if !ledgerReasonPattern.MatchString(meta.Reason) {
+		return result, ErrInvalidLedgerReason
+	}
+	if len(meta.RefId) > 64 {
+		return result, ErrInvalidRefId
+	}
+	if utf8.RuneCountInString(meta.Remark) > 100 {
+		return result, ErrLedgerRemark
+	}
+	if replayed, ok := service.replay(currency, from, to, meta); ok {
+		return replayed, nil
+	}
+	entries := []LedgerEntry{
+		{Uid: from, Currency: currency, Amount: -amount},
+		{Uid: to, Currency: currency, Amount: amount},
+	}
+	// 按玩家id顺序更新, 以免并发的反向转账互相等待
+	// 系统账户是所有发放及扣除共用的热点行, 最后更新以缩短其行锁的持有时间
+	sort.Slice(entries, func(i, j int) bool {
+		iSystem, jSystem := entries[i].Uid == model.WalletSystemUid, entries[j].Uid == model.WalletSystemUid
+		if iSystem != jSystem {
+			return jSystem
+		}
+		return entries[i].Uid < entries[j].Uid
+	})
+	err := crud.DbSess().Transaction(func(tx *gorm.DB) error {
+		return service.apply(tx, result.TxId, entries, meta)
+	})
+	if err != nil {
+		if err == ErrInsufficientBalance {
+			return result, err
+		}
+		// 并发执行同一关联id时唯一索引冲突, 返回已执行的交易
+		if replayed, ok := service.replay(currency, from, to, meta); ok {
+			return replayed, nil
+		}
+		g3.ZL().Error("post ledger transaction failed",
+			zap.String("txId", result.TxId),
+			zap.Int64("from", from),
+			zap.Int64("to", to),
+			zap.String("currency", currency),
+			zap.Error(err))
+		return result, ErrWalletSaveFailure
+	}
+	for _, entry := range entries {
+		if entry.Uid != model.WalletSystemUid {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	return result, nil
+}
+
+// apply 在事务中更新余额并写入分录, 玩家钱包以条件更新保证余额不为负
+func (service *walletService) apply(tx *gorm.DB, txId string, entries []LedgerEntry, meta LedgerMeta) error {
+	sum := int64(0)
+	for _, entry := range entries {
+		sum += entry.Amount
+	}
+	if sum != 0 {
+		return errUnbalancedLedger
+	}
+	for i := range entries {
+		entry := &entries[i]
+		wallet := &model.GameWallet{Uid: entry.Uid, Currency: entry.Currency}
+		wallet.SetCreatedBy(meta.Operator)
+		wallet.SetUpdatedBy(meta.Operator)
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(wallet).Error; err != nil {
+			return err
+		}
+		sess := tx.Model(new(model.GameWallet)).Where("uid = ? AND currency = ?", entry.Uid, entry.Currency)
+		if entry.Uid != model.WalletSystemUid && entry.Amount < 0 {
+			sess = sess.Where("balance >= ?", -entry.Amount)
+		}
+		updated := sess.Updates(map[string]interface{}{
+			"balance":    gorm.Expr("balance + ?", entry.Amount),
+			"updated_by": meta.Operator,
+		})
+		if updated.Error != nil {
+			return updated.Error
+		}
+		if updated.RowsAffected != 1 {
+			return ErrInsufficientBalance
+		}
+		// 事务中已持有该行的写锁, 读到的即本次更新后的余额
+		if err := tx.Model(new(model.GameWallet)).
+			Where("uid = ? AND currency = ?", entry.Uid, entry.Currency).
+			Pluck("balance", &entry.BalanceAfter).Error; err != nil {
+			return err
+		}
+		ledger := &model.GameLedger{
+			TxId:         txId,
+			Uid:          entry.Uid,
+			Currency:     entry.Currency,
+			Amount:       entry.Amount,
+			BalanceAfter: entry.BalanceAfter,
+			Reason:       meta.Reason,
+			RefId:        meta.RefId,
+			Operator:     meta.Operator,
+		}
+		ledger.RefKey = refKey(entry.Uid, entry.Currency, meta)
+		ledger.Remark = meta.Remark
+		ledger.SetCreatedBy(meta.Operator)
+		ledger.SetUpdatedBy(meta.Operator)
+		if err := tx.Create(ledger).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refKey 分录的幂等键, 系统账户的分录及关联id为空时为nil
+func refKey(uid int64, currency string, meta LedgerMeta) *string {
+	if len(meta.RefId) == 0 || uid == model.WalletSystemUid {
+		return nil
+	}
+	key := fmt.Sprintf("%s/%d/%s/%s", currency, uid, meta.Reason, meta.RefId)
+	return &key
+}
+
+// replay 查找关联id已执行过的交易
+func (service *walletService) replay(currency string, from, to int64, meta LedgerMeta) (LedgerTx, bool) {
+	key := refKey(from, currency, meta)
+	if key == nil {
+		key = refKey(to, currency, meta)
+	}
+	if key == nil {
+		return LedgerTx{}, false
+	}
+	ledger := dao.GameLedgerDao.FindByRefKey(*key)
+	if ledger == nil {
+		return LedgerTx{}, false
+	}
+	result := LedgerTx{TxId: ledger.TxId, Replayed: true, Entries: make([]LedgerEntry, 0, 2)}
+	for _, row := range dao.GameLedgerDao.FindByTxId(ledger.TxId) {
+		if row.Uid != model.WalletSystemUid {
+			result.Entries = append(result.Entries, LedgerEntry{
+				Uid:          row.Uid,
+				Currency:     row.Currency,
+				Amount:       row.Amount,
+				BalanceAfter: row.BalanceAfter,
+			})
+		}
+	}
+	return result, true
+}